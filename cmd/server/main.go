@@ -1,6 +1,7 @@
 package main
 
 import (
+	"database/sql"
 	"flag"
 	"fmt"
 	"log"
@@ -20,8 +21,10 @@ import (
 	"github.com/golang-cafe/job-board/internal/email"
 	"github.com/golang-cafe/job-board/internal/handler"
 	"github.com/golang-cafe/job-board/internal/job"
+	"github.com/golang-cafe/job-board/internal/middleware"
 	"github.com/golang-cafe/job-board/internal/payment"
 	"github.com/golang-cafe/job-board/internal/recruiter"
+	"github.com/golang-cafe/job-board/internal/search"
 	"github.com/golang-cafe/job-board/internal/server"
 	"github.com/golang-cafe/job-board/internal/template"
 	"github.com/golang-cafe/job-board/internal/user"
@@ -50,6 +53,30 @@ func main() {
 	if err != nil {
 		log.Fatalf("unable to connect to postgres: %v", err)
 	}
+	user.ConfigurePool(conn, user.PoolConfig{
+		MaxOpenConns:    cfg.DatabaseMaxOpenConns,
+		MaxIdleConns:    cfg.DatabaseMaxIdleConns,
+		ConnMaxLifetime: cfg.DatabaseConnMaxLifetime,
+	})
+	var replica *sql.DB
+	if cfg.DatabaseReplicaHost != "" {
+		replica, err = database.GetDbConn(
+			cfg.DatabaseUser,
+			cfg.DatabasePassword,
+			cfg.DatabaseReplicaHost,
+			cfg.DatabasePort,
+			cfg.DatabaseName,
+			cfg.DatabaseSSLMode,
+		)
+		if err != nil {
+			log.Fatalf("unable to connect to postgres replica: %v", err)
+		}
+		user.ConfigurePool(replica, user.PoolConfig{
+			MaxOpenConns:    cfg.DatabaseMaxOpenConns,
+			MaxIdleConns:    cfg.DatabaseMaxIdleConns,
+			ConnMaxLifetime: cfg.DatabaseConnMaxLifetime,
+		})
+	}
 	emailClient, err := email.NewClient(
 		cfg.Email2APIKey,
 		cfg.SupportEmail,
@@ -60,40 +87,123 @@ func main() {
 	if err != nil {
 		log.Fatalf("unable to connect to sparkpost API: %v", err)
 	}
-	sessionStore := sessions.NewCookieStore(cfg.SessionKey)
-	robotsTxtContent, err := os.ReadFile("./static/robots.txt")
+	middleware.BrowserSessionDuration = cfg.SessionTTL
+	middleware.MachineSignedRequests = cfg.MachineSignedRequests
+	middleware.AccessLogSampleRate = cfg.AccessLogSampleRate
+	middleware.AccessLogSlowThreshold = cfg.AccessLogSlowThreshold
+	middleware.AccessLogHeaders = cfg.AccessLogHeaders
+	middleware.AccessLogDeniedHeaders = append(middleware.AccessLogDeniedHeaders, cfg.AccessLogDeniedHeaders...)
+	middleware.AuthBreaker = middleware.NewCircuitBreaker(cfg.AuthBreakerThreshold, cfg.AuthBreakerWindow, cfg.AuthBreakerCooldown)
+	middleware.AuthVerifyTimeout = cfg.AuthVerifyTimeout
+	middleware.AuthRedirectPath = cfg.AuthRedirectPath
+	middleware.SiteHost = cfg.SiteHost
+	middleware.TrustedProxies, err = middleware.ParseTrustedProxies(cfg.TrustedProxies)
 	if err != nil {
-		log.Fatalf("unable to read robots.txt placeholder file: %w", err)
+		log.Fatalf("unable to parse TRUSTED_PROXIES: %v", err)
+	}
+	if cfg.Env != "dev" && len(middleware.TrustedProxies) == 0 {
+		log.Println("warning: TRUSTED_PROXIES is empty, X-Forwarded-* headers are ignored and plain http requests are redirected to https")
+	}
+	if cfg.SchemeHeaders != nil {
+		middleware.SchemeHeaders = cfg.SchemeHeaders
+	}
+	middleware.BetaGatePublicPaths = cfg.BetaPublicPaths
+	var captcha middleware.CaptchaVerifier
+	if cfg.CaptchaSecret != "" {
+		verifyURL := cfg.CaptchaVerifyURL
+		if verifyURL == "" {
+			verifyURL = middleware.HCaptchaVerifyURL
+		}
+		captcha = middleware.NewSiteVerifier(verifyURL, cfg.CaptchaSecret)
+	}
+	sessionCfg := middleware.SessionConfig{
+		Name:               cfg.SessionCookieName,
+		Secure:             cfg.Env != "dev",
+		Embedded:           cfg.SessionEmbedded,
+		EmbeddedCookieName: cfg.EmbeddedCookieName,
 	}
-	securityTxtContent, err := os.ReadFile("./static/security.txt")
+	middleware.SessionCookieName = sessionCfg.CookieName()
+	middleware.LegacySessionCookieNames = cfg.SessionLegacyCookieNames
+	var sessionStore sessions.Store = middleware.NewSessionStore(cfg.SessionKey, sessionCfg)
+	if cfg.SessionStore == "postgres" {
+		sessionStore = middleware.NewPostgresSessionStore(conn, sessionCfg, cfg.SessionKey)
+	}
+	robotsTxtContent, err := os.ReadFile("./static/robots.txt")
 	if err != nil {
-		log.Fatalf("unable to read security.txt placeholder file: %w", err)
+		log.Fatalf("unable to read robots.txt placeholder file: %w", err)
 	}
 
 	devRepo := developer.NewRepository(conn)
 	recRepo := recruiter.NewRepository(conn)
 	blogRepo := blog.NewRepository(conn)
-	userRepo := user.NewRepository(conn)
+	userRepo := user.NewRepository(conn).WithProfiles(user.NewProfileRepository(conn)).WithReplica(replica)
+	if cfg.UserCacheTTL > 0 && cfg.UserCacheSize > 0 {
+		userRepo.WithCache(user.NewCache(cfg.UserCacheTTL, cfg.UserCacheSize))
+	}
+	if cfg.UserWebhookURL != "" {
+		userRepo.WithDispatcher(user.NewWebhookDispatcher(cfg.UserWebhookURL, cfg.UserWebhookSecret))
+	}
+	middleware.EmailVerifiedSyncer = userRepo
 	companyRepo := company.NewRepository(conn)
 	jobRepo := job.NewRepository(conn)
+	if cfg.SearchIndexURL != "" {
+		indexSyncer := search.NewAsyncSyncer(search.NewOpenSearchIndexer(cfg.SearchIndexURL), cfg.SearchIndexQueueSize)
+		userRepo.WithIndexSyncer(indexSyncer)
+		jobRepo.WithIndexSyncer(indexSyncer)
+	}
 	paymentRepo := payment.NewRepository(cfg.StripeKey, cfg.SiteName, cfg.SiteHost, cfg.URLProtocol)
 
+	tmpl := template.NewTemplate(cfg.Env, cfg.Env != "dev")
+	if err := template.LoadIcons(os.DirFS("./static/icons")); err != nil {
+		log.Fatalf("unable to load icons: %v", err)
+	}
+	template.ConfigurePageTitle(cfg.SiteName, cfg.PageTitleSeparator)
+	if err := template.LoadAssetHashes(os.DirFS("./static/assets"), "/s/"); err != nil {
+		log.Fatalf("unable to hash assets: %v", err)
+	}
+	if err := template.LoadAssetHashes(os.DirFS("./static/scripts"), "/scripts/"); err != nil {
+		log.Fatalf("unable to hash scripts: %v", err)
+	}
+	if err := tmpl.Verify(
+		"error.html", "landing.html", "job.html", "auth.html", "auto-login.html", "profile-home.html",
+		"post-a-job.html", "post-a-job-success.html", "post-a-job-error.html", "post-a-job-without-payment.html",
+		"edit.html", "manage.html", "payment.html", "apply-message.html", "list-jobs-admin.html",
+		"companies.html", "company.html", "developers.html", "view-developer-profile.html",
+		"edit-developer-profile.html", "edit-recruiter-profile.html", "submit-recruiter-profile.html",
+		"create-jobseeker-account.html", "salary-explorer.html", "newsletter.html", "support.html",
+		"about.html", "privacy-policy.html", "terms-of-service.html",
+		"create-blogpost.html", "edit-blogpost.html", "list-blogposts.html", "user-blogposts.html", "view-blogpost.html",
+		"jobs-rss.html", "jobs-atom.html", "sitemap.html", "sitemap-index.html", "coming-soon.html",
+	); err != nil {
+		log.Fatalf("unable to start: %v", err)
+	}
+	template.ApplicantBadgeFloor = cfg.ApplicantBadgeFloor
+	template.ApplicantBadgeCap = cfg.ApplicantBadgeCap
+	middleware.SetErrorResponder(middleware.NewErrorResponder(tmpl, "error.html"))
+
+	router := mux.NewRouter()
+	router.NotFoundHandler = middleware.NotFoundHandler(tmpl)
 	svr := server.NewServer(
 		cfg,
 		conn,
-		mux.NewRouter(),
-		template.NewTemplate(cfg.Env),
+		router,
+		tmpl,
 		emailClient,
 		sessionStore,
 	)
+	userRepo.WithHealth(svr.DatabaseHealth())
+	middleware.TokenVersion = userRepo.TokenVersion
+	middleware.ForceReauthAt = userRepo.ForceReauthAt
 
+	svr.RegisterRoute("/healthz", handler.HealthzHandler(svr, userRepo), []string{"GET"})
 	svr.RegisterRoute("/sitemap.xml", handler.SitemapIndexHandler(svr), []string{"GET"})
 	svr.RegisterRoute("/sitemap-{number}.xml", handler.SitemapHandler(svr), []string{"GET"})
 	svr.RegisterRoute("/robots.txt", handler.RobotsTXTHandler(svr, robotsTxtContent), []string{"GET"})
-	svr.RegisterRoute("/.well-known/security.txt", handler.WellKnownSecurityHandler(svr, securityTxtContent), []string{"GET"})
+	svr.RegisterRoute("/.well-known/security.txt", handler.WellKnownSecurityHandler(svr), []string{"GET"})
+	svr.RegisterPathPrefix("/.well-known/", middleware.WellKnownFileServer(cfg.WellKnownDir), []string{"GET"})
 
-	svr.RegisterPathPrefix("/s/", http.StripPrefix("/s/", http.FileServer(http.Dir("./static/assets"))), []string{"GET"})
-	svr.RegisterPathPrefix("/scripts/", http.StripPrefix("/scripts/", http.FileServer(http.Dir("./static/scripts"))), []string{"GET"})
+	svr.RegisterPathPrefix("/s/", http.StripPrefix("/s/", middleware.PrecompressedFileServer(http.Dir("./static/assets"))), []string{"GET"})
+	svr.RegisterPathPrefix("/scripts/", http.StripPrefix("/scripts/", middleware.PrecompressedFileServer(http.Dir("./static/scripts"))), []string{"GET"})
 
 	svr.RegisterRoute("/about", handler.AboutPageHandler(svr), []string{"GET"})
 	svr.RegisterRoute("/privacy-policy", handler.PrivacyPolicyPageHandler(svr), []string{"GET"})
@@ -162,9 +272,13 @@ func main() {
 	svr.RegisterRoute("/x/sdm", handler.SaveDeveloperMetadataHandler(svr, devRepo), []string{"POST"})
 	svr.RegisterRoute("/x/udp", handler.UpdateDeveloperProfileHandler(svr, devRepo), []string{"POST"})
 	svr.RegisterRoute("/x/udm", handler.UpdateDeveloperMetadataHandler(svr, devRepo), []string{"POST"})
+	svr.RegisterRoute("/api/me", handler.CurrentUserHandler(svr, userRepo), []string{"GET"})
+	svr.RegisterRoute("/x/preferred-currency", handler.SetPreferredCurrencyHandler(svr, userRepo), []string{"POST"})
+	svr.RegisterRoute("/x/account/delete", handler.RequestAccountDeletionHandler(svr, userRepo), []string{"POST"})
+	svr.RegisterRoute("/x/account/delete/{token}", handler.ConfirmAccountDeletionHandler(svr, userRepo), []string{"GET", "POST"})
 	svr.RegisterRoute("/x/ddm", handler.DeleteDeveloperMetadataHandler(svr, devRepo), []string{"POST"})
 	svr.RegisterRoute("/x/ddp", handler.DeleteDeveloperProfileHandler(svr, devRepo, userRepo), []string{"POST"})
-	svr.RegisterRoute("/x/smdp/{id}", handler.SendMessageDeveloperProfileHandler(svr, devRepo), []string{"POST"})
+	svr.RegisterRoute("/x/smdp/{id}", middleware.CaptchaMiddleware(captcha, handler.SendMessageDeveloperProfileHandler(svr, devRepo)), []string{"POST"})
 	svr.RegisterRoute("/developer/{slug}", handler.ViewDeveloperProfileHandler(svr, devRepo, recRepo), []string{"GET"})
 	svr.RegisterRoute("/x/auth/message/{id}", handler.DeliverMessageDeveloperProfileHandler(svr, devRepo), []string{"GET"})
 
@@ -209,6 +323,7 @@ func main() {
 
 	// newsletter member save
 	svr.RegisterRoute("/x/email/subscribe", handler.AddEmailSubscriberHandler(svr), []string{"GET"})
+	svr.RegisterRoute("/x/beta/access", handler.RequestBetaAccessHandler(svr), []string{"POST"})
 	svr.RegisterRoute("/x/email/unsubscribe", handler.RemoveEmailSubscriberHandler(svr), []string{"GET"})
 	svr.RegisterRoute("/x/email/confirm/{token}", handler.ConfirmEmailSubscriberHandler(svr), []string{"GET"})
 
@@ -219,7 +334,7 @@ func main() {
 	svr.RegisterRoute("/apply/{token}", handler.ApplyToJobConfirmation(svr, jobRepo), []string{"GET"})
 
 	// submit job post
-	svr.RegisterRoute("/x/s", handler.SubmitJobPostPageHandler(svr, jobRepo, paymentRepo), []string{"POST"})
+	svr.RegisterRoute("/x/s", middleware.DenyPreviewMiddleware(handler.SubmitJobPostPageHandler(svr, jobRepo, paymentRepo)), []string{"POST"})
 
 	// re-submit job post payment for upsell
 	svr.RegisterRoute("/x/s/upsell", handler.SubmitJobPostPaymentUpsellPageHandler(svr, jobRepo, paymentRepo), []string{"POST"})
@@ -239,7 +354,7 @@ func main() {
 	svr.RegisterRoute("/x/stripe/checkout/completed", handler.StripePaymentConfirmationWebhookHandler(svr, jobRepo, recRepo), []string{"POST"})
 
 	// send feedback message
-	svr.RegisterRoute("/x/s/message", handler.SendFeedbackMessage(svr), []string{"POST"})
+	svr.RegisterRoute("/x/s/message", middleware.CaptchaMiddleware(captcha, handler.SendFeedbackMessage(svr)), []string{"POST"})
 
 	// track job clickout
 	svr.RegisterRoute("/x/j/c/{id}", handler.TrackJobClickoutPageHandler(svr, jobRepo), []string{"GET"})
@@ -256,6 +371,12 @@ func main() {
 	// view job by slug
 	svr.RegisterRoute("/job/{slug}", handler.JobBySlugPageHandler(svr, jobRepo), []string{"GET"})
 
+	// @public: preview a draft job with a short lived preview token
+	svr.RegisterRoute("/preview/job/{id}", handler.JobPreviewPageHandler(svr, jobRepo), []string{"GET"})
+
+	// @private: issue a preview token for one of the signed in user's draft jobs
+	svr.RegisterRoute("/x/j/{id}/preview", handler.CreateJobPreviewTokenHandler(svr, jobRepo), []string{"POST"})
+
 	// view company by slug
 	svr.RegisterRoute("/company/{slug}", handler.CompanyBySlugPageHandler(svr, companyRepo, jobRepo), []string{"GET"})
 
@@ -265,10 +386,14 @@ func main() {
 
 	// sign on page
 	svr.RegisterRoute("/auth", handler.GetAuthPageHandler(svr), []string{"GET"})
+	// deployments mounting the sign in page elsewhere get it served there too, unless it lives on another site
+	if authPath := strings.SplitN(cfg.AuthRedirectPath, "?", 2)[0]; authPath != "/auth" && middleware.SafeNextPath(authPath) {
+		svr.RegisterRoute(authPath, handler.GetAuthPageHandler(svr), []string{"GET"})
+	}
 	svr.RegisterRoute("/autologin", handler.GetAutologinPageHandler(svr), []string{"GET"})
 
 	// sign on email link
-	svr.RegisterRoute("/x/auth/link", handler.RequestTokenSignOn(svr, userRepo), []string{"POST"})
+	svr.RegisterRoute("/x/auth/link", middleware.CaptchaMiddleware(captcha, handler.RequestTokenSignOn(svr, userRepo)), []string{"POST"})
 	svr.RegisterRoute("/x/signin", handler.FirebaseSignin(svr, userRepo), []string{"POST"})
 	svr.RegisterRoute("/x/auth/{token}", handler.VerifyTokenSignOn(svr, userRepo, devRepo, recRepo, cfg.AdminEmail), []string{"GET"})
 
@@ -467,6 +592,9 @@ func main() {
 
 	// RSS feed
 	svr.RegisterRoute("/rss", handler.ServeRSSFeed(svr, jobRepo), []string{"GET"})
+	svr.RegisterRoute("/feed/jobs", handler.JobsFeedHandler(svr, jobRepo, ""), []string{"GET"})
+	svr.RegisterRoute("/feed/jobs.rss", handler.JobsFeedHandler(svr, jobRepo, handler.FeedRSS), []string{"GET"})
+	svr.RegisterRoute("/feed/jobs.atom", handler.JobsFeedHandler(svr, jobRepo, handler.FeedAtom), []string{"GET"})
 
 	//
 	// admin routes
@@ -494,5 +622,17 @@ func main() {
 	// @admin: permanently delete job and all child resources (image, clickouts, edit token)
 	svr.RegisterRoute("/x/j/d", handler.PermanentlyDeleteJobByToken(svr, jobRepo), []string{"POST"})
 
+	// @admin: browse public pages as a signed out visitor, ?on=1 to start and ?on=0 to stop
+	svr.RegisterRoute("/x/view-as-public", handler.ViewAsPublicHandler(svr), []string{"POST"})
+
+	// @admin: stop impersonating and return to the admin account
+	svr.RegisterRoute("/x/impersonate/stop", handler.StopImpersonationHandler(svr, userRepo), []string{"POST"})
+
+	// @admin: act as another (non admin) user to debug their account
+	svr.RegisterRoute("/x/impersonate/{id}", handler.ImpersonateUserHandler(svr, userRepo), []string{"POST"})
+
+	// @admin: download users as csv
+	svr.RegisterRoute("/manage/users/export", handler.ExportUsersCSVHandler(svr, userRepo), []string{"GET"})
+
 	log.Fatal(svr.Run())
 }
@@ -12,18 +12,20 @@ require (
 	github.com/alecthomas/template v0.0.0-20160405071501-a0175ee3bccc
 	github.com/allegro/bigcache/v2 v2.2.5 // indirect
 	github.com/allegro/bigcache/v3 v3.0.0
+	github.com/andybalholm/cascadia v1.1.0
+	github.com/aymerick/douceur v0.2.0
 	github.com/azr/backoff v0.0.0-20160115115103-53511d3c7330 // indirect
 	github.com/bot-api/telegram v0.0.0-20170115211335-b7abf87c449e
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
-	github.com/dustin/go-humanize v1.0.0
+	github.com/dustin/go-humanize v1.0.1
 	github.com/dustin/go-jsonpointer v0.0.0-20160814072949-ba0abeacc3dc // indirect
 	github.com/dustin/gojson v0.0.0-20160307161227-2e71ec9dd5ad // indirect
 	github.com/fogleman/gg v1.3.0
 	github.com/fsnotify/fsnotify v1.6.0
 	github.com/garyburd/go-oauth v0.0.0-20180319155456-bca2e7f09a17 // indirect
 	github.com/golang/groupcache v0.0.0-20210331224755-41bb18bfe9da // indirect
-	github.com/gorilla/feeds v1.1.1
 	github.com/gorilla/mux v1.7.3
+	github.com/gorilla/securecookie v1.1.1
 	github.com/gorilla/sessions v1.2.0
 	github.com/gosimple/slug v1.3.0
 	github.com/joho/godotenv v1.5.1
@@ -37,11 +39,16 @@ require (
 	github.com/rs/zerolog v1.20.0
 	github.com/segmentio/ksuid v1.0.2
 	github.com/shurcooL/sanitized_anchor_name v0.0.0-20170918181015-86672fcb3f95 // indirect
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 	github.com/snabb/diagio v0.0.0-20170305182244-0ef68e3dbf01 // indirect
-	github.com/snabb/sitemap v0.0.0-20171225173334-36baa8b39ef4
 	github.com/stripe/stripe-go v62.10.0+incompatible
+	github.com/tdewolff/minify/v2 v2.12.9
+	go.opentelemetry.io/otel v1.4.1
+	go.opentelemetry.io/otel/trace v1.4.1
 	golang.org/x/crypto v0.8.0
 	golang.org/x/image v0.5.0 // indirect
+	golang.org/x/net v0.9.0
+	golang.org/x/text v0.9.0
 	google.golang.org/api v0.122.0
 	gopkg.in/russross/blackfriday.v2 v2.0.0
 	gopkg.in/stretchr/testify.v1 v1.2.2 // indirect
@@ -6,6 +6,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -76,6 +77,66 @@ type Config struct {
 	FirebaseMessagingSenderId string
 	FirebaseAppId             string
 	FirebaseMeasurementId     string
+	SessionEmbedded           bool          // use SameSite=None session cookies for iframe embeds, requires https
+	EmbeddedCookieName        string        // session cookie name used when SessionEmbedded is set
+	SessionStore              string        // either cookie (default) or postgres for server side sessions
+	SessionTTL                time.Duration // lifetime of sessions without "remember me"
+	SessionRenewalThreshold   float64       // fraction of the session lifetime left under which the jwt is re-issued, 0 disables renewal
+	RateLimitAnonymous        int           // requests per minute per IP for visitors that aren't signed in, 0 disables
+	RateLimitAuthenticated    int           // requests per minute per signed in user, 0 disables
+	UserWebhookURL            string        // receives a signed POST for every new user, empty disables
+	UserWebhookSecret         []byte
+	SearchIndexURL            string        // OpenSearch index kept in sync with users and jobs, empty disables
+	SearchIndexQueueSize      int           // updates buffered for the search index before new ones are dropped
+	ReferrerPolicy            string        // empty uses the middleware default
+	PermissionsPolicy         string        // empty uses the middleware default
+	NoIndexPaths              []string      // path prefixes sent X-Robots-Tag: noindex, e.g. private profile pages
+	MachineSignedRequests     bool          // require HMAC signed machine requests instead of the static MACHINE_TOKEN header
+	DatabaseMaxOpenConns      int           // 0 uses the pool default
+	DatabaseMaxIdleConns      int           // 0 uses the pool default
+	DatabaseConnMaxLifetime   time.Duration // 0 uses the pool default
+	DatabaseQueryTimeout      time.Duration // bound on single queries made while serving a request, 0 disables
+	UserCacheTTL              time.Duration // how long looked up users are cached, 0 disables the user cache
+	UserCacheSize             int           // most users kept in the user cache, 0 disables it
+	AllowedHosts              []string      // hosts accepted besides SITE_HOST and www.SITE_HOST, *.example.com matches subdomains
+	SessionCookieName         string        // empty uses the middleware default
+	SessionLegacyCookieNames  []string      // previous session cookie names still read while sessions migrate
+	Locales                   []string      // locales the site can be shown in, matched against Accept-Language
+	DefaultLocale             string
+	AccessLogSampleRate       float64       // fraction of 2xx/3xx requests logged, errors and slow requests are always logged
+	AccessLogSlowThreshold    time.Duration // requests taking longer are always logged
+	MaxURILength              int           // longer request URLs get a 414, 0 disables
+	SecurityContacts          []string      // Contact lines of security.txt, defaults to mailto:SUPPORT_EMAIL
+	SecurityPolicyURL         string        // Policy line of security.txt, empty omits it
+	SecurityTxtExpires        time.Time     // zero keeps the Expires line of security.txt a year ahead
+	SecurityLanguages         []string      // Preferred-Languages line of security.txt, defaults to en
+	WellKnownDir              string        // extra files served under /.well-known/, empty disables
+	ApplicantBadgeFloor       int           // jobs with fewer applicants don't show a count
+	ApplicantBadgeCap         int           // higher applicant counts are shown as "N+"
+	BadBotMode                string        // block (default), tarpit or decoy
+	BadBotDelay               time.Duration // how long tarpit holds bad bot requests
+	BadBotMaxTarpits          int           // bad bot requests tarpit holds at once, past it they are blocked
+	AuthBreakerThreshold      int           // consecutive firebase failures that open the auth circuit breaker, 0 disables
+	AuthBreakerWindow         time.Duration // failures further apart than this don't add up
+	AuthBreakerCooldown       time.Duration // how long the open breaker fails fast before probing firebase again
+	AuthVerifyTimeout         time.Duration // bound on a single firebase id token verification
+	AccessLogHeaders          []string      // request headers added to access log lines
+	AccessLogDeniedHeaders    []string      // headers redacted from access log lines on top of the credentials always redacted
+	HSTSMaxAge                time.Duration // max-age of Strict-Transport-Security
+	HSTSIncludeSubDomains     bool
+	HSTSPreload               bool     // opt in to the HSTS preload list, practically irreversible, only sent in prod
+	AuthRedirectPath          string   // sign in page signed out users are sent to, /auth by default
+	BlockedPaths              []string // exploit probe paths answered with a bare 404, nil for the defaults and empty to disable
+	CaptchaSecret             string   // secret of the CAPTCHA provider, empty disables CAPTCHA checks
+	CaptchaVerifyURL          string   // siteverify endpoint of the CAPTCHA provider, empty for hCaptcha
+	CaptchaSiteKey            string   // public site key the CAPTCHA widget is rendered with, required with CaptchaSecret
+	DatabaseReplicaHost       string   // read replica taking the read only user queries, empty reads from the primary
+	ServerTiming              bool     // send a Server-Timing header with the time spent in db queries, rendering and auth
+	BetaAllowlist             []string // lower case emails let past the beta gate, empty disables the gate
+	BetaPublicPaths           []string // paths served to everyone while the beta gate is on, nil for the defaults
+	PageTitleSeparator        string   // goes between the segments of page titles, empty for "|"
+	SchemeHeaders             []string // headers the proxy sets to the client scheme, e.g. X-Forwarded-Scheme or Forwarded, nil for X-Forwarded-Proto
+	TrustedProxies            []string // IPs and CIDRs of the reverse proxies whose X-Forwarded-* headers are believed
 }
 
 func LoadConfig(envFile string) (Config, error) {
@@ -342,6 +403,340 @@ func LoadConfig(envFile string) (Config, error) {
 	}
 	developersBannerLink := os.Getenv("DEVELOPERS_BANNER_LINK")
 	developersBannerText := os.Getenv("DEVELOPERS_BANNER_TEXT")
+	sessionEmbedded := strings.EqualFold(os.Getenv("SESSION_EMBEDDED"), "true")
+	embeddedCookieName := os.Getenv("EMBEDDED_COOKIE_NAME")
+	if embeddedCookieName == "" {
+		embeddedCookieName = "____gce"
+	}
+	sessionStore := strings.ToLower(os.Getenv("SESSION_STORE"))
+	if sessionStore == "" {
+		sessionStore = "cookie"
+	}
+	if sessionStore != "cookie" && sessionStore != "postgres" {
+		return Config{}, fmt.Errorf("SESSION_STORE must be either cookie or postgres")
+	}
+	sessionTTL := 24 * time.Hour
+	if sessionTTLStr := os.Getenv("SESSION_TTL"); sessionTTLStr != "" {
+		sessionTTL, err = time.ParseDuration(sessionTTLStr)
+		if err != nil {
+			return Config{}, fmt.Errorf("unable to parse SESSION_TTL as duration: %w", err)
+		}
+	}
+	sessionRenewalThreshold := 0.2
+	if sessionRenewalThresholdStr := os.Getenv("SESSION_RENEWAL_THRESHOLD"); sessionRenewalThresholdStr != "" {
+		sessionRenewalThreshold, err = strconv.ParseFloat(sessionRenewalThresholdStr, 64)
+		if err != nil {
+			return Config{}, fmt.Errorf("unable to parse SESSION_RENEWAL_THRESHOLD as float: %w", err)
+		}
+	}
+	rateLimitAnonymous := 120
+	if rateLimitAnonymousStr := os.Getenv("RATE_LIMIT_ANONYMOUS"); rateLimitAnonymousStr != "" {
+		rateLimitAnonymous, err = strconv.Atoi(rateLimitAnonymousStr)
+		if err != nil {
+			return Config{}, fmt.Errorf("could not convert ascii to int: %v", err)
+		}
+	}
+	rateLimitAuthenticated := 600
+	if rateLimitAuthenticatedStr := os.Getenv("RATE_LIMIT_AUTHENTICATED"); rateLimitAuthenticatedStr != "" {
+		rateLimitAuthenticated, err = strconv.Atoi(rateLimitAuthenticatedStr)
+		if err != nil {
+			return Config{}, fmt.Errorf("could not convert ascii to int: %v", err)
+		}
+	}
+	userWebhookURL := os.Getenv("USER_WEBHOOK_URL")
+	userWebhookSecret := os.Getenv("USER_WEBHOOK_SECRET")
+	if userWebhookURL != "" && userWebhookSecret == "" {
+		return Config{}, fmt.Errorf("USER_WEBHOOK_SECRET cannot be empty when USER_WEBHOOK_URL is set")
+	}
+	searchIndexURL := os.Getenv("SEARCH_INDEX_URL")
+	searchIndexQueueSize := 1000
+	if v := os.Getenv("SEARCH_INDEX_QUEUE_SIZE"); v != "" {
+		searchIndexQueueSize, err = strconv.Atoi(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("unable to parse SEARCH_INDEX_QUEUE_SIZE as int: %w", err)
+		}
+	}
+	referrerPolicy := os.Getenv("REFERRER_POLICY")
+	permissionsPolicy := os.Getenv("PERMISSIONS_POLICY")
+	noIndexPathsStr := os.Getenv("NO_INDEX_PATHS")
+	if noIndexPathsStr == "" {
+		noIndexPathsStr = "/profile,/manage,/auth,/autologin"
+	}
+	var noIndexPaths []string
+	for _, p := range strings.Split(noIndexPathsStr, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			noIndexPaths = append(noIndexPaths, p)
+		}
+	}
+	machineSignedRequests := strings.EqualFold(os.Getenv("MACHINE_SIGNED_REQUESTS"), "true")
+	var databaseMaxOpenConns, databaseMaxIdleConns int
+	if v := os.Getenv("DATABASE_MAX_OPEN_CONNS"); v != "" {
+		databaseMaxOpenConns, err = strconv.Atoi(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("could not convert ascii to int: %v", err)
+		}
+	}
+	if v := os.Getenv("DATABASE_MAX_IDLE_CONNS"); v != "" {
+		databaseMaxIdleConns, err = strconv.Atoi(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("could not convert ascii to int: %v", err)
+		}
+	}
+	var databaseConnMaxLifetime time.Duration
+	if v := os.Getenv("DATABASE_CONN_MAX_LIFETIME"); v != "" {
+		databaseConnMaxLifetime, err = time.ParseDuration(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("unable to parse DATABASE_CONN_MAX_LIFETIME as duration: %w", err)
+		}
+	}
+	var databaseQueryTimeout time.Duration
+	if v := os.Getenv("DATABASE_QUERY_TIMEOUT"); v != "" {
+		databaseQueryTimeout, err = time.ParseDuration(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("unable to parse DATABASE_QUERY_TIMEOUT as duration: %w", err)
+		}
+	}
+	userCacheTTL := 30 * time.Second
+	if v := os.Getenv("USER_CACHE_TTL"); v != "" {
+		userCacheTTL, err = time.ParseDuration(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("unable to parse USER_CACHE_TTL as duration: %w", err)
+		}
+	}
+	userCacheSize := 10000
+	if v := os.Getenv("USER_CACHE_SIZE"); v != "" {
+		userCacheSize, err = strconv.Atoi(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("unable to parse USER_CACHE_SIZE as int: %w", err)
+		}
+	}
+	var allowedHosts []string
+	for _, host := range strings.Split(os.Getenv("ALLOWED_HOSTS"), ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			allowedHosts = append(allowedHosts, host)
+		}
+	}
+	defaultLocale := os.Getenv("DEFAULT_LOCALE")
+	if defaultLocale == "" {
+		defaultLocale = "en"
+	}
+	supportedLocales := os.Getenv("SUPPORTED_LOCALES")
+	if supportedLocales == "" {
+		supportedLocales = "en,de,es,fr,it,pt"
+	}
+	var locales []string
+	for _, locale := range strings.Split(supportedLocales, ",") {
+		if locale = strings.TrimSpace(locale); locale != "" {
+			locales = append(locales, locale)
+		}
+	}
+	accessLogSampleRate := 1.0
+	if v := os.Getenv("ACCESS_LOG_SAMPLE_RATE"); v != "" {
+		accessLogSampleRate, err = strconv.ParseFloat(v, 64)
+		if err != nil {
+			return Config{}, fmt.Errorf("unable to parse ACCESS_LOG_SAMPLE_RATE as float: %w", err)
+		}
+		if accessLogSampleRate < 0 || accessLogSampleRate > 1 {
+			return Config{}, fmt.Errorf("ACCESS_LOG_SAMPLE_RATE must be between 0 and 1")
+		}
+	}
+	accessLogSlowThreshold := time.Second
+	if v := os.Getenv("ACCESS_LOG_SLOW_THRESHOLD"); v != "" {
+		accessLogSlowThreshold, err = time.ParseDuration(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("unable to parse ACCESS_LOG_SLOW_THRESHOLD as duration: %w", err)
+		}
+	}
+	maxURILength := 8192
+	if v := os.Getenv("MAX_URI_LENGTH"); v != "" {
+		maxURILength, err = strconv.Atoi(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("unable to parse MAX_URI_LENGTH as int: %w", err)
+		}
+	}
+	var securityContacts []string
+	for _, contact := range strings.Split(os.Getenv("SECURITY_CONTACTS"), ",") {
+		if contact = strings.TrimSpace(contact); contact != "" {
+			securityContacts = append(securityContacts, contact)
+		}
+	}
+	if len(securityContacts) == 0 {
+		securityContacts = []string{"mailto:" + supportEmail}
+	}
+	var securityLanguages []string
+	for _, lang := range strings.Split(os.Getenv("SECURITY_LANGUAGES"), ",") {
+		if lang = strings.TrimSpace(lang); lang != "" {
+			securityLanguages = append(securityLanguages, lang)
+		}
+	}
+	if len(securityLanguages) == 0 {
+		securityLanguages = []string{"en"}
+	}
+	var securityTxtExpires time.Time
+	if v := os.Getenv("SECURITY_TXT_EXPIRES"); v != "" {
+		securityTxtExpires, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return Config{}, fmt.Errorf("unable to parse SECURITY_TXT_EXPIRES as RFC 3339 time: %w", err)
+		}
+	}
+	applicantBadgeFloor := 5
+	if v := os.Getenv("APPLICANT_BADGE_FLOOR"); v != "" {
+		applicantBadgeFloor, err = strconv.Atoi(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("unable to parse APPLICANT_BADGE_FLOOR as int: %w", err)
+		}
+	}
+	applicantBadgeCap := 50
+	if v := os.Getenv("APPLICANT_BADGE_CAP"); v != "" {
+		applicantBadgeCap, err = strconv.Atoi(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("unable to parse APPLICANT_BADGE_CAP as int: %w", err)
+		}
+	}
+	badBotMode := os.Getenv("BAD_BOT_MODE")
+	if badBotMode == "" {
+		badBotMode = "block"
+	}
+	if badBotMode != "block" && badBotMode != "tarpit" && badBotMode != "decoy" {
+		return Config{}, fmt.Errorf("BAD_BOT_MODE must be one of block, tarpit or decoy")
+	}
+	badBotDelay := 10 * time.Second
+	if v := os.Getenv("BAD_BOT_DELAY"); v != "" {
+		badBotDelay, err = time.ParseDuration(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("unable to parse BAD_BOT_DELAY as duration: %w", err)
+		}
+	}
+	badBotMaxTarpits := 100
+	if v := os.Getenv("BAD_BOT_MAX_TARPITS"); v != "" {
+		badBotMaxTarpits, err = strconv.Atoi(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("unable to parse BAD_BOT_MAX_TARPITS as int: %w", err)
+		}
+	}
+	var accessLogHeaders []string
+	for _, header := range strings.Split(os.Getenv("ACCESS_LOG_HEADERS"), ",") {
+		if header = strings.TrimSpace(header); header != "" {
+			accessLogHeaders = append(accessLogHeaders, header)
+		}
+	}
+	var accessLogDeniedHeaders []string
+	for _, header := range strings.Split(os.Getenv("ACCESS_LOG_DENIED_HEADERS"), ",") {
+		if header = strings.TrimSpace(header); header != "" {
+			accessLogDeniedHeaders = append(accessLogDeniedHeaders, header)
+		}
+	}
+	hstsMaxAge := 365 * 24 * time.Hour
+	if v := os.Getenv("HSTS_MAX_AGE"); v != "" {
+		hstsMaxAge, err = time.ParseDuration(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("unable to parse HSTS_MAX_AGE as duration: %w", err)
+		}
+	}
+	hstsIncludeSubDomains := true
+	if v := os.Getenv("HSTS_INCLUDE_SUBDOMAINS"); v != "" {
+		hstsIncludeSubDomains, err = strconv.ParseBool(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("unable to parse HSTS_INCLUDE_SUBDOMAINS as bool: %w", err)
+		}
+	}
+	var hstsPreload bool
+	if v := os.Getenv("HSTS_PRELOAD"); v != "" {
+		hstsPreload, err = strconv.ParseBool(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("unable to parse HSTS_PRELOAD as bool: %w", err)
+		}
+	}
+	authRedirectPath := os.Getenv("AUTH_REDIRECT_PATH")
+	if authRedirectPath == "" {
+		authRedirectPath = "/auth"
+	}
+	var blockedPaths []string
+	if v, ok := os.LookupEnv("BLOCKED_PATHS"); ok {
+		blockedPaths = []string{}
+		for _, p := range strings.Split(v, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				blockedPaths = append(blockedPaths, p)
+			}
+		}
+	}
+	captchaSecret := os.Getenv("CAPTCHA_SECRET")
+	captchaVerifyURL := os.Getenv("CAPTCHA_VERIFY_URL")
+	captchaSiteKey := os.Getenv("CAPTCHA_SITE_KEY")
+	if captchaSecret != "" && captchaSiteKey == "" {
+		return Config{}, fmt.Errorf("CAPTCHA_SITE_KEY cannot be empty when CAPTCHA_SECRET is set")
+	}
+	databaseReplicaHost := os.Getenv("DATABASE_REPLICA_HOST")
+	var serverTiming bool
+	if v := os.Getenv("SERVER_TIMING"); v != "" {
+		serverTiming, err = strconv.ParseBool(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("unable to parse SERVER_TIMING as bool: %w", err)
+		}
+	}
+	var betaAllowlist []string
+	for _, e := range strings.Split(os.Getenv("BETA_ALLOWLIST"), ",") {
+		if e = strings.ToLower(strings.TrimSpace(e)); e != "" {
+			betaAllowlist = append(betaAllowlist, e)
+		}
+	}
+	var betaPublicPaths []string
+	if v, ok := os.LookupEnv("BETA_PUBLIC_PATHS"); ok {
+		betaPublicPaths = []string{}
+		for _, p := range strings.Split(v, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				betaPublicPaths = append(betaPublicPaths, p)
+			}
+		}
+	}
+	pageTitleSeparator := os.Getenv("PAGE_TITLE_SEPARATOR")
+	var schemeHeaders []string
+	for _, h := range strings.Split(os.Getenv("SCHEME_HEADERS"), ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			schemeHeaders = append(schemeHeaders, h)
+		}
+	}
+	var trustedProxies []string
+	for _, p := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			trustedProxies = append(trustedProxies, p)
+		}
+	}
+	authBreakerThreshold := 5
+	if v := os.Getenv("AUTH_BREAKER_THRESHOLD"); v != "" {
+		authBreakerThreshold, err = strconv.Atoi(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("unable to parse AUTH_BREAKER_THRESHOLD as int: %w", err)
+		}
+	}
+	authBreakerWindow := time.Minute
+	if v := os.Getenv("AUTH_BREAKER_WINDOW"); v != "" {
+		authBreakerWindow, err = time.ParseDuration(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("unable to parse AUTH_BREAKER_WINDOW as duration: %w", err)
+		}
+	}
+	authBreakerCooldown := 30 * time.Second
+	if v := os.Getenv("AUTH_BREAKER_COOLDOWN"); v != "" {
+		authBreakerCooldown, err = time.ParseDuration(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("unable to parse AUTH_BREAKER_COOLDOWN as duration: %w", err)
+		}
+	}
+	authVerifyTimeout := 5 * time.Second
+	if v := os.Getenv("AUTH_VERIFY_TIMEOUT"); v != "" {
+		authVerifyTimeout, err = time.ParseDuration(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("unable to parse AUTH_VERIFY_TIMEOUT as duration: %w", err)
+		}
+	}
+	sessionCookieName := os.Getenv("SESSION_COOKIE_NAME")
+	var sessionLegacyCookieNames []string
+	for _, name := range strings.Split(os.Getenv("SESSION_LEGACY_COOKIE_NAMES"), ",") {
+		if name = strings.TrimSpace(name); name != "" && name != sessionCookieName {
+			sessionLegacyCookieNames = append(sessionLegacyCookieNames, name)
+		}
+	}
 	urlProtocol := "http://"
 	if !strings.EqualFold(env, "dev") {
 		urlProtocol = "https://"
@@ -406,6 +801,66 @@ func LoadConfig(envFile string) (Config, error) {
 		DevelopersBannerText:     developersBannerText,
 		URLProtocol:              urlProtocol,
 		FirebaseCredentialFile:   firebaseFileLocation,
+		SessionEmbedded:          sessionEmbedded,
+		EmbeddedCookieName:       embeddedCookieName,
+		SessionStore:             sessionStore,
+		SessionTTL:               sessionTTL,
+		SessionRenewalThreshold:  sessionRenewalThreshold,
+		RateLimitAnonymous:       rateLimitAnonymous,
+		RateLimitAuthenticated:   rateLimitAuthenticated,
+		UserWebhookURL:           userWebhookURL,
+		UserWebhookSecret:        []byte(userWebhookSecret),
+		SearchIndexURL:           searchIndexURL,
+		SearchIndexQueueSize:     searchIndexQueueSize,
+		ReferrerPolicy:           referrerPolicy,
+		PermissionsPolicy:        permissionsPolicy,
+		NoIndexPaths:             noIndexPaths,
+		MachineSignedRequests:    machineSignedRequests,
+		DatabaseMaxOpenConns:     databaseMaxOpenConns,
+		DatabaseMaxIdleConns:     databaseMaxIdleConns,
+		DatabaseConnMaxLifetime:  databaseConnMaxLifetime,
+		DatabaseQueryTimeout:     databaseQueryTimeout,
+		UserCacheTTL:             userCacheTTL,
+		UserCacheSize:            userCacheSize,
+		AllowedHosts:             allowedHosts,
+		SessionCookieName:        sessionCookieName,
+		SessionLegacyCookieNames: sessionLegacyCookieNames,
+		Locales:                  locales,
+		DefaultLocale:            defaultLocale,
+		AccessLogSampleRate:      accessLogSampleRate,
+		AccessLogSlowThreshold:   accessLogSlowThreshold,
+		MaxURILength:             maxURILength,
+		SecurityContacts:         securityContacts,
+		SecurityPolicyURL:        os.Getenv("SECURITY_POLICY_URL"),
+		SecurityTxtExpires:       securityTxtExpires,
+		SecurityLanguages:        securityLanguages,
+		WellKnownDir:             os.Getenv("WELL_KNOWN_DIR"),
+		ApplicantBadgeFloor:      applicantBadgeFloor,
+		ApplicantBadgeCap:        applicantBadgeCap,
+		BadBotMode:               badBotMode,
+		BadBotDelay:              badBotDelay,
+		BadBotMaxTarpits:         badBotMaxTarpits,
+		AuthBreakerThreshold:     authBreakerThreshold,
+		AuthBreakerWindow:        authBreakerWindow,
+		AuthBreakerCooldown:      authBreakerCooldown,
+		AuthVerifyTimeout:        authVerifyTimeout,
+		AccessLogHeaders:         accessLogHeaders,
+		AccessLogDeniedHeaders:   accessLogDeniedHeaders,
+		HSTSMaxAge:               hstsMaxAge,
+		HSTSIncludeSubDomains:    hstsIncludeSubDomains,
+		HSTSPreload:              hstsPreload,
+		AuthRedirectPath:         authRedirectPath,
+		BlockedPaths:             blockedPaths,
+		CaptchaSecret:            captchaSecret,
+		CaptchaVerifyURL:         captchaVerifyURL,
+		CaptchaSiteKey:           captchaSiteKey,
+		DatabaseReplicaHost:      databaseReplicaHost,
+		ServerTiming:             serverTiming,
+		BetaAllowlist:            betaAllowlist,
+		BetaPublicPaths:          betaPublicPaths,
+		PageTitleSeparator:       pageTitleSeparator,
+		SchemeHeaders:            schemeHeaders,
+		TrustedProxies:           trustedProxies,
 		// FirebaseApiKey:            firebaseApiKey,
 		// FirebaseAuthDomain:        firebaseAuthDomain,
 		// FirebaseProjectId:         firebaseProjectId,
@@ -0,0 +1,73 @@
+// Package currency knows the ISO 4217 currencies salaries can be shown in. It is shared by
+// the data layer, which validates preferred currencies, and the templates, which print them
+package currency
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// symbols maps ISO 4217 codes to display symbols, extend it with Register
+var symbols = map[string]string{
+	"USD": "$",
+	"EUR": "€",
+	"JPY": "¥",
+	"GBP": "£",
+	"AUD": "A$",
+	"CAD": "C$",
+	"CHF": "Fr",
+	"CNY": "元",
+	"HKD": "HK$",
+	"NZD": "NZ$",
+	"SEK": "kr",
+	"KRW": "₩",
+	"SGD": "S$",
+	"NOK": "kr",
+	"MXN": "MX$",
+	"INR": "₹",
+	"RUB": "₽",
+	"ZAR": "R",
+	"TRY": "₺",
+	"BRL": "R$",
+}
+
+var symbolsMu sync.RWMutex
+
+// Register adds or replaces the symbol shown for the ISO 4217 currency code
+func Register(code, symbol string) {
+	symbolsMu.Lock()
+	defer symbolsMu.Unlock()
+	symbols[strings.ToUpper(code)] = symbol
+}
+
+// Supported returns the sorted codes of all currencies with a known symbol
+func Supported() []string {
+	symbolsMu.RLock()
+	defer symbolsMu.RUnlock()
+	codes := make([]string, 0, len(symbols))
+	for code := range symbols {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// IsSupported reports whether code is one of the Supported currencies
+func IsSupported(code string) bool {
+	symbolsMu.RLock()
+	defer symbolsMu.RUnlock()
+	_, ok := symbols[code]
+	return ok
+}
+
+// Symbol returns the symbol of the ISO 4217 currency code, "$" for unknown codes
+func Symbol(code string) string {
+	symbolsMu.RLock()
+	defer symbolsMu.RUnlock()
+	symbol, ok := symbols[code]
+	if !ok {
+		return "$"
+	}
+	return symbol
+}
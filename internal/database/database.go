@@ -200,6 +200,25 @@ func AddFXRate(conn *sql.DB, fx FXRate) error {
 	return err
 }
 
+// GetFXRates returns the rates to convert into target, keyed by base currency
+func GetFXRates(conn *sql.DB, target string) (map[string]float64, error) {
+	rows, err := conn.Query(`SELECT base, value FROM fx_rate WHERE target = $1`, target)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rates := make(map[string]float64)
+	for rows.Next() {
+		var base string
+		var value float64
+		if err := rows.Scan(&base, &value); err != nil {
+			return nil, err
+		}
+		rates[strings.TrimSpace(base)] = value
+	}
+	return rates, rows.Err()
+}
+
 type EmailSubscriber struct {
 	Email       string
 	Token       string
@@ -212,6 +231,17 @@ func AddEmailSubscriber(conn *sql.DB, email, token string) error {
 	return err
 }
 
+// AddBetaAccessRequest records that email asked to join the private beta. It returns false
+// when the email already asked before
+func AddBetaAccessRequest(conn *sql.DB, email string) (bool, error) {
+	res, err := conn.Exec(`INSERT INTO beta_access_request (email, created_at) VALUES ($1, NOW()) ON CONFLICT DO NOTHING`, email)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
 func ConfirmEmailSubscriber(conn *sql.DB, token string) error {
 	_, err := conn.Exec(`UPDATE email_subscribers SET confirmed_at = NOW() WHERE token = $1`, token)
 	return err
@@ -0,0 +1,113 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrUnavailable is returned instead of querying while the database is known to be down
+var ErrUnavailable = errors.New("database unavailable")
+
+// Health tracks whether the database is reachable. Repositories report query errors to
+// Observe, a connection level failure marks the database unhealthy and starts probing it
+// in the background until a ping succeeds again. Only the user repository reports to it,
+// through the user lookups every authenticated request makes, so failures of queries made
+// by other repositories are not noticed until a user lookup fails too
+type Health struct {
+	db           *sql.DB
+	Backoff      time.Duration // delay before the first probe, doubled up to MaxBackoff
+	MaxBackoff   time.Duration
+	ProbeTimeout time.Duration
+
+	mu      sync.Mutex
+	healthy bool
+	probing bool
+}
+
+func NewHealth(db *sql.DB) *Health {
+	return &Health{
+		db:           db,
+		Backoff:      2 * time.Second,
+		MaxBackoff:   30 * time.Second,
+		ProbeTimeout: 5 * time.Second,
+		healthy:      true,
+	}
+}
+
+// Healthy reports whether the last connection level failure has been followed by a successful probe
+func (h *Health) Healthy() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.healthy
+}
+
+// Observe returns err unchanged, marking the database unhealthy first if err shows the
+// connection to it was lost
+func (h *Health) Observe(err error) error {
+	if err == nil || !IsConnectionError(err) {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.healthy = false
+	if !h.probing {
+		h.probing = true
+		log.Printf("database connection lost, probing for recovery: %v", err)
+		go h.probe()
+	}
+	return err
+}
+
+func (h *Health) probe() {
+	backoff := h.Backoff
+	for {
+		time.Sleep(backoff)
+		ctx, cancel := context.WithTimeout(context.Background(), h.ProbeTimeout)
+		err := h.db.PingContext(ctx)
+		cancel()
+		if err == nil {
+			h.mu.Lock()
+			h.healthy = true
+			h.probing = false
+			h.mu.Unlock()
+			log.Printf("database connection recovered")
+			return
+		}
+		if backoff *= 2; backoff > h.MaxBackoff {
+			backoff = h.MaxBackoff
+		}
+	}
+}
+
+// IsConnectionError reports whether err comes from a lost or refused connection rather than from the query itself.
+// Context errors are never connection errors: a query timing out, or a request giving up while
+// waiting for a pooled connection, says nothing about the database being reachable
+func IsConnectionError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// class 08 is connection exception, 57P01-57P03 are shutdowns and startups
+		return pqErr.Code.Class() == "08" || pqErr.Code == "57P01" || pqErr.Code == "57P02" || pqErr.Code == "57P03"
+	}
+	return false
+}
@@ -0,0 +1,29 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+)
+
+type primaryKey struct{}
+
+// WithPrimary returns a copy of ctx in which repositories read from the primary rather than
+// from a replica, for reads that must see a write made just before, e.g. right after a create
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// PrimaryRequired reports whether ctx was marked with WithPrimary
+func PrimaryRequired(ctx context.Context) bool {
+	required, _ := ctx.Value(primaryKey{}).(bool)
+	return required
+}
+
+// Reader returns the database reads made with ctx should go to: replica when there is one
+// and ctx doesn't require the primary, primary otherwise
+func Reader(ctx context.Context, primary, replica *sql.DB) *sql.DB {
+	if replica == nil || PrimaryRequired(ctx) {
+		return primary
+	}
+	return replica
+}
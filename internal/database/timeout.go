@@ -0,0 +1,31 @@
+package database
+
+import (
+	"context"
+	"time"
+)
+
+type queryTimeoutKey struct{}
+
+// WithQueryTimeout returns a copy of ctx in which every query made by repositories that
+// honor it is bounded by d, on top of any deadline ctx already has. A d of 0 lifts
+// the bound, e.g. for long running exports
+func WithQueryTimeout(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, queryTimeoutKey{}, d)
+}
+
+// QueryTimeout returns the per query timeout set on ctx, if any
+func QueryTimeout(ctx context.Context) (time.Duration, bool) {
+	d, ok := ctx.Value(queryTimeoutKey{}).(time.Duration)
+	return d, ok && d > 0
+}
+
+// QueryContext derives the context a single query should run with, applying the
+// timeout set with WithQueryTimeout. The cancel func must be called once the query,
+// including reading its rows, is done
+func QueryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d, ok := QueryTimeout(ctx); ok {
+		return context.WithTimeout(ctx, d)
+	}
+	return ctx, func() {}
+}
@@ -118,7 +118,8 @@ func (w *GzipResponseWriter) Write(b []byte) (int, error) {
 		ce    = w.Header().Get(contentEncoding)
 	)
 	// Only continue if they didn't already choose an encoding or a known unhandled content length or type.
-	if ce == "" && (cl == 0 || cl >= w.minSize) && (ct == "" || handleContentType(w.contentTypes, ct)) {
+	// Partial content is never compressed, the byte ranges refer to the identity encoded body.
+	if ce == "" && w.code != http.StatusPartialContent && w.Header().Get("Content-Range") == "" && (cl == 0 || cl >= w.minSize) && (ct == "" || handleContentType(w.contentTypes, ct)) {
 		// If the current buffer is less than minSize and a Content-Length isn't set, then wait until we have more data.
 		if len(w.buf) < w.minSize && cl == 0 {
 			return len(b), nil
@@ -322,7 +323,8 @@ func GzipHandlerWithOpts(opts ...option) (func(http.Handler) http.Handler, error
 
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Add(vary, acceptEncoding)
-			if acceptsGzip(r) {
+			// Range requests are passed through untouched so the ranges served match the identity encoded body
+			if acceptsGzip(r) && r.Header.Get("Range") == "" {
 				gw := &GzipResponseWriter{
 					ResponseWriter: w,
 					index:          index,
@@ -452,6 +454,13 @@ func acceptsGzip(r *http.Request) bool {
 	return acceptedEncodings["gzip"] > 0.0
 }
 
+// AcceptsEncoding returns true if the given HTTP request indicates that it will
+// accept a response with the given content-coding, e.g. "br".
+func AcceptsEncoding(r *http.Request, coding string) bool {
+	acceptedEncodings, _ := parseEncodings(r.Header.Get(acceptEncoding))
+	return acceptedEncodings[coding] > 0.0
+}
+
 // returns true if we've been configured to compress the specific content type.
 func handleContentType(contentTypes []parsedContentType, ct string) bool {
 	// If contentTypes is empty we handle all content types.
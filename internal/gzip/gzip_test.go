@@ -0,0 +1,99 @@
+package gzip
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGzipHandler(t *testing.T) {
+	body := []byte(strings.Repeat("golang cafe jobs ", 500))
+	var precompressed bytes.Buffer
+	gz := gzip.NewWriter(&precompressed)
+	gz.Write(body)
+	gz.Close()
+
+	tests := []struct {
+		name         string
+		rangeHeader  string
+		handler      http.HandlerFunc
+		wantStatus   int
+		wantEncoding string
+		wantBody     []byte
+	}{
+		{
+			name: "full response is compressed",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.ServeContent(w, r, "jobs.txt", time.Time{}, bytes.NewReader(body))
+			},
+			wantStatus:   http.StatusOK,
+			wantEncoding: "gzip",
+			wantBody:     body,
+		},
+		{
+			name:        "range request is served as is",
+			rangeHeader: "bytes=100-1999",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.ServeContent(w, r, "jobs.txt", time.Time{}, bytes.NewReader(body))
+			},
+			wantStatus: http.StatusPartialContent,
+			wantBody:   body[100:2000],
+		},
+		{
+			name: "partial content without a range request is served as is",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Range", "bytes 0-1999/8500")
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write(body[:2000])
+			},
+			wantStatus: http.StatusPartialContent,
+			wantBody:   body[:2000],
+		},
+		{
+			name: "already encoded response is not compressed twice",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Encoding", "gzip")
+				w.Write(precompressed.Bytes())
+			},
+			wantStatus:   http.StatusOK,
+			wantEncoding: "gzip",
+			wantBody:     body,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/jobs.txt", nil)
+			r.Header.Set("Accept-Encoding", "gzip")
+			if tt.rangeHeader != "" {
+				r.Header.Set("Range", tt.rangeHeader)
+			}
+			w := httptest.NewRecorder()
+			GzipHandler(tt.handler).ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Fatalf("Content-Encoding = %q, want %q", got, tt.wantEncoding)
+			}
+			got := w.Body.Bytes()
+			if tt.wantEncoding == "gzip" {
+				zr, err := gzip.NewReader(w.Body)
+				if err != nil {
+					t.Fatalf("body is not gzip: %v", err)
+				}
+				if got, err = io.ReadAll(zr); err != nil {
+					t.Fatalf("body does not decompress: %v", err)
+				}
+			}
+			if !bytes.Equal(got, tt.wantBody) {
+				t.Errorf("body is %d bytes, want the expected %d bytes", len(got), len(tt.wantBody))
+			}
+		})
+	}
+}
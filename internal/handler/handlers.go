@@ -3,6 +3,7 @@ package handler
 import (
 	"bytes"
 	"context"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -24,14 +25,12 @@ import (
 	"github.com/bot-api/telegram"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/dustin/go-humanize"
-	"github.com/gorilla/feeds"
 	"github.com/gorilla/mux"
 	"github.com/gosimple/slug"
 	"github.com/machinebox/graphql"
 	"github.com/microcosm-cc/bluemonday"
 	"github.com/nfnt/resize"
 	"github.com/segmentio/ksuid"
-	"github.com/snabb/sitemap"
 
 	"github.com/golang-cafe/job-board/internal/blog"
 	"github.com/golang-cafe/job-board/internal/company"
@@ -62,9 +61,9 @@ type devGetSaver interface {
 }
 
 type tokenSaver interface {
-	SaveTokenSignOn(email, token, userType string) error
-	CreateUser(user.User) error
-	UpdateAccessToken(userId, accessToken string) error
+	SaveTokenSignOn(ctx context.Context, email, token, userType string, remember bool) error
+	CreateUser(ctx context.Context, u user.User) error
+	UpdateAccessToken(ctx context.Context, userId, accessToken string) error
 }
 
 func GetAutologinPageHandler(svr server.Server) http.HandlerFunc {
@@ -85,8 +84,9 @@ func GetAutologinPageHandler(svr server.Server) http.HandlerFunc {
 
 func GetAuthPageHandler(svr server.Server) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		profile, _ := middleware.GetUserFromJWT(r, svr.SessionStore, svr.GetJWTSigningKey())
-		if profile != nil {
+		profile, _ := middleware.IdentityFromContext(r.Context())
+		// signed in users are only asked to sign in again by RequireRecentAuth
+		if profile != nil && r.URL.Query().Get("reauth") != "1" {
 			svr.Redirect(w, r, http.StatusMovedPermanently, fmt.Sprintf("%s%s/", svr.GetConfig().URLProtocol, svr.GetConfig().SiteHost))
 			return
 		}
@@ -119,7 +119,7 @@ func DevelopersHandler(svr server.Server, devRepo *developer.Repository, recruit
 		location := vars["location"]
 		tag := vars["tag"]
 		page := r.URL.Query().Get("p")
-		profile, _ := middleware.GetUserFromJWT(r, svr.SessionStore, svr.GetJWTSigningKey())
+		profile, _ := middleware.IdentityFromContext(r.Context())
 		if profile != nil && profile.Type == "recruiter" {
 			expTime, err := recruiterRepo.RecruiterProfilePlanExpiration(profile.Email)
 			if err == nil && expTime.Before(time.Now().UTC()) {
@@ -134,7 +134,7 @@ func DevelopersHandler(svr server.Server, devRepo *developer.Repository, recruit
 
 func SubmitDeveloperProfileHandler(svr server.Server, devRepo *developer.Repository) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		profile, _ := middleware.GetUserFromJWT(r, svr.SessionStore, svr.GetJWTSigningKey())
+		profile, _ := middleware.IdentityFromContext(r.Context())
 		if profile != nil {
 			log.Println("profile != nil")
 			routeName := fmt.Sprintf("%s-Developers", strings.Title(svr.GetConfig().SiteJobCategory))
@@ -155,7 +155,7 @@ func SubmitDeveloperProfileHandler(svr server.Server, devRepo *developer.Reposit
 
 func SubmitRecruiterProfileHandler(svr server.Server, devRepo *developer.Repository) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		profile, _ := middleware.GetUserFromJWT(r, svr.SessionStore, svr.GetJWTSigningKey())
+		profile, _ := middleware.IdentityFromContext(r.Context())
 		if profile != nil {
 			log.Println("profile != nil")
 			routeName := fmt.Sprintf("%s-Developers", strings.Title(svr.GetConfig().SiteJobCategory))
@@ -216,7 +216,13 @@ func SaveRecruiterProfileHandler(svr server.Server, recRepo *recruiter.Repositor
 			UpdatedAt:  t,
 			Email:      strings.ToLower(req.Email),
 		}
-		err = userRepo.SaveTokenSignOn(strings.ToLower(req.Email), k.String(), user.UserTypeRecruiter)
+		signOnToken, err := user.GenerateSignOnToken()
+		if err != nil {
+			svr.Log(err, "unable to generate sign on token")
+			svr.JSON(w, http.StatusInternalServerError, nil)
+			return
+		}
+		err = userRepo.SaveTokenSignOn(r.Context(), strings.ToLower(req.Email), signOnToken, user.UserTypeRecruiter, false)
 		if err != nil {
 			svr.Log(err, "unable to save sign on token")
 			svr.JSON(w, http.StatusInternalServerError, nil)
@@ -238,7 +244,7 @@ func SaveRecruiterProfileHandler(svr server.Server, recRepo *recruiter.Repositor
 				svr.GetConfig().SiteName,
 				svr.GetConfig().URLProtocol,
 				svr.GetConfig().SiteHost,
-				k.String(),
+				signOnToken,
 			),
 		)
 		if err != nil {
@@ -305,9 +311,9 @@ func SaveDeveloperMetadataHandler(svr server.Server, devRepo *developer.Reposito
 				svr.JSON(w, http.StatusBadRequest, nil)
 				return
 			}
-			profile, err := middleware.GetUserFromJWT(r, svr.SessionStore, svr.GetJWTSigningKey())
-			if err != nil {
-				svr.Log(err, "unable to get email from JWT")
+			profile, ok := middleware.IdentityFromContext(r.Context())
+			if !ok {
+				svr.Log(middleware.ErrNoIdentity, "unable to get email from JWT")
 				svr.JSON(w, http.StatusForbidden, nil)
 				return
 			}
@@ -360,9 +366,9 @@ func DeleteDeveloperMetadataHandler(svr server.Server, devRepo *developer.Reposi
 				svr.JSON(w, http.StatusBadRequest, nil)
 				return
 			}
-			profile, err := middleware.GetUserFromJWT(r, svr.SessionStore, svr.GetJWTSigningKey())
-			if err != nil {
-				svr.Log(err, "unable to get email from JWT")
+			profile, ok := middleware.IdentityFromContext(r.Context())
+			if !ok {
+				svr.Log(middleware.ErrNoIdentity, "unable to get email from JWT")
 				svr.JSON(w, http.StatusForbidden, nil)
 				return
 			}
@@ -407,9 +413,9 @@ func UpdateDeveloperMetadataHandler(svr server.Server, devRepo *developer.Reposi
 				svr.JSON(w, http.StatusBadRequest, nil)
 				return
 			}
-			profile, err := middleware.GetUserFromJWT(r, svr.SessionStore, svr.GetJWTSigningKey())
-			if err != nil {
-				svr.Log(err, "unable to get email from JWT")
+			profile, ok := middleware.IdentityFromContext(r.Context())
+			if !ok {
+				svr.Log(middleware.ErrNoIdentity, "unable to get email from JWT")
 				svr.JSON(w, http.StatusForbidden, nil)
 				return
 			}
@@ -446,7 +452,7 @@ func UpdateDeveloperMetadataHandler(svr server.Server, devRepo *developer.Reposi
 	)
 }
 
-func CreateDeveloperAccount(svr server.Server, userRepo tokenSaver) http.HandlerFunc {
+func CreateDeveloperAccount(svr server.Server, userRepo user.UserStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		payload := &struct {
 			Uid            string `json:"uid"`
@@ -467,12 +473,25 @@ func CreateDeveloperAccount(svr server.Server, userRepo tokenSaver) http.Handler
 			return
 		}
 
-		_, err := svr.GetAuthClient().VerifyIDToken(context.Background(), payload.AccessToken)
+		tk, err := svr.GetAuthClient().VerifyIDToken(context.Background(), payload.AccessToken)
 		if err != nil {
 			svr.Log(err, "error creating developer account")
 			svr.JSON(w, http.StatusInternalServerError, "internal error creating user")
 			return
 		}
+		// the google or github account may already be linked to a user, e.g. one who first
+		// signed in with the other provider, who must not end up with a second account
+		existing, err := linkedFirebaseUser(r.Context(), userRepo, tk)
+		if err != nil {
+			svr.Log(err, "error looking up linked identities")
+			svr.JSON(w, http.StatusInternalServerError, "internal error creating user")
+			return
+		}
+		if existing != nil {
+			linkFirebaseIdentities(r.Context(), svr, userRepo, existing.ID, tk)
+			svr.JSON(w, http.StatusOK, existing.ID)
+			return
+		}
 
 		u := user.User{
 			ID:             payload.Uid,
@@ -485,11 +504,17 @@ func CreateDeveloperAccount(svr server.Server, userRepo tokenSaver) http.Handler
 			Type:           "jobseeker",
 			IsAdmin:        false,
 		}
-		if err := userRepo.CreateUser(u); err != nil {
+		if err := userRepo.CreateUser(r.Context(), u); err != nil {
+			var validationErr *user.ValidationError
+			if errors.As(err, &validationErr) {
+				middleware.WriteJSONError(w, http.StatusBadRequest, "validation_failed", validationErr.Error(), map[string]string{validationErr.Field: validationErr.Message})
+				return
+			}
 			svr.Log(err, "error creating developer account")
 			svr.JSON(w, http.StatusInternalServerError, "internal error creating user")
 			return
 		}
+		linkFirebaseIdentities(r.Context(), svr, userRepo, u.ID, tk)
 
 		// TODO: send welcome email
 		// TODO: subscribe to marketting emails
@@ -609,8 +634,13 @@ func SaveDeveloperProfileHandler(svr server.Server, devRepo devGetSaver, userRep
 			RoleLevel:          req.RoleLevel,
 			DetectedLocationID: detectedLocationID,
 		}
-		// Use sign-on token from firebase.
-		err = userRepo.SaveTokenSignOn(strings.ToLower(req.Email), k.String(), user.UserTypeDeveloper)
+		signOnToken, err := user.GenerateSignOnToken()
+		if err != nil {
+			svr.Log(err, "unable to generate sign on token")
+			svr.JSON(w, http.StatusInternalServerError, nil)
+			return
+		}
+		err = userRepo.SaveTokenSignOn(r.Context(), strings.ToLower(req.Email), signOnToken, user.UserTypeDeveloper, false)
 		if err != nil {
 			svr.Log(err, "unable to save sign on token")
 			svr.JSON(w, http.StatusInternalServerError, nil)
@@ -638,7 +668,7 @@ func SaveDeveloperProfileHandler(svr server.Server, devRepo devGetSaver, userRep
 				svr.GetConfig().SiteName,
 				svr.GetConfig().URLProtocol,
 				svr.GetConfig().SiteHost,
-				k.String(),
+				signOnToken,
 			),
 		)
 		if err != nil {
@@ -1505,9 +1535,9 @@ func UpdateDeveloperProfileHandler(svr server.Server, devRepo *developer.Reposit
 				svr.JSON(w, http.StatusBadRequest, "too many skills")
 				return
 			}
-			profile, err := middleware.GetUserFromJWT(r, svr.SessionStore, svr.GetJWTSigningKey())
-			if err != nil {
-				svr.Log(err, "unable to get email from JWT")
+			profile, ok := middleware.IdentityFromContext(r.Context())
+			if !ok {
+				svr.Log(middleware.ErrNoIdentity, "unable to get email from JWT")
 				svr.JSON(w, http.StatusForbidden, nil)
 				return
 			}
@@ -1553,7 +1583,7 @@ func UpdateDeveloperProfileHandler(svr server.Server, devRepo *developer.Reposit
 	)
 }
 
-func DeleteDeveloperProfileHandler(svr server.Server, devRepo *developer.Repository, userRepo *user.Repository) http.HandlerFunc {
+func DeleteDeveloperProfileHandler(svr server.Server, devRepo *developer.Repository, userRepo user.UserStore) http.HandlerFunc {
 	return middleware.UserAuthenticatedMiddleware(
 		svr.SessionStore,
 		svr.GetAuthClient(),
@@ -1571,9 +1601,9 @@ func DeleteDeveloperProfileHandler(svr server.Server, devRepo *developer.Reposit
 				svr.JSON(w, http.StatusBadRequest, nil)
 				return
 			}
-			profile, err := middleware.GetUserFromJWT(r, svr.SessionStore, svr.GetJWTSigningKey())
-			if err != nil {
-				svr.Log(err, "unable to get email from JWT")
+			profile, ok := middleware.IdentityFromContext(r.Context())
+			if !ok {
+				svr.Log(middleware.ErrNoIdentity, "unable to get email from JWT")
 				svr.JSON(w, http.StatusForbidden, nil)
 				return
 			}
@@ -1581,22 +1611,35 @@ func DeleteDeveloperProfileHandler(svr server.Server, devRepo *developer.Reposit
 				svr.JSON(w, http.StatusForbidden, nil)
 				return
 			}
-			err = devRepo.DeleteDeveloperProfile(req.ID, req.Email)
-			if err != nil {
+			if profile.Email == req.Email {
+				// deleting your own profile deletes the account, which only happens once the
+				// emailed link is confirmed, see ConfirmAccountDeletionHandler
+				token, err := userRepo.RequestAccountDeletion(r.Context(), profile.UserID)
+				if err != nil {
+					svr.Log(err, "unable to request account deletion")
+					svr.JSON(w, http.StatusInternalServerError, nil)
+					return
+				}
+				if err := sendAccountDeletionEmail(svr, profile.Email, token); err != nil {
+					svr.Log(err, "unable to send account deletion email")
+					svr.JSON(w, http.StatusInternalServerError, nil)
+					return
+				}
+				svr.JSON(w, http.StatusOK, map[string]string{"status": "confirmation_sent"})
+				return
+			}
+			// admins disapproving someone else's profile only remove the profile, not the account
+			if err := devRepo.DeleteDeveloperProfile(req.ID, req.Email); err != nil {
 				svr.Log(err, "unable to delete developer profile")
 				svr.JSON(w, http.StatusInternalServerError, nil)
 				return
 			}
-			if imageErr := database.DeleteImageByID(svr.Conn, req.ImageID); imageErr != nil {
+			if err := database.DeleteImageByID(svr.Conn, req.ImageID); err != nil {
 				svr.Log(err, "unable to delete developer profile image id "+req.ImageID)
 				svr.JSON(w, http.StatusInternalServerError, nil)
 				return
 			}
-			if userErr := userRepo.DeleteUserByEmail(req.Email); userErr != nil {
-				svr.Log(err, "unable to delete user by email "+req.Email)
-				svr.JSON(w, http.StatusInternalServerError, nil)
-			}
-			svr.JSON(w, http.StatusOK, nil)
+			svr.JSON(w, http.StatusOK, map[string]string{"status": "deleted"})
 		},
 	)
 }
@@ -1667,6 +1710,54 @@ func AddEmailSubscriberHandler(svr server.Server) http.HandlerFunc {
 	}
 }
 
+// RequestBetaAccessHandler records a request to join the private beta posted by the form of
+// the coming soon page and lets the admin know about new ones. The visitor is sent back to the
+// page they were on, with the form flashed back to them when the email is invalid
+func RequestBetaAccessHandler(svr server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		back := r.PostFormValue("next")
+		if !middleware.SafeNextPath(back) {
+			back = "/"
+		}
+		emailStr := strings.ToLower(strings.TrimSpace(r.PostFormValue("email")))
+		if err := user.ValidateEmail(emailStr); err != nil {
+			var validationErr *user.ValidationError
+			errs := map[string]string{"email": "please enter a valid email"}
+			if errors.As(err, &validationErr) {
+				errs[validationErr.Field] = validationErr.Message
+			}
+			if err := svr.FlashForm(w, r, r.PostForm, errs); err != nil {
+				svr.Log(err, "unable to flash beta access request form")
+			}
+			http.Redirect(w, r, back, http.StatusSeeOther)
+			return
+		}
+		created, err := database.AddBetaAccessRequest(svr.Conn, emailStr)
+		if err != nil {
+			svr.Log(err, "unable to save beta access request")
+			svr.JSON(w, http.StatusInternalServerError, nil)
+			return
+		}
+		if created {
+			err = svr.GetEmail().SendHTMLEmail(
+				email.Address{Name: svr.GetEmail().DefaultSenderName(), Email: svr.GetEmail().NoReplySenderAddress()},
+				email.Address{Email: svr.GetConfig().AdminEmail},
+				email.Address{Name: svr.GetEmail().DefaultSenderName(), Email: svr.GetEmail().NoReplySenderAddress()},
+				fmt.Sprintf("Beta access request on %s", svr.GetConfig().SiteName),
+				fmt.Sprintf("%s asked to join the %s beta. Add the email to BETA_ALLOWLIST to let them in.", emailStr, svr.GetConfig().SiteName),
+			)
+			if err != nil {
+				svr.Log(err, "unable to notify admin of beta access request")
+			}
+		}
+		sep := "?"
+		if strings.Contains(back, "?") {
+			sep = "&"
+		}
+		http.Redirect(w, r, back+sep+middleware.BetaAccessRequestedParam+"=1", http.StatusSeeOther)
+	}
+}
+
 func SendMessageDeveloperProfileHandler(svr server.Server, devRepo *developer.Repository) http.HandlerFunc {
 	return middleware.UserAuthenticatedMiddleware(
 		svr.SessionStore,
@@ -1674,9 +1765,9 @@ func SendMessageDeveloperProfileHandler(svr server.Server, devRepo *developer.Re
 		func(w http.ResponseWriter, r *http.Request) {
 			vars := mux.Vars(r)
 			profileID := vars["id"]
-			sender, err := middleware.GetUserFromJWT(r, svr.SessionStore, svr.GetJWTSigningKey())
-			if err != nil {
-				svr.Log(err, "unable to get email from JWT")
+			sender, ok := middleware.IdentityFromContext(r.Context())
+			if !ok {
+				svr.Log(middleware.ErrNoIdentity, "unable to get email from JWT")
 				svr.JSON(w, http.StatusUnauthorized, "unauthorized")
 				return
 			}
@@ -1815,10 +1906,10 @@ func EditProfileHandler(svr server.Server, devRepo *developer.Repository, recRep
 		func(w http.ResponseWriter, r *http.Request) {
 			vars := mux.Vars(r)
 			profileID := vars["id"]
-			profile, err := middleware.GetUserFromJWT(r, svr.SessionStore, svr.GetJWTSigningKey())
-			if err != nil {
-				svr.Log(err, "unable to get email from JWT")
-				http.Redirect(w, r, "/auth", http.StatusUnauthorized)
+			profile, ok := middleware.IdentityFromContext(r.Context())
+			if !ok {
+				svr.Log(middleware.ErrNoIdentity, "unable to get email from JWT")
+				middleware.RedirectToAuth(w, r)
 				return
 			}
 			// todo: allow admin to edit any profile type
@@ -1831,11 +1922,11 @@ func EditProfileHandler(svr server.Server, devRepo *developer.Repository, recRep
 				devProjects, err := devRepo.DeveloperMetadataByProfileID("github", profileID)
 				if err != nil {
 					svr.Log(err, "unable to find developer profile")
-					http.Redirect(w, r, "/auth", http.StatusUnauthorized)
+					middleware.RedirectToAuth(w, r)
 					return
 				}
 				if dev.Email != profile.Email && !profile.IsAdmin {
-					http.Redirect(w, r, "/auth", http.StatusUnauthorized)
+					middleware.RedirectToAuth(w, r)
 					return
 				}
 				svr.Render(r, w, http.StatusOK, "edit-developer-profile.html", map[string]interface{}{
@@ -1848,15 +1939,15 @@ func EditProfileHandler(svr server.Server, devRepo *developer.Repository, recRep
 				rec, err := recRepo.RecruiterProfileByID(profileID)
 				if err != nil {
 					svr.Log(err, "unable to find recruiter profile")
-					http.Redirect(w, r, "/auth", http.StatusUnauthorized)
+					middleware.RedirectToAuth(w, r)
 					return
 				}
 				svr.Render(r, w, http.StatusOK, "edit-recruiter-profile.html", map[string]interface{}{
 					"RecruiterProfile": rec,
 				})
 			case user.UserTypeAdmin:
-				svr.Log(err, "admin does not have profile to edit yet")
-				http.Redirect(w, r, "/auth", http.StatusUnauthorized)
+				svr.Log(errors.New("admin has no profile"), "admin does not have profile to edit yet")
+				middleware.RedirectToAuth(w, r)
 				return
 			}
 		},
@@ -1867,7 +1958,7 @@ func ViewDeveloperProfileHandler(svr server.Server, devRepo *developer.Repositor
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		profileSlug := vars["slug"]
-		profile, _ := middleware.GetUserFromJWT(r, svr.SessionStore, svr.GetJWTSigningKey())
+		profile, _ := middleware.IdentityFromContext(r.Context())
 		if profile != nil && profile.Type == "recruiter" {
 			expTime, err := recruiterRepo.RecruiterProfilePlanExpiration(profile.Email)
 			if err == nil && expTime.Before(time.Now().UTC()) {
@@ -1890,7 +1981,7 @@ func ViewDeveloperProfileHandler(svr server.Server, devRepo *developer.Repositor
 		devProjects, err := devRepo.DeveloperMetadataByProfileID("github", dev.ID)
 		if err != nil {
 			svr.Log(err, "unable to find developer metadata")
-			http.Redirect(w, r, "/auth", http.StatusUnauthorized)
+			middleware.RedirectToAuth(w, r)
 			return
 		}
 		dev.UpdatedAtHumanized = dev.UpdatedAt.UTC().Format("January 2006")
@@ -1912,7 +2003,7 @@ func CompaniesForLocationHandler(svr server.Server, companyRepo *company.Reposit
 	}
 }
 
-func IndexPageHandler(svr server.Server, jobRepo *job.Repository, userRepo *user.Repository) http.HandlerFunc {
+func IndexPageHandler(svr server.Server, jobRepo *job.Repository, userRepo user.UserStore) http.HandlerFunc {
 	return middleware.InjectAuthTokenMiddleware(
 		svr.SessionStore,
 		svr.GetAuthClient(),
@@ -1967,7 +2058,7 @@ func IndexPageHandler(svr server.Server, jobRepo *job.Repository, userRepo *user
 			data := map[string]interface{}{}
 			tk, ok := r.Context().Value("authToken").(*auth.Token)
 			if ok {
-				profile, err := userRepo.GetUser(tk.UID)
+				profile, err := userRepo.GetUser(r.Context(), tk.UID)
 				if err != nil {
 					svr.Log(err, "failed to get user from user repo")
 					svr.JSON(w, http.StatusInternalServerError, "unauthorized access")
@@ -2074,7 +2165,7 @@ func SendFeedbackMessage(svr server.Server) http.HandlerFunc {
 	}
 }
 
-func FirebaseSignin(svr server.Server, userRepo *user.Repository) http.HandlerFunc {
+func FirebaseSignin(svr server.Server, userRepo user.UserStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		payload := &struct {
 			Uid            string `json:"uid"`
@@ -2083,6 +2174,7 @@ func FirebaseSignin(svr server.Server, userRepo *user.Repository) http.HandlerFu
 			AccessToken    string `json:"access_token"`
 			ExpirationTime int64  `json:"expiration_time"`
 			CreatedAt      int64  `json:"created_at"`
+			Remember       bool   `json:"remember"`
 		}{}
 		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 			svr.Log(err, "error signing in")
@@ -2090,20 +2182,21 @@ func FirebaseSignin(svr server.Server, userRepo *user.Repository) http.HandlerFu
 			return
 		}
 
-		_, err := svr.VerifyUserToken(payload.AccessToken)
+		tk, err := svr.VerifyUserToken(payload.AccessToken)
 		if err != nil {
 			svr.Log(err, "error verifying access token")
 			svr.JSON(w, http.StatusInternalServerError, "error verifying access token")
 			return
 		}
+		linkFirebaseIdentities(r.Context(), svr, userRepo, tk.UID, tk)
 
-		if err := userRepo.UpdateAccessToken(payload.Uid, payload.AccessToken); err != nil {
+		if err := userRepo.UpdateAccessToken(r.Context(), payload.Uid, payload.AccessToken); err != nil {
 			svr.Log(err, "error updating access token")
 			svr.JSON(w, http.StatusInternalServerError, "error update access token")
 			return
 		}
 
-		sess, err := svr.SessionStore.Get(r, "____gc")
+		sess, err := middleware.GetSession(r, svr.SessionStore)
 		if err != nil {
 			svr.TEXT(w, http.StatusInternalServerError, "Invalid or expired token")
 			svr.Log(err, "unable to get session cookie from request")
@@ -2111,7 +2204,8 @@ func FirebaseSignin(svr server.Server, userRepo *user.Repository) http.HandlerFu
 		}
 
 		sess.Values["jwt"] = payload.AccessToken
-		if err := sess.Save(r, w); err != nil {
+		middleware.SetSessionDuration(sess, payload.Remember)
+		if err := middleware.SaveSession(r, w, sess); err != nil {
 			svr.Log(err, "unable to save jwt into session cookie")
 			svr.JSON(w, http.StatusInternalServerError, nil)
 			return
@@ -2121,37 +2215,84 @@ func FirebaseSignin(svr server.Server, userRepo *user.Repository) http.HandlerFu
 	}
 }
 
-func RequestTokenSignOn(svr server.Server, userRepo *user.Repository) http.HandlerFunc {
+// firebaseProviders maps the firebase sign in providers to the ones users are linked to
+var firebaseProviders = map[string]string{
+	"google.com": user.ProviderGoogle,
+	"github.com": user.ProviderGitHub,
+}
+
+// firebaseIdentities are the identities a firebase token signs the user in with: the firebase
+// uid itself, then the google or github accounts firebase signed them in with
+func firebaseIdentities(tk *auth.Token) []user.Identity {
+	identities := []user.Identity{{Provider: user.ProviderFirebase, ExternalID: tk.UID}}
+	for firebaseProvider, ids := range tk.Firebase.Identities {
+		provider, ok := firebaseProviders[firebaseProvider]
+		if !ok {
+			continue
+		}
+		list, _ := ids.([]interface{})
+		for _, id := range list {
+			if externalID, ok := id.(string); ok && externalID != "" {
+				identities = append(identities, user.Identity{Provider: provider, ExternalID: externalID})
+			}
+		}
+	}
+	return identities
+}
+
+// linkedFirebaseUser returns the user already linked to one of the identities of tk, or nil
+func linkedFirebaseUser(ctx context.Context, userRepo user.UserStore, tk *auth.Token) (*user.User, error) {
+	for _, identity := range firebaseIdentities(tk) {
+		u, err := userRepo.GetUserByProviderID(ctx, identity.Provider, identity.ExternalID)
+		if err != nil || u != nil {
+			return u, err
+		}
+	}
+	return nil, nil
+}
+
+// linkFirebaseIdentities links userID to the identities of tk. Failures are only logged, they
+// don't block sign in
+func linkFirebaseIdentities(ctx context.Context, svr server.Server, userRepo user.UserStore, userID string, tk *auth.Token) {
+	for _, identity := range firebaseIdentities(tk) {
+		if err := userRepo.LinkIdentity(ctx, userID, identity.Provider, identity.ExternalID); err != nil {
+			svr.Log(err, fmt.Sprintf("unable to link %s identity", identity.Provider))
+		}
+	}
+}
+
+func RequestTokenSignOn(svr server.Server, userRepo user.UserStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		req := &struct {
-			Email string `json:"email"`
+			Email    string `json:"email"`
+			Remember bool   `json:"remember"`
 		}{}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			svr.JSON(w, http.StatusBadRequest, nil)
 			return
 		}
+		req.Email = strings.ToLower(strings.TrimSpace(req.Email))
 		if !svr.IsEmail(req.Email) {
 			svr.JSON(w, http.StatusBadRequest, nil)
 			return
 		}
-		userType, err := userRepo.GetUserTypeByEmail(req.Email)
+		userType, err := userRepo.GetUserTypeByEmail(r.Context(), req.Email)
 		if err != nil {
 			svr.JSON(w, http.StatusNotFound, nil)
 			return
 		}
-		k, err := ksuid.NewRandom()
+		token, err := user.GenerateSignOnToken()
 		if err != nil {
 			svr.Log(err, "unable to generate token")
 			svr.JSON(w, http.StatusBadRequest, nil)
 			return
 		}
-		err = userRepo.SaveTokenSignOn(req.Email, k.String(), userType)
+		err = userRepo.SaveTokenSignOn(r.Context(), req.Email, token, userType, req.Remember)
 		if err != nil {
 			svr.Log(err, "unable to save sign on token")
 			svr.JSON(w, http.StatusBadRequest, nil)
 			return
 		}
-		token := k.String()
 		err = svr.GetEmail().SendHTMLEmail(
 			email.Address{Name: svr.GetEmail().DefaultSenderName(), Email: svr.GetEmail().NoReplySenderAddress()},
 			email.Address{Email: req.Email},
@@ -2167,25 +2308,28 @@ func RequestTokenSignOn(svr server.Server, userRepo *user.Repository) http.Handl
 	}
 }
 
-func VerifyTokenSignOn(svr server.Server, userRepo *user.Repository, devRepo *developer.Repository, recRepo *recruiter.Repository, adminEmail string) http.HandlerFunc {
+func VerifyTokenSignOn(svr server.Server, userRepo user.UserStore, devRepo *developer.Repository, recRepo *recruiter.Repository, adminEmail string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		token := vars["token"]
-		u, _, err := userRepo.GetOrCreateUserFromToken(token)
+		u, _, remember, err := userRepo.CompleteSignOn(r.Context(), token)
 		if err != nil {
-			svr.Log(err, fmt.Sprintf("unable to validate signon token %s", token))
+			if err != user.ErrSignOnTokenInvalid && err != user.ErrSignOnTokenExpired {
+				svr.Log(err, fmt.Sprintf("unable to validate signon token %s", token))
+			}
 			svr.TEXT(w, http.StatusBadRequest, "Invalid or expired token")
 			return
 		}
 		fmt.Println("verify")
-		sess, err := svr.SessionStore.Get(r, "____gc")
+		sess, err := middleware.GetSession(r, svr.SessionStore)
 		if err != nil {
 			svr.TEXT(w, http.StatusInternalServerError, "Invalid or expired token")
 			svr.Log(err, "unable to get session cookie from request")
 			return
 		}
+		middleware.SetSessionDuration(sess, remember)
 		stdClaims := &jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(30 * 24 * time.Hour).UTC().Unix(),
+			ExpiresAt: time.Now().Add(middleware.SessionDuration(remember)).UTC().Unix(),
 			IssuedAt:  time.Now().UTC().Unix(),
 			Issuer:    fmt.Sprintf("%s%s", svr.GetConfig().URLProtocol, svr.GetConfig().SiteHost),
 		}
@@ -2197,12 +2341,14 @@ func VerifyTokenSignOn(svr server.Server, userRepo *user.Repository, devRepo *de
 			IsDeveloper:    u.Type == user.UserTypeDeveloper,
 			CreatedAt:      u.CreatedAt,
 			Type:           u.Type,
+			TokenVersion:   u.TokenVersion,
+			AuthTime:       stdClaims.IssuedAt,
 			StandardClaims: *stdClaims,
 		}
 		tkn := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 		ss, err := tkn.SignedString(svr.GetJWTSigningKey())
 		sess.Values["jwt"] = ss
-		err = sess.Save(r, w)
+		err = middleware.SaveSession(r, w, sess)
 		if err != nil {
 			svr.Log(err, "unable to save jwt into session cookie")
 			svr.JSON(w, http.StatusInternalServerError, nil)
@@ -2342,6 +2488,80 @@ func JobBySlugPageHandler(svr server.Server, jobRepo *job.Repository) http.Handl
 	}
 }
 
+// CreateJobPreviewTokenHandler issues a short lived preview link for a draft job posted with the
+// signed in user's email. It only needs a session, not a verified email, so recruiters can check
+// how their Ad looks before it is approved
+func CreateJobPreviewTokenHandler(svr server.Server, jobRepo *job.Repository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		profile, ok := middleware.IdentityFromContext(r.Context())
+		if !ok {
+			svr.JSON(w, http.StatusUnauthorized, nil)
+			return
+		}
+		jobID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			svr.JSON(w, http.StatusBadRequest, nil)
+			return
+		}
+		if _, err := jobRepo.DraftJobPostForPreview(jobID, profile.Email); err != nil {
+			svr.Log(err, fmt.Sprintf("unable to retrieve draft job %d for preview", jobID))
+			svr.JSON(w, http.StatusNotFound, nil)
+			return
+		}
+		token := middleware.NewPreviewToken(svr.GetJWTSigningKey(), profile.Email, jobPreviewResource(r), middleware.PreviewTokenTTL)
+		svr.JSON(w, http.StatusOK, map[string]interface{}{
+			"url":        fmt.Sprintf("%s%s/preview/job/%d?%s=%s", svr.GetConfig().URLProtocol, svr.GetConfig().SiteHost, jobID, middleware.PreviewTokenParam, url.QueryEscape(token)),
+			"expires_at": time.Now().Add(middleware.PreviewTokenTTL).Unix(),
+		})
+	}
+}
+
+// JobPreviewPageHandler renders a draft job for the holder of a preview token issued by
+// CreateJobPreviewTokenHandler. Views are not tracked and the page is never indexed
+func JobPreviewPageHandler(svr server.Server, jobRepo *job.Repository) http.HandlerFunc {
+	return middleware.PreviewMiddleware(
+		svr.GetJWTSigningKey(),
+		jobPreviewResource,
+		func(w http.ResponseWriter, r *http.Request) {
+			scope, _ := middleware.GetPreviewScope(r)
+			jobID, err := strconv.Atoi(mux.Vars(r)["id"])
+			if err != nil {
+				svr.JSON(w, http.StatusNotFound, nil)
+				return
+			}
+			jobPost, err := jobRepo.DraftJobPostForPreview(jobID, scope.Subject)
+			if err != nil {
+				svr.Log(err, fmt.Sprintf("unable to retrieve draft job %d for preview", jobID))
+				svr.JSON(w, http.StatusNotFound, nil)
+				return
+			}
+			w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+			jobPost.SalaryRange = fmt.Sprintf("%s%s to %s%s", jobPost.SalaryCurrency, humanize.Comma(jobPost.SalaryMin), jobPost.SalaryCurrency, humanize.Comma(jobPost.SalaryMax))
+			if err := svr.Render(r, w, http.StatusOK, "job.html", map[string]interface{}{
+				"Job":                     jobPost,
+				"JobURIEncoded":           url.QueryEscape(jobPost.Slug),
+				"IsQuickApply":            svr.IsEmail(jobPost.HowToApply),
+				"IsPreview":               true,
+				"HTMLJobDescription":      svr.MarkdownToHTML(jobPost.JobDescription),
+				"HTMLJobPerks":            svr.MarkdownToHTML(jobPost.Perks),
+				"HTMLJobInterviewProcess": svr.MarkdownToHTML(jobPost.InterviewProcess),
+				"ExternalJobId":           jobPost.ExternalID,
+				"MonthAndYear":            time.Unix(jobPost.CreatedAt, 0).UTC().Format("January 2006"),
+				"GoogleJobCreatedAt":      time.Unix(jobPost.CreatedAt, 0).Format(time.RFC3339),
+				"GoogleJobValidThrough":   time.Unix(jobPost.CreatedAt, 0).AddDate(0, 5, 0),
+				"GoogleJobLocation":       strings.Split(jobPost.Location, "/")[0],
+				"GoogleJobDescription":    strconv.Quote(strings.ReplaceAll(string(svr.MarkdownToHTML(jobPost.JobDescription)), "\n", "")),
+			}); err != nil {
+				svr.Log(err, "unable to render job preview")
+			}
+		},
+	)
+}
+
+func jobPreviewResource(r *http.Request) string {
+	return "job:" + mux.Vars(r)["id"]
+}
+
 func CompanyBySlugPageHandler(svr server.Server, companyRepo *company.Repository, jobRepo *job.Repository) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
@@ -2447,42 +2667,103 @@ func ServeRSSFeed(svr server.Server, jobRepo *job.Repository) http.HandlerFunc {
 			svr.XML(w, http.StatusInternalServerError, []byte{})
 			return
 		}
-		now := time.Now()
-		feed := &feeds.Feed{
-			Title:       fmt.Sprintf("%s Jobs", svr.GetConfig().SiteName),
-			Link:        &feeds.Link{Href: fmt.Sprintf("%s%s", svr.GetConfig().URLProtocol, svr.GetConfig().SiteHost)},
-			Description: fmt.Sprintf("%s Jobs RSS Feed", svr.GetConfig().SiteName),
-			Author:      &feeds.Author{Name: svr.GetConfig().SiteName, Email: svr.GetConfig().SupportEmail},
-			Created:     now,
-		}
-
+		items := make([]feedItem, 0, len(jobPosts))
 		for _, j := range jobPosts {
+			item := feedItem{
+				Title:       fmt.Sprintf("%s with %s - %s", j.JobTitle, j.Company, j.Location),
+				Link:        fmt.Sprintf("%s%s/job/%s", svr.GetConfig().URLProtocol, svr.GetConfig().SiteHost, j.Slug),
+				GUID:        j.Slug,
+				Published:   *j.ApprovedAt,
+				Description: string(svr.MarkdownToHTML(j.JobDescription + "\n\n**Salary Range:** " + j.SalaryRange)),
+			}
 			if j.CompanyIconID != "" {
-				feed.Items = append(feed.Items, &feeds.Item{
-					Title:       fmt.Sprintf("%s with %s - %s", j.JobTitle, j.Company, j.Location),
-					Link:        &feeds.Link{Href: fmt.Sprintf("%s%s/job/%s", svr.GetConfig().URLProtocol, svr.GetConfig().SiteHost, j.Slug)},
-					Description: string(svr.MarkdownToHTML(j.JobDescription + "\n\n**Salary Range:** " + j.SalaryRange)),
-					Author:      &feeds.Author{Name: svr.GetConfig().SiteName, Email: svr.GetConfig().SupportEmail},
-					Enclosure:   &feeds.Enclosure{Length: "not implemented", Type: "image", Url: fmt.Sprintf("%s%s/x/s/m/%s", svr.GetConfig().URLProtocol, svr.GetConfig().SiteHost, j.CompanyIconID)},
-					Created:     *j.ApprovedAt,
-				})
-			} else {
-				feed.Items = append(feed.Items, &feeds.Item{
-					Title:       fmt.Sprintf("%s with %s - %s", j.JobTitle, j.Company, j.Location),
-					Link:        &feeds.Link{Href: fmt.Sprintf("%s%s/job/%s", svr.GetConfig().URLProtocol, svr.GetConfig().SiteHost, j.Slug)},
-					Description: string(svr.MarkdownToHTML(j.JobDescription + "\n\n**Salary Range:** " + j.SalaryRange)),
-					Author:      &feeds.Author{Name: svr.GetConfig().SiteName, Email: svr.GetConfig().SupportEmail},
-					Created:     *j.ApprovedAt,
-				})
+				item.Enclosure = fmt.Sprintf("%s%s/x/s/m/%s", svr.GetConfig().URLProtocol, svr.GetConfig().SiteHost, j.CompanyIconID)
+			}
+			items = append(items, item)
+		}
+		if err := svr.RenderAs(r, w, http.StatusOK, "application/rss+xml; charset=utf-8", "jobs-rss.html", map[string]interface{}{
+			"FeedTitle":       fmt.Sprintf("%s Jobs", svr.GetConfig().SiteName),
+			"FeedDescription": fmt.Sprintf("%s Jobs RSS Feed", svr.GetConfig().SiteName),
+			"FeedLink":        fmt.Sprintf("%s%s", svr.GetConfig().URLProtocol, svr.GetConfig().SiteHost),
+			"FeedSelf":        middleware.AbsoluteURL(r, r.URL.RequestURI()),
+			"FeedUpdated":     time.Now().UTC(),
+			"FeedItems":       items,
+		}); err != nil {
+			svr.Log(err, "unable to render rss feed")
+		}
+	}
+}
+
+// Formats served by JobsFeedHandler
+const (
+	FeedRSS  = "rss"
+	FeedAtom = "atom"
+)
+
+type feedItem struct {
+	Title       string
+	Link        string
+	GUID        string
+	Published   time.Time
+	Description string
+	Enclosure   string // image shown next to the item, e.g. the company icon, if any
+}
+
+// JobsFeedHandler serves the latest jobs as RSS 2.0 or Atom. An empty format picks one from the
+// Accept header, defaulting to RSS. The l (location) and tag query parameters narrow the feed
+// like the job search does, so people can subscribe to e.g. remote jobs only, n sets the size
+func JobsFeedHandler(svr server.Server, jobRepo *job.Repository, format string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format := format
+		if format == "" {
+			format = FeedRSS
+			if accept := r.Header.Get("Accept"); strings.Contains(accept, "application/atom+xml") && !strings.Contains(accept, "application/rss+xml") {
+				format = FeedAtom
 			}
 		}
-		rssFeed, err := feed.ToRss()
+		q := r.URL.Query()
+		n := 20
+		if v, err := strconv.Atoi(q.Get("n")); err == nil && v > 0 && v <= 100 {
+			n = v
+		}
+		jobPosts, _, err := jobRepo.JobsByQuery(q.Get("l"), q.Get("tag"), 1, 0, "", n, false)
 		if err != nil {
-			svr.Log(err, "unable to convert rss feed to xml")
+			svr.Log(err, "unable to retrieve jobs for feed")
 			svr.XML(w, http.StatusInternalServerError, []byte{})
 			return
 		}
-		svr.XML(w, http.StatusOK, []byte(rssFeed))
+		items := make([]feedItem, 0, len(jobPosts))
+		var updated time.Time
+		for _, j := range jobPosts {
+			published := time.Unix(j.CreatedAt, 0).UTC()
+			if published.After(updated) {
+				updated = published
+			}
+			items = append(items, feedItem{
+				Title:       fmt.Sprintf("%s with %s - %s", j.JobTitle, j.Company, j.Location),
+				Link:        middleware.AbsoluteURL(r, "/job/"+j.Slug),
+				GUID:        j.Slug,
+				Published:   published,
+				Description: string(svr.MarkdownToHTML(j.JobDescription + "\n\n**Salary Range:** " + j.SalaryRange)),
+			})
+		}
+		if updated.IsZero() {
+			updated = time.Now().UTC()
+		}
+		view, contentType := "jobs-rss.html", "application/rss+xml; charset=utf-8"
+		if format == FeedAtom {
+			view, contentType = "jobs-atom.html", "application/atom+xml; charset=utf-8"
+		}
+		if err := svr.RenderAs(r, w, http.StatusOK, contentType, view, map[string]interface{}{
+			"FeedTitle":       fmt.Sprintf("%s Jobs", svr.GetConfig().SiteName),
+			"FeedDescription": fmt.Sprintf("Latest jobs posted on %s", svr.GetConfig().SiteName),
+			"FeedLink":        middleware.AbsoluteURL(r, "/"),
+			"FeedSelf":        middleware.AbsoluteURL(r, r.URL.RequestURI()),
+			"FeedUpdated":     updated,
+			"FeedItems":       items,
+		}); err != nil {
+			svr.Log(err, "unable to render jobs feed")
+		}
 	}
 }
 
@@ -2623,26 +2904,17 @@ func StripePaymentConfirmationWebhookHandler(svr server.Server, jobRepo *job.Rep
 
 func SitemapIndexHandler(svr server.Server) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		index := sitemap.NewSitemapIndex()
 		entries, err := database.GetSitemapIndex(svr.Conn, svr.GetConfig().SiteHost)
 		if err != nil {
 			svr.Log(err, "database.GetSitemapIndex")
 			svr.TEXT(w, http.StatusInternalServerError, "unable to fetch sitemap")
 			return
 		}
-		for _, e := range entries {
-			index.Add(&sitemap.URL{
-				Loc:     e.Loc,
-				LastMod: &e.LastMod,
-			})
-		}
-		buf := new(bytes.Buffer)
-		if _, err := index.WriteTo(buf); err != nil {
-			svr.Log(err, "sitemapIndex.WriteTo")
-			svr.TEXT(w, http.StatusInternalServerError, "unable to save sitemap index")
-			return
+		if err := svr.RenderAs(r, w, http.StatusOK, "application/xml; charset=utf-8", "sitemap-index.html", map[string]interface{}{
+			"Entries": entries,
+		}); err != nil {
+			svr.Log(err, "unable to render sitemap index")
 		}
-		svr.XML(w, http.StatusOK, buf.Bytes())
 	}
 }
 
@@ -2662,34 +2934,51 @@ func SitemapHandler(svr server.Server) http.HandlerFunc {
 			svr.TEXT(w, http.StatusInternalServerError, "unable to fetch sitemap")
 			return
 		}
-		sitemapFile := sitemap.New()
-		for _, e := range entries {
-			sitemapFile.Add(&sitemap.URL{
-				Loc:        e.Loc,
-				LastMod:    &e.LastMod,
-				ChangeFreq: sitemap.ChangeFreq(e.ChangeFreq),
-			})
+		if err := svr.RenderAs(r, w, http.StatusOK, "application/xml; charset=utf-8", "sitemap.html", map[string]interface{}{
+			"Entries": entries,
+		}); err != nil {
+			svr.Log(err, fmt.Sprintf("unable to render sitemap %d", number))
 		}
-		buf := new(bytes.Buffer)
-		if _, err := sitemapFile.WriteTo(buf); err != nil {
-			svr.Log(err, fmt.Sprintf("sitemapFile.WriteTo %d", number))
-			svr.TEXT(w, http.StatusInternalServerError, "unable to save sitemap file")
+	}
+}
+
+// HealthzHandler is the readiness check, it fails while the database is unreachable
+func HealthzHandler(svr server.Server, userRepo user.UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !userRepo.Healthy() {
+			svr.JSON(w, http.StatusServiceUnavailable, map[string]string{"status": "database unavailable"})
 			return
 		}
-		svr.XML(w, http.StatusOK, buf.Bytes())
+		svr.JSON(w, http.StatusOK, map[string]string{"status": "ok"})
 	}
 }
 
+// RobotsTXTHandler serves robots.txt in production and disallows all crawling everywhere
+// else, so staging deployments don't end up in search results
 func RobotsTXTHandler(svr server.Server, robotsTxtContent []byte) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if svr.GetConfig().Env != "prod" {
+			svr.TEXT(w, http.StatusOK, "User-agent: *\nDisallow: /\n")
+			return
+		}
 		svr.TEXT(w, http.StatusOK, strings.ReplaceAll(string(robotsTxtContent), "__host_placeholder__", svr.GetConfig().SiteHost))
 	}
 }
 
-func WellKnownSecurityHandler(svr server.Server, securityTxtContent []byte) http.HandlerFunc {
+func WellKnownSecurityHandler(svr server.Server) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		contentWithHost := strings.ReplaceAll(string(securityTxtContent), "__host_placeholder__", svr.GetConfig().SiteHost)
-		svr.TEXT(w, http.StatusOK, strings.ReplaceAll(contentWithHost, "__support_email_placeholder__", svr.GetConfig().SupportEmail))
+		cfg := svr.GetConfig()
+		expires := cfg.SecurityTxtExpires
+		if expires.IsZero() {
+			expires = time.Now().UTC().Truncate(24*time.Hour).AddDate(1, 0, 0)
+		}
+		svr.TEXT(w, http.StatusOK, middleware.SecurityTxt{
+			Contacts:           cfg.SecurityContacts,
+			Policy:             cfg.SecurityPolicyURL,
+			Expires:            expires,
+			PreferredLanguages: cfg.SecurityLanguages,
+			Canonical:          fmt.Sprintf("https://%s/.well-known/security.txt", cfg.SiteHost),
+		}.String())
 	}
 }
 
@@ -2826,7 +3115,7 @@ func ApplyForJobPageHandler(svr server.Server, jobRepo *job.Repository) http.Han
 			svr.JSON(w, http.StatusBadRequest, nil)
 			return
 		}
-		profile, _ := middleware.GetUserFromJWT(r, svr.SessionStore, svr.GetJWTSigningKey())
+		profile, _ := middleware.IdentityFromContext(r.Context())
 		// user is not logged in
 		// standard flow to confirm application
 		if profile == nil {
@@ -3095,9 +3384,9 @@ func DeveloperDirectoryUpsellPageHandler(svr server.Server, jobRepo *job.Reposit
 				svr.JSON(w, http.StatusBadRequest, err.Error())
 				return
 			}
-			profile, err := middleware.GetUserFromJWT(r, svr.SessionStore, svr.GetJWTSigningKey())
-			if err != nil {
-				svr.Log(err, "unable to retrieve user from JWT")
+			profile, ok := middleware.IdentityFromContext(r.Context())
+			if !ok {
+				svr.Log(middleware.ErrNoIdentity, "unable to retrieve user from JWT")
 				svr.JSON(w, http.StatusForbidden, nil)
 				return
 			}
@@ -3929,9 +4218,9 @@ func EditBlogPostHandler(svr server.Server, blogPostRepo *blog.Repository) http.
 		func(w http.ResponseWriter, r *http.Request) {
 			vars := mux.Vars(r)
 			id := vars["id"]
-			profile, err := middleware.GetUserFromJWT(r, svr.SessionStore, svr.GetJWTSigningKey())
-			if err != nil {
-				svr.Log(err, "unable to retrieve user from JWT")
+			profile, ok := middleware.IdentityFromContext(r.Context())
+			if !ok {
+				svr.Log(middleware.ErrNoIdentity, "unable to retrieve user from JWT")
 				svr.JSON(w, http.StatusForbidden, nil)
 				return
 			}
@@ -3978,9 +4267,9 @@ func CreateBlogPostHandler(svr server.Server, blogPostRepo *blog.Repository) htt
 				svr.JSON(w, http.StatusBadRequest, nil)
 				return
 			}
-			profile, err := middleware.GetUserFromJWT(r, svr.SessionStore, svr.GetJWTSigningKey())
-			if err != nil {
-				svr.Log(err, "unable to retrieve user from JWT")
+			profile, ok := middleware.IdentityFromContext(r.Context())
+			if !ok {
+				svr.Log(middleware.ErrNoIdentity, "unable to retrieve user from JWT")
 				svr.JSON(w, http.StatusForbidden, nil)
 				return
 			}
@@ -4024,9 +4313,9 @@ func UpdateBlogPostHandler(svr server.Server, blogPostRepo *blog.Repository) htt
 				svr.JSON(w, http.StatusBadRequest, nil)
 				return
 			}
-			profile, err := middleware.GetUserFromJWT(r, svr.SessionStore, svr.GetJWTSigningKey())
-			if err != nil {
-				svr.Log(err, "unable to retrieve user from JWT")
+			profile, ok := middleware.IdentityFromContext(r.Context())
+			if !ok {
+				svr.Log(middleware.ErrNoIdentity, "unable to retrieve user from JWT")
 				svr.JSON(w, http.StatusForbidden, nil)
 				return
 			}
@@ -4055,9 +4344,9 @@ func PublishBlogPostHandler(svr server.Server, blogPostRepo *blog.Repository) ht
 		func(w http.ResponseWriter, r *http.Request) {
 			vars := mux.Vars(r)
 			id := vars["id"]
-			profile, err := middleware.GetUserFromJWT(r, svr.SessionStore, svr.GetJWTSigningKey())
-			if err != nil {
-				svr.Log(err, "unable to get email from JWT")
+			profile, ok := middleware.IdentityFromContext(r.Context())
+			if !ok {
+				svr.Log(middleware.ErrNoIdentity, "unable to get email from JWT")
 				svr.JSON(w, http.StatusForbidden, nil)
 				return
 			}
@@ -4082,9 +4371,9 @@ func UnpublishBlogPostHandler(svr server.Server, blogPostRepo *blog.Repository)
 		func(w http.ResponseWriter, r *http.Request) {
 			vars := mux.Vars(r)
 			id := vars["id"]
-			profile, err := middleware.GetUserFromJWT(r, svr.SessionStore, svr.GetJWTSigningKey())
-			if err != nil {
-				svr.Log(err, "unable to get email from JWT")
+			profile, ok := middleware.IdentityFromContext(r.Context())
+			if !ok {
+				svr.Log(middleware.ErrNoIdentity, "unable to get email from JWT")
 				svr.JSON(w, http.StatusForbidden, nil)
 				return
 			}
@@ -4122,9 +4411,9 @@ func GetUserBlogPostsHandler(svr server.Server, blogPostRepo *blog.Repository) h
 		svr.SessionStore,
 		svr.GetAuthClient(),
 		func(w http.ResponseWriter, r *http.Request) {
-			profile, err := middleware.GetUserFromJWT(r, svr.SessionStore, svr.GetJWTSigningKey())
-			if err != nil {
-				svr.Log(err, "unable to get email from JWT")
+			profile, ok := middleware.IdentityFromContext(r.Context())
+			if !ok {
+				svr.Log(middleware.ErrNoIdentity, "unable to get email from JWT")
 				svr.JSON(w, http.StatusForbidden, nil)
 				return
 			}
@@ -4142,7 +4431,7 @@ func GetUserBlogPostsHandler(svr server.Server, blogPostRepo *blog.Repository) h
 	)
 }
 
-func ProfileHomepageHandler(svr server.Server, devRepo *developer.Repository, recRepo *recruiter.Repository, userRepo *user.Repository) http.HandlerFunc {
+func ProfileHomepageHandler(svr server.Server, devRepo *developer.Repository, recRepo *recruiter.Repository, userRepo user.UserStore) http.HandlerFunc {
 	return middleware.UserAuthenticatedPageMiddleware(
 		svr.SessionStore,
 		svr.GetAuthClient(),
@@ -4153,7 +4442,7 @@ func ProfileHomepageHandler(svr server.Server, devRepo *developer.Repository, re
 				svr.JSON(w, http.StatusInternalServerError, "unauthorized access")
 				return
 			}
-			profile, err := userRepo.GetUser(tk.UID)
+			profile, err := userRepo.GetUser(r.Context(), tk.UID)
 			if err != nil {
 				svr.Log(err, "failed to get user from user repo")
 				svr.JSON(w, http.StatusInternalServerError, "unauthorized access")
@@ -4216,18 +4505,312 @@ func ProfileHomepageHandler(svr server.Server, devRepo *developer.Repository, re
 	)
 }
 
-func TriggerExpiredUserSignOnTokensTask(svr server.Server, userRepo *user.Repository) http.HandlerFunc {
+func TriggerExpiredUserSignOnTokensTask(svr server.Server, userRepo user.UserStore) http.HandlerFunc {
 	return middleware.MachineAuthenticatedMiddleware(
 		svr.GetConfig().MachineToken,
 		func(w http.ResponseWriter, r *http.Request) {
 			go func() {
-				err := userRepo.DeleteExpiredUserSignOnTokens()
+				report, err := userRepo.RunMaintenance(context.Background(), user.MaintenanceOptions{
+					SignOnTokens:          true,
+					AccountDeletionTokens: true,
+					Sessions:              svr.GetConfig().SessionStore == "postgres",
+					AccessTokens:          true,
+				})
 				if err != nil {
-					svr.Log(err, "unable to delete expired user_sign_on_tokens")
+					svr.Log(err, "unable to purge expired ephemeral rows")
 					return
 				}
+				for table, n := range report.Deleted {
+					log.Printf("maintenance purged %d expired rows from %s in %s", n, table, report.Duration[table])
+				}
 			}()
 			svr.JSON(w, http.StatusOK, map[string]interface{}{"status": "ok"})
 		},
 	)
 }
+
+func ImpersonateUserHandler(svr server.Server, userRepo user.UserStore) http.HandlerFunc {
+	return middleware.AdminAuthenticatedMiddleware(
+		svr.SessionStore,
+		svr.GetJWTSigningKey(),
+		func(w http.ResponseWriter, r *http.Request) {
+			profile, ok := middleware.IdentityFromContext(r.Context())
+			if !ok {
+				svr.JSON(w, http.StatusUnauthorized, nil)
+				return
+			}
+			targetUserID := mux.Vars(r)["id"]
+			err := middleware.Impersonate(w, r, svr.SessionStore, userRepo, profile, targetUserID)
+			switch err {
+			case nil:
+			case middleware.ErrNotAdmin, middleware.ErrCannotImpersonateAdmin:
+				svr.JSON(w, http.StatusForbidden, map[string]string{"error": err.Error()})
+				return
+			case middleware.ErrImpersonatedUserMissing:
+				svr.JSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+				return
+			default:
+				svr.Log(err, fmt.Sprintf("unable to impersonate user %s", targetUserID))
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			svr.Redirect(w, r, http.StatusSeeOther, "/")
+		},
+	)
+}
+
+func StopImpersonationHandler(svr server.Server, userRepo user.UserStore) http.HandlerFunc {
+	return middleware.AdminAuthenticatedMiddleware(
+		svr.SessionStore,
+		svr.GetJWTSigningKey(),
+		func(w http.ResponseWriter, r *http.Request) {
+			if err := middleware.StopImpersonation(w, r, svr.SessionStore, userRepo); err != nil {
+				svr.Log(err, "unable to stop impersonation")
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			svr.Redirect(w, r, http.StatusSeeOther, "/manage/list")
+		},
+	)
+}
+
+// SetPreferredCurrencyHandler stores the currency job salaries are shown in for the signed in
+// user, an empty currency goes back to showing each job in its own currency
+func SetPreferredCurrencyHandler(svr server.Server, userRepo user.UserStore) http.HandlerFunc {
+	return middleware.UserAuthenticatedMiddleware(
+		svr.SessionStore,
+		svr.GetAuthClient(),
+		func(w http.ResponseWriter, r *http.Request) {
+			req := &struct {
+				Currency string `json:"currency"`
+			}{}
+			if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+				svr.JSON(w, http.StatusBadRequest, nil)
+				return
+			}
+			tk, ok := r.Context().Value("authToken").(*auth.Token)
+			if !ok {
+				svr.JSON(w, http.StatusUnauthorized, nil)
+				return
+			}
+			err := userRepo.SetPreferredCurrency(r.Context(), tk.UID, req.Currency)
+			switch err {
+			case nil:
+			case user.ErrUnsupportedCurrency:
+				middleware.WriteJSONError(w, http.StatusBadRequest, "unsupported_currency", err.Error(), map[string]string{"currency": err.Error()})
+				return
+			default:
+				svr.Log(err, "unable to set preferred currency")
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			svr.JSON(w, http.StatusOK, nil)
+		},
+	)
+}
+
+// RequestAccountDeletionHandler emails the signed in user a link that deletes their account
+// once opened, see ConfirmAccountDeletionHandler
+func RequestAccountDeletionHandler(svr server.Server, userRepo user.UserStore) http.HandlerFunc {
+	return middleware.UserAuthenticatedMiddleware(
+		svr.SessionStore,
+		svr.GetAuthClient(),
+		func(w http.ResponseWriter, r *http.Request) {
+			tk, ok := r.Context().Value("authToken").(*auth.Token)
+			if !ok {
+				svr.JSON(w, http.StatusUnauthorized, nil)
+				return
+			}
+			profile, err := userRepo.GetUser(r.Context(), tk.UID)
+			if err != nil || profile == nil {
+				svr.JSON(w, http.StatusNotFound, nil)
+				return
+			}
+			token, err := userRepo.RequestAccountDeletion(r.Context(), profile.ID)
+			if err != nil {
+				svr.Log(err, "unable to request account deletion")
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			if err := sendAccountDeletionEmail(svr, profile.Email, token); err != nil {
+				svr.Log(err, "unable to send account deletion email")
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			svr.JSON(w, http.StatusOK, nil)
+		},
+	)
+}
+
+// sendAccountDeletionEmail sends the link confirming the deletion of the account of to
+func sendAccountDeletionEmail(svr server.Server, to, token string) error {
+	return svr.GetEmail().SendHTMLEmail(
+		email.Address{Name: svr.GetEmail().DefaultSenderName(), Email: svr.GetEmail().NoReplySenderAddress()},
+		email.Address{Email: to},
+		email.Address{Name: svr.GetEmail().DefaultSenderName(), Email: svr.GetEmail().SupportSenderAddress()},
+		fmt.Sprintf("Confirm the deletion of your %s account", svr.GetConfig().SiteName),
+		fmt.Sprintf("Someone, hopefully you, asked to delete your %s account. Open this link within the next hour to confirm, this cannot be undone: %s%s/x/account/delete/%s<br /><br />If you didn't ask for it you can ignore this email, your account is safe.", svr.GetConfig().SiteName, svr.GetConfig().URLProtocol, svr.GetConfig().SiteHost, token))
+}
+
+// ConfirmAccountDeletionHandler deletes the account the emailed token was issued for. Opening
+// the link only shows a confirmation button, so link scanners prefetching it delete nothing
+func ConfirmAccountDeletionHandler(svr server.Server, userRepo user.UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			svr.MEDIA(w, http.StatusOK, []byte(`<!DOCTYPE html><html><body><form method="POST"><p>Delete your account? This cannot be undone.</p><button type="submit">Delete my account</button></form></body></html>`), "text/html; charset=utf-8")
+			return
+		}
+		err := userRepo.ConfirmAccountDeletion(r.Context(), mux.Vars(r)["token"])
+		switch err {
+		case nil:
+		case user.ErrAccountDeletionTokenInvalid, user.ErrAccountDeletionTokenExpired, user.ErrUserNotFound:
+			svr.TEXT(w, http.StatusBadRequest, "Invalid or expired link, please ask to delete your account again")
+			return
+		default:
+			svr.Log(err, "unable to confirm account deletion")
+			svr.TEXT(w, http.StatusInternalServerError, "Unable to delete your account, please try again")
+			return
+		}
+		if sess, err := middleware.GetSession(r, svr.SessionStore); err == nil {
+			delete(sess.Values, "jwt")
+			if err := middleware.SaveSession(r, w, sess); err != nil {
+				svr.Log(err, "unable to clear session after account deletion")
+			}
+		}
+		svr.TEXT(w, http.StatusOK, "Your account has been deleted")
+	}
+}
+
+// currentUser is the stable JSON schema of /api/me, fields are only ever added
+type currentUser struct {
+	ID            string    `json:"id"`
+	Email         string    `json:"email"`
+	Type          string    `json:"type"`
+	EmailVerified bool      `json:"email_verified"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// CurrentUserHandler returns the signed in user, for the front-end to know who it is talking to
+func CurrentUserHandler(svr server.Server, userRepo user.UserStore) http.HandlerFunc {
+	return middleware.UserAuthenticatedMiddleware(
+		svr.SessionStore,
+		svr.GetAuthClient(),
+		func(w http.ResponseWriter, r *http.Request) {
+			tk, ok := r.Context().Value("authToken").(*auth.Token)
+			if !ok {
+				svr.JSON(w, http.StatusUnauthorized, nil)
+				return
+			}
+			profile, err := userRepo.GetUser(r.Context(), tk.UID)
+			if err != nil {
+				svr.Log(err, "unable to get current user")
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			if profile == nil {
+				svr.JSON(w, http.StatusNotFound, nil)
+				return
+			}
+			svr.JSON(w, http.StatusOK, currentUser{
+				ID:            profile.ID,
+				Email:         profile.Email,
+				Type:          profile.Type,
+				EmailVerified: profile.EmailVerified,
+				CreatedAt:     profile.CreatedAt,
+			})
+		},
+	)
+}
+
+// ViewAsPublicHandler lets an admin browse public pages as a signed out visitor, on=1 turns
+// it on and anything else turns it off
+func ViewAsPublicHandler(svr server.Server) http.HandlerFunc {
+	return middleware.AdminAuthenticatedMiddleware(
+		svr.SessionStore,
+		svr.GetJWTSigningKey(),
+		func(w http.ResponseWriter, r *http.Request) {
+			on := r.URL.Query().Get("on") == "1"
+			err := middleware.SetViewAsPublic(w, r, svr.SessionStore, svr.GetJWTSigningKey(), on)
+			switch err {
+			case nil:
+			case middleware.ErrNotAdmin:
+				svr.JSON(w, http.StatusForbidden, map[string]string{"error": err.Error()})
+				return
+			default:
+				svr.Log(err, "unable to toggle view as public")
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			if on {
+				svr.Redirect(w, r, http.StatusSeeOther, "/")
+				return
+			}
+			svr.Redirect(w, r, http.StatusSeeOther, "/manage/list")
+		},
+	)
+}
+
+// ExportUsersCSVHandler streams the users matching the type, email_verified, email,
+// created_after and created_before (YYYY-MM-DD) query filters as a csv attachment
+func ExportUsersCSVHandler(svr server.Server, userRepo user.UserStore) http.HandlerFunc {
+	return middleware.AdminAuthenticatedMiddleware(
+		svr.SessionStore,
+		svr.GetJWTSigningKey(),
+		func(w http.ResponseWriter, r *http.Request) {
+			q := r.URL.Query()
+			filter := user.ListFilter{
+				Type:  q.Get("type"),
+				Email: q.Get("email"),
+			}
+			if v := q.Get("email_verified"); v != "" {
+				verified, err := strconv.ParseBool(v)
+				if err != nil {
+					svr.JSON(w, http.StatusBadRequest, map[string]string{"error": "email_verified must be true or false"})
+					return
+				}
+				filter.EmailVerified = &verified
+			}
+			var err error
+			if v := q.Get("created_after"); v != "" {
+				if filter.CreatedAfter, err = time.Parse("2006-01-02", v); err != nil {
+					svr.JSON(w, http.StatusBadRequest, map[string]string{"error": "created_after must be YYYY-MM-DD"})
+					return
+				}
+			}
+			if v := q.Get("created_before"); v != "" {
+				if filter.CreatedBefore, err = time.Parse("2006-01-02", v); err != nil {
+					svr.JSON(w, http.StatusBadRequest, map[string]string{"error": "created_before must be YYYY-MM-DD"})
+					return
+				}
+			}
+
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="users-%s.csv"`, time.Now().UTC().Format("20060102-150405")))
+			cw := csv.NewWriter(w)
+			cw.Write([]string{"id", "email", "type", "email_verified", "created_at"})
+			rows := 0
+			// the export streams for as long as it takes, so lift the per query timeout
+			ctx := database.WithQueryTimeout(r.Context(), 0)
+			err = userRepo.EachUser(ctx, filter, func(u user.User) error {
+				if err := cw.Write([]string{u.ID, u.Email, u.Type, strconv.FormatBool(u.EmailVerified), u.CreatedAt.UTC().Format(time.RFC3339)}); err != nil {
+					return err
+				}
+				// flush every so often so the download progresses instead of buffering the whole export
+				rows++
+				if rows%500 == 0 {
+					cw.Flush()
+					return cw.Error()
+				}
+				return nil
+			})
+			cw.Flush()
+			if err == nil {
+				err = cw.Error()
+			}
+			if err != nil && r.Context().Err() == nil {
+				// headers are already sent, all that can be done is to log the truncated export
+				svr.Log(err, "unable to export users csv")
+			}
+		},
+	)
+}
@@ -115,6 +115,7 @@ type JobPost struct {
 	Company                         string
 	CompanyURL                      string
 	SalaryRange                     string
+	OriginalSalaryRange             string // SalaryRange in the posted currency when SalaryRange was converted, empty otherwise
 	Location                        string
 	JobDescription                  string
 	Perks                           string
@@ -182,3 +183,31 @@ type Applicant struct {
 	ConfirmedAt pq.NullTime
 	CvSize      int
 }
+
+// SearchQuery holds the user supplied terms and filters for SearchJobs. Zero values disable a filter
+type SearchQuery struct {
+	Text       string
+	Location   string
+	RemoteOnly bool
+	SalaryMin  int64
+	SalaryMax  int64
+	Page       int
+	PerPage    int
+}
+
+type JobResult struct {
+	ID             int
+	JobTitle       string
+	Company        string
+	Location       string
+	SalaryRange    string
+	SalaryMin      int64
+	SalaryMax      int64
+	SalaryCurrency string
+	SalaryPeriod   string
+	Slug           string
+	ExternalID     string
+	CompanyIconID  string
+	CreatedAt      time.Time
+	Rank           float64
+}
@@ -1,6 +1,7 @@
 package job
 
 import (
+	"context"
 	"database/sql"
 	"encoding/binary"
 	"errors"
@@ -9,16 +10,34 @@ import (
 	"strings"
 	"time"
 
+	"github.com/golang-cafe/job-board/internal/search"
 	"github.com/gosimple/slug"
+	"github.com/lib/pq"
 	"github.com/segmentio/ksuid"
 )
 
 type Repository struct {
-	db *sql.DB
+	db    *sql.DB
+	index search.IndexSyncer
 }
 
 func NewRepository(db *sql.DB) *Repository {
-	return &Repository{db}
+	return &Repository{db: db, index: search.NopSyncer{}}
+}
+
+// WithIndexSyncer keeps the external search index in sync with jobs written through the repository
+func (r *Repository) WithIndexSyncer(s search.IndexSyncer) *Repository {
+	r.index = s
+	return r
+}
+
+func jobDocumentID(jobID int) string {
+	return "job:" + strconv.Itoa(jobID)
+}
+
+// indexJob sends the changed fields of the job to the search index
+func (r *Repository) indexJob(jobID int, fields map[string]interface{}) {
+	r.index.Upsert(search.Document{ID: jobDocumentID(jobID), Type: "job", Fields: fields})
 }
 
 func (r *Repository) TrackJobView(job *JobPost) error {
@@ -155,6 +174,19 @@ func (r *Repository) SaveDraft(job *JobRq) (int, error) {
 	if err := res.Scan(&lastInsertID); err != nil {
 		return 0, err
 	}
+	r.indexJob(lastInsertID, map[string]interface{}{
+		"job_title":       job.JobTitle,
+		"company":         job.Company,
+		"location":        job.Location,
+		"description":     job.Description,
+		"salary_min":      salaryMinInt,
+		"salary_max":      salaryMaxInt,
+		"salary_currency": job.SalaryCurrency,
+		"slug":            slugTitle,
+		"created_at":      createdAt,
+		"approved":        false,
+		"expired":         false,
+	})
 	return int(lastInsertID), err
 }
 
@@ -188,6 +220,15 @@ func (r *Repository) UpdateJob(job *JobRqUpdate, jobID int) error {
 	if err != nil {
 		return err
 	}
+	r.indexJob(jobID, map[string]interface{}{
+		"job_title":       job.JobTitle,
+		"company":         job.Company,
+		"location":        job.Location,
+		"description":     job.Description,
+		"salary_min":      salaryMinInt,
+		"salary_max":      salaryMaxInt,
+		"salary_currency": job.SalaryCurrency,
+	})
 	return err
 }
 
@@ -199,6 +240,7 @@ func (r *Repository) ApproveJob(jobID int) error {
 	if err != nil {
 		return err
 	}
+	r.indexJob(jobID, map[string]interface{}{"approved": true})
 	return err
 }
 
@@ -210,6 +252,7 @@ func (r *Repository) DisapproveJob(jobID int) error {
 	if err != nil {
 		return err
 	}
+	r.indexJob(jobID, map[string]interface{}{"approved": false})
 	return err
 }
 
@@ -381,6 +424,35 @@ func (r *Repository) JobPostBySlug(slug string) (*JobPost, error) {
 	return job, nil
 }
 
+// DraftJobPostForPreview returns the job with jobID only while it is still waiting for approval
+// and was posted with companyEmail, so a recruiter can preview their own draft and nothing else
+func (r *Repository) DraftJobPostForPreview(jobID int, companyEmail string) (*JobPost, error) {
+	job := &JobPost{}
+	row := r.db.QueryRow(
+		`SELECT id, job_title, company, company_url, salary_range, location, description, perks, interview_process, how_to_apply, created_at, url_id, slug, salary_min, salary_max, salary_currency, company_icon_image_id, external_id, salary_period
+		FROM job
+		WHERE id = $1
+		AND approved_at IS NULL
+		AND LOWER(company_email) = LOWER($2)`, jobID, companyEmail)
+	var createdAt time.Time
+	var perks, interview, companyIcon sql.NullString
+	err := row.Scan(&job.ID, &job.JobTitle, &job.Company, &job.CompanyURL, &job.SalaryRange, &job.Location, &job.JobDescription, &perks, &interview, &job.HowToApply, &createdAt, &job.CreatedAt, &job.Slug, &job.SalaryMin, &job.SalaryMax, &job.SalaryCurrency, &companyIcon, &job.ExternalID, &job.SalaryPeriod)
+	if err != nil {
+		return nil, err
+	}
+	if companyIcon.Valid {
+		job.CompanyIconID = companyIcon.String
+	}
+	if perks.Valid {
+		job.Perks = perks.String
+	}
+	if interview.Valid {
+		job.InterviewProcess = interview.String
+	}
+	job.TimeAgo = createdAt.UTC().Format("January 2006")
+	return job, nil
+}
+
 func (r *Repository) JobPostBySlugAdmin(slug string) (*JobPost, error) {
 	job := &JobPost{}
 	row := r.db.QueryRow(
@@ -555,6 +627,7 @@ func (r *Repository) DeleteJobCascade(jobID int) error {
 	); err != nil {
 		return err
 	}
+	r.index.Delete(jobDocumentID(jobID))
 	return nil
 }
 
@@ -783,6 +856,29 @@ func (r *Repository) JobsByQuery(location, tag string, pageId, salary int, curre
 	return jobs, fullRowsCount, nil
 }
 
+// SalaryCurrencyISOByJobIDs returns the ISO 4217 salary currency of each of the jobs, keyed by job id
+func (r *Repository) SalaryCurrencyISOByJobIDs(jobIDs []int) (map[int]string, error) {
+	ids := make([]int64, len(jobIDs))
+	for i, id := range jobIDs {
+		ids[i] = int64(id)
+	}
+	rows, err := r.db.Query(`SELECT id, salary_currency_iso FROM job WHERE id = ANY($1) AND salary_currency_iso IS NOT NULL`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	codes := make(map[int]string, len(jobIDs))
+	for rows.Next() {
+		var id int
+		var code string
+		if err := rows.Scan(&id, &code); err != nil {
+			return nil, err
+		}
+		codes[id] = strings.TrimSpace(code)
+	}
+	return codes, rows.Err()
+}
+
 func (r *Repository) TokenByJobID(jobID int) (string, error) {
 	tokenRow := r.db.QueryRow(
 		`SELECT token
@@ -864,7 +960,35 @@ func (r *Repository) GetLastNJobsFromID(max, jobID int) ([]*JobPost, error) {
 
 func (r *Repository) MarkJobAsExpired(jobID int) error {
 	_, err := r.db.Exec(`UPDATE job SET expired = true WHERE id = $1`, jobID)
-	return err
+	if err != nil {
+		return err
+	}
+	r.indexJob(jobID, map[string]interface{}{"expired": true})
+	return nil
+}
+
+// CountActiveJobsByRecruiter counts the approved, non expired jobs posted with the email of
+// the recruiter profile recruiterID. Drafts awaiting approval don't count
+func (r *Repository) CountActiveJobsByRecruiter(ctx context.Context, recruiterID string) (int, error) {
+	var count int
+	err := r.db.QueryRowContext(ctx, `SELECT COUNT(*)
+		FROM job
+		JOIN recruiter_profile rp ON LOWER(rp.email) = LOWER(job.company_email)
+		WHERE rp.id = $1 AND job.approved_at IS NOT NULL AND job.expired IS NOT TRUE`, recruiterID).Scan(&count)
+	return count, err
+}
+
+// CanPostJob reports whether the recruiter is below limit active jobs, the cap of their plan.
+// A limit of 0 or less means unlimited
+func (r *Repository) CanPostJob(ctx context.Context, recruiterID string, limit int) (bool, error) {
+	if limit <= 0 {
+		return true, nil
+	}
+	count, err := r.CountActiveJobsByRecruiter(ctx, recruiterID)
+	if err != nil {
+		return false, err
+	}
+	return count < limit, nil
 }
 
 func (r *Repository) NewJobsLastWeekOrMonth() (int, int, error) {
@@ -987,7 +1111,11 @@ func (r *Repository) UpdateJobPlan(jobID int, planType string, planDuration int,
 		expiration.CompanyPageEligibilityExpiredAt,
 		expiration.PlanExpiredAt,
 		jobID)
-	return err
+	if err != nil {
+		return err
+	}
+	r.indexJob(jobID, map[string]interface{}{"approved": true, "plan_type": planType, "plan_expired_at": expiration.PlanExpiredAt})
+	return nil
 }
 
 func (r *Repository) GetClickoutCountForJob(jobID int) (int, error) {
@@ -0,0 +1,93 @@
+package job
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+const defaultSearchPerPage = 20
+
+// SearchJobs runs a ranked full-text search over approved, non expired jobs. The text is
+// parsed with plainto_tsquery so user input never reaches the query as syntax. Results
+// are ordered by rank (then recency) and the total number of matches is returned for pagination
+func (r *Repository) SearchJobs(ctx context.Context, q SearchQuery) ([]JobResult, int, error) {
+	results := []JobResult{}
+	if q.PerPage <= 0 {
+		q.PerPage = defaultSearchPerPage
+	}
+	if q.Page <= 0 {
+		q.Page = 1
+	}
+
+	args := []interface{}{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	rank := "0"
+	conditions := []string{"approved_at IS NOT NULL", "expired IS NOT TRUE"}
+	if text := strings.TrimSpace(q.Text); text != "" {
+		tsquery := "plainto_tsquery('english', " + arg(text) + ")"
+		doc := `setweight(to_tsvector('english', job_title), 'A') || setweight(to_tsvector('english', company), 'B') || setweight(to_tsvector('english', description), 'C')`
+		conditions = append(conditions, "("+doc+") @@ "+tsquery)
+		rank = "ts_rank(" + doc + ", " + tsquery + ")"
+	}
+	if location := strings.TrimSpace(q.Location); location != "" {
+		conditions = append(conditions, "location ILIKE '%' || "+arg(location)+" || '%'")
+	}
+	if q.RemoteOnly {
+		conditions = append(conditions, "location ILIKE '%remote%'")
+	}
+	if q.SalaryMin > 0 {
+		conditions = append(conditions, "salary_max >= "+arg(q.SalaryMin))
+	}
+	if q.SalaryMax > 0 {
+		conditions = append(conditions, "salary_min <= "+arg(q.SalaryMax))
+	}
+	limit := arg(q.PerPage)
+	offset := arg((q.Page - 1) * q.PerPage)
+
+	rows, err := r.db.QueryContext(ctx, `
+	SELECT count(*) OVER() AS full_count, id, job_title, company, location, salary_range, salary_min, salary_max, salary_currency, salary_period, slug, external_id, company_icon_image_id, created_at, `+rank+` AS rank
+	FROM job
+	WHERE `+strings.Join(conditions, " AND ")+`
+	ORDER BY rank DESC, created_at DESC LIMIT `+limit+` OFFSET `+offset, args...)
+	if err != nil {
+		return results, 0, err
+	}
+	defer rows.Close()
+	var fullRowsCount int
+	for rows.Next() {
+		var res JobResult
+		var slug, companyIcon sql.NullString
+		err := rows.Scan(
+			&fullRowsCount,
+			&res.ID,
+			&res.JobTitle,
+			&res.Company,
+			&res.Location,
+			&res.SalaryRange,
+			&res.SalaryMin,
+			&res.SalaryMax,
+			&res.SalaryCurrency,
+			&res.SalaryPeriod,
+			&slug,
+			&res.ExternalID,
+			&companyIcon,
+			&res.CreatedAt,
+			&res.Rank,
+		)
+		if err != nil {
+			return results, fullRowsCount, err
+		}
+		res.Slug = slug.String
+		res.CompanyIconID = companyIcon.String
+		results = append(results, res)
+	}
+	if err := rows.Err(); err != nil {
+		return results, fullRowsCount, err
+	}
+	return results, fullRowsCount, nil
+}
@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"sync"
+	"time"
+)
+
+// ActiveUserWindow is how long a user counts as active after their last authenticated request
+var ActiveUserWindow = 5 * time.Minute
+
+var activeUsers = &activeUserTracker{seen: make(map[string]time.Time)}
+
+// activeUserTracker is an approximate, in-memory record of recently authenticated users
+type activeUserTracker struct {
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	lastPrune time.Time
+}
+
+func (t *activeUserTracker) touch(userID string) {
+	now := time.Now()
+	t.mu.Lock()
+	t.seen[userID] = now
+	if now.Sub(t.lastPrune) > ActiveUserWindow {
+		t.prune(now)
+	}
+	t.mu.Unlock()
+}
+
+func (t *activeUserTracker) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.prune(time.Now())
+	return len(t.seen)
+}
+
+// prune must be called with mu held
+func (t *activeUserTracker) prune(now time.Time) {
+	for id, lastSeen := range t.seen {
+		if now.Sub(lastSeen) > ActiveUserWindow {
+			delete(t.seen, id)
+		}
+	}
+	t.lastPrune = now
+}
+
+// ActiveUserCount returns the approximate number of users seen by the
+// authenticated middlewares within the last ActiveUserWindow
+func ActiveUserCount() int {
+	return activeUsers.count()
+}
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/rs/zerolog"
+)
+
+// Auth events are logged by the authenticated middlewares for every decision they make,
+// with the client ip and path, so repeated failures from one address can be alerted on
+const (
+	AuthEventSuccess                 = "auth.success"
+	AuthEventTokenExpired            = "auth.token_expired"
+	AuthEventNoSession               = "auth.no_session"
+	AuthEventTokenVerificationFailed = "auth.token_verification_failed"
+	AuthEventRoleDenied              = "auth.role_denied"
+	AuthEventReauthRequired          = "auth.reauth_required"
+)
+
+// authEvent starts the log event for an auth decision, failures are logged as warnings.
+// Callers add the fields they know about, such as user_id, and call Msg("auth")
+func authEvent(r *http.Request, event string) *zerolog.Event {
+	logger := LoggerFromContext(r.Context())
+	e := logger.Warn()
+	if event == AuthEventSuccess {
+		e = logger.Info()
+	}
+	return e.Str("event", event).Str("client_ip", clientIP(r)).Str("path", r.URL.Path)
+}
+
+// authFailureEvent maps the errors of authenticateFromCookie to their auth event
+func authFailureEvent(err error) string {
+	switch err {
+	case ErrNoAuthSession, ErrNoAuthCookie:
+		return AuthEventNoSession
+	case ErrTokenExpired:
+		return AuthEventTokenExpired
+	case ErrReauthRequired:
+		return AuthEventReauthRequired
+	case ErrImpersonating:
+		return AuthEventRoleDenied
+	}
+	return AuthEventTokenVerificationFailed
+}
@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+)
+
+// Ways BadBotMiddleware can deal with known bad bots
+const (
+	BadBotBlock  = "block"  // 418 right away
+	BadBotTarpit = "tarpit" // 418 after BadBotDelay, slowing scrapers down
+	BadBotDecoy  = "decoy"  // serve a minimal page that looks like success but holds no content
+)
+
+const badBotDecoyPage = "<!DOCTYPE html><html><head><title>Loading</title></head><body></body></html>"
+
+// DefaultBadBotMaxTarpits bounds the bad bot requests held at once when BadBotConfig doesn't
+const DefaultBadBotMaxTarpits = 100
+
+// BadBotConfig is how BadBotMiddleware deals with known bad bots
+type BadBotConfig struct {
+	// Mode is one of the BadBot constants, empty blocks them like BadBotBlock
+	Mode string
+	// Delay is how long BadBotTarpit holds a request before turning it down
+	Delay time.Duration
+	// MaxTarpits bounds the requests held at once, past it bad bots are blocked right away.
+	// Zero uses DefaultBadBotMaxTarpits
+	MaxTarpits int
+}
+
+// BadBotMiddleware turns down requests from the user agents ClassifyUserAgent reports as
+// bad bots, except in dev. It goes after the rate limiter, so a bot flooding the tarpit is
+// throttled before it holds any of the limited tarpit slots
+func BadBotMiddleware(next http.Handler, env string, cfg BadBotConfig) http.Handler {
+	if env == "dev" {
+		return next
+	}
+	maxTarpits := cfg.MaxTarpits
+	if maxTarpits <= 0 {
+		maxTarpits = DefaultBadBotMaxTarpits
+	}
+	tarpits := make(chan struct{}, maxTarpits)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ua := r.Header.Get("User-Agent")
+		if ClassifyUserAgent(ua) != UAClassBadBot {
+			next.ServeHTTP(w, r)
+			return
+		}
+		LoggerFromContext(r.Context()).Debug().
+			Str("user_agent", ua).
+			Str("ip", clientIP(r)).
+			Str("mode", cfg.Mode).
+			Stringer("url", r.URL).
+			Msg("bad bot")
+		switch cfg.Mode {
+		case BadBotTarpit:
+			select {
+			case tarpits <- struct{}{}:
+			default:
+				// every slot is taken, don't let bots pile up goroutines and connections
+				w.WriteHeader(http.StatusTeapot)
+				return
+			}
+			defer func() { <-tarpits }()
+			t := time.NewTimer(cfg.Delay)
+			defer t.Stop()
+			select {
+			case <-t.C:
+				w.WriteHeader(http.StatusTeapot)
+			case <-r.Context().Done():
+			}
+		case BadBotDecoy:
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(badBotDecoyPage))
+		default:
+			w.WriteHeader(http.StatusTeapot)
+		}
+	})
+}
@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"firebase.google.com/go/auth"
+	"github.com/gorilla/sessions"
+)
+
+// DefaultBetaGatePublicPaths stay reachable by everyone while the beta gate is on: the
+// pages explaining the site, the sign in flow, assets and the machine endpoints
+var DefaultBetaGatePublicPaths = []string{
+	"/about",
+	"/privacy-policy",
+	"/terms-of-service",
+	"/support",
+	"/auth",
+	"/autologin",
+	"/x/auth/",
+	"/x/signin",
+	"/x/email/",
+	"/x/beta/",
+	"/x/task/",
+	"/x/stripe/",
+	"/s/",
+	"/scripts/",
+	"/.well-known/",
+	"/robots.txt",
+	"/healthz",
+}
+
+// BetaGatePublicPaths replaces DefaultBetaGatePublicPaths when not nil. Set it at startup
+var BetaGatePublicPaths []string
+
+// BetaGateView is the coming soon page served by BetaGateMiddleware
+var BetaGateView = "coming-soon.html"
+
+// BetaAccessRequestedParam is set on the page the visitor is sent back to once their request
+// for access is saved, so the coming soon page can thank them
+const BetaAccessRequestedParam = "beta-access"
+
+// BetaGateMiddleware serves the coming soon page, which lets visitors request access, to
+// everyone but users signed in with an email allowedEmails accepts, with either a magic link
+// or a firebase session. The public paths, in the syntax of BlockPathsMiddleware, are served
+// to everyone. A nil allowedEmails disables the gate
+func BetaGateMiddleware(next http.Handler, allowedEmails func(string) bool, sessionStore sessions.Store, authClient *auth.Client, jwtKey []byte) http.Handler {
+	if allowedEmails == nil {
+		return next
+	}
+	publicPaths := BetaGatePublicPaths
+	if publicPaths == nil {
+		publicPaths = DefaultBetaGatePublicPaths
+	}
+	lowered := make([]string, len(publicPaths))
+	for i, p := range publicPaths {
+		lowered[i] = strings.ToLower(p)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if matchPathPattern(strings.ToLower(r.URL.Path), lowered) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		email := sessionEmail(r, sessionStore, authClient, jwtKey)
+		if email != "" && allowedEmails(email) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if wantsJSON(r) || errorResponder.tmpl == nil {
+			errorResponder.Respond(w, r, http.StatusForbidden, "the site is in private beta")
+			return
+		}
+		w.Header().Set("X-Robots-Tag", "noindex")
+		// a request access form that failed validation comes back here, see RequestBetaAccessHandler
+		form, _ := PopFormFlash(w, r, sessionStore)
+		if form.Values == nil {
+			form.Values = url.Values{"email": {email}}
+		}
+		errorResponder.tmpl.Render(w, http.StatusOK, BetaGateView, map[string]interface{}{
+			"Email":      email,
+			"SignedIn":   email != "",
+			"Next":       r.URL.Path,
+			"Requested":  r.URL.Query().Get(BetaAccessRequestedParam) != "",
+			"Form":       form.Values,
+			"FormErrors": form.Errors,
+			"CSPNonce":   CSPNonceFromContext(r.Context()),
+		})
+	})
+}
+
+// sessionEmail is the lower case email of the signed in user, read from the site JWT of magic
+// link sessions or else from the verified firebase ID token. Empty when signed out
+func sessionEmail(r *http.Request, sessionStore sessions.Store, authClient *auth.Client, jwtKey []byte) string {
+	if claims, err := GetUserFromJWT(r, sessionStore, jwtKey); err == nil {
+		return strings.ToLower(strings.TrimSpace(claims.Email))
+	}
+	if authClient == nil {
+		return ""
+	}
+	_, tk, err := authenticateFromCookie(sessionStore, authClient, r)
+	if err != nil {
+		return ""
+	}
+	email, _ := tk.Claims["email"].(string)
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// DefaultBlockedPaths are paths vulnerability scanners probe for that the site never serves
+var DefaultBlockedPaths = []string{
+	"/.env",
+	"/.git/",
+	"/.svn/",
+	"/.hg/",
+	"/.aws/",
+	"/.DS_Store",
+	"/wp-admin/",
+	"/wp-content/",
+	"/wp-includes/",
+	"/wp-login.php",
+	"/xmlrpc.php",
+	"/phpmyadmin/",
+	"/cgi-bin/",
+	"/vendor/phpunit/",
+	"/server-status",
+	"*.php",
+	"*.asp",
+	"*.aspx",
+	"*.jsp",
+	"*.cgi",
+}
+
+var blockedRequests uint64
+
+// BlockedRequests is the number of requests BlockPathsMiddleware turned away since startup
+func BlockedRequests() uint64 {
+	return atomic.LoadUint64(&blockedRequests)
+}
+
+// BlockPathsMiddleware answers requests for paths matching patterns with an empty 404 and
+// closes the connection, before they reach logging, tracing or the router. Patterns are
+// matched case insensitively: "/dir/" matches the directory and everything below it,
+// "*.ext" matches any path with that extension and anything else only matches exactly
+func BlockPathsMiddleware(next http.Handler, patterns []string) http.Handler {
+	if len(patterns) == 0 {
+		return next
+	}
+	lowered := make([]string, len(patterns))
+	for i, p := range patterns {
+		lowered[i] = strings.ToLower(p)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if matchPathPattern(strings.ToLower(r.URL.Path), lowered) {
+			atomic.AddUint64(&blockedRequests, 1)
+			w.Header().Set("Connection", "close")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// matchPathPattern reports whether path matches one of patterns, see BlockPathsMiddleware
+// for the pattern syntax. Both are expected to be lower case
+func matchPathPattern(path string, patterns []string) bool {
+	for _, p := range patterns {
+		switch {
+		case strings.HasPrefix(p, "*."):
+			if strings.HasSuffix(path, p[1:]) {
+				return true
+			}
+		case strings.HasSuffix(p, "/"):
+			if strings.HasPrefix(path, p) || path == strings.TrimSuffix(p, "/") {
+				return true
+			}
+		case path == p:
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CircuitBreaker stops calls to a flaky dependency once it fails threshold times in a row
+// within window. While open every call fails fast, after cooldown a single probe call is let
+// through (half open) and its outcome closes or re-opens the circuit. A nil *CircuitBreaker
+// always allows calls
+type CircuitBreaker struct {
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+
+	mu           sync.Mutex
+	failures     int
+	firstFailure time.Time
+	openedAt     time.Time
+	open         bool
+	probing      bool
+}
+
+// NewCircuitBreaker returns nil, a breaker that never opens, when threshold is 0 or less
+func NewCircuitBreaker(threshold int, window, cooldown time.Duration) *CircuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &CircuitBreaker{threshold: threshold, window: window, cooldown: cooldown}
+}
+
+// Allow reports whether a call may go ahead. Callers that get true must report the outcome
+// with Success or Failure
+func (b *CircuitBreaker) Allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.open {
+		return true
+	}
+	if b.probing || time.Since(b.openedAt) < b.cooldown {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+func (b *CircuitBreaker) Success() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.open = false
+	b.probing = false
+}
+
+func (b *CircuitBreaker) Failure() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if b.probing {
+		b.probing = false
+		b.openedAt = now
+		log.Printf("circuit breaker probe failed, retrying in %s", b.cooldown)
+		return
+	}
+	if b.failures == 0 || now.Sub(b.firstFailure) > b.window {
+		b.failures = 0
+		b.firstFailure = now
+	}
+	b.failures++
+	if b.failures >= b.threshold && !b.open {
+		b.open = true
+		b.openedAt = now
+		log.Printf("circuit breaker opened after %d failures, retrying in %s", b.failures, b.cooldown)
+	}
+}
+
+// AuthBreaker guards the firebase id token verification in authenticateFromCookie
+var AuthBreaker = NewCircuitBreaker(5, time.Minute, 30*time.Second)
+
+// AuthVerifyTimeout bounds a single firebase id token verification
+var AuthVerifyTimeout = 5 * time.Second
+
+// isUpstreamFailure tells errors reaching firebase, which count towards AuthBreaker, apart
+// from tokens that are simply invalid or expired
+func isUpstreamFailure(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) ||
+		errors.As(err, &netErr) ||
+		strings.Contains(err.Error(), "while retrieving public keys")
+}
@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// RequireContentType responds 415 to requests with a body whose Content-Type, ignoring
+// parameters like charset, isn't one of allowed, e.g. "application/json". GET, HEAD and
+// DELETE requests without a body are let through
+func RequireContentType(next http.HandlerFunc, allowed ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !hasBody(r) {
+			next(w, r)
+			return
+		}
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err == nil {
+			for _, a := range allowed {
+				if strings.EqualFold(mediaType, a) {
+					next(w, r)
+					return
+				}
+			}
+		}
+		errorResponder.Respond(w, r, http.StatusUnsupportedMediaType, "Content-Type must be one of "+strings.Join(allowed, ", "))
+	}
+}
+
+func hasBody(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodDelete:
+		return r.ContentLength > 0 || len(r.TransferEncoding) > 0
+	}
+	return true
+}
@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DatabaseRetryAfter is the Retry-After sent while the database is unavailable
+var DatabaseRetryAfter = 5 * time.Second
+
+// RequireHealthyDatabase answers 503 while healthy reports the database as down, rather than
+// letting handlers fail on their first query. Health check paths are let through
+func RequireHealthyDatabase(healthy func() bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if healthy() || hasPathPrefix(r.URL.Path, HostAllowlistExemptPaths) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(int(DatabaseRetryAfter.Seconds())))
+		errorResponder.Respond(w, r, http.StatusServiceUnavailable, "we are having a temporary problem, please try again in a few seconds")
+	})
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"firebase.google.com/go/auth"
+)
+
+// EmailVerifiedSyncer stores the email_verified flag the auth provider reports for a user,
+// e.g. user.Repository. Set it at startup, nil disables the sync
+var EmailVerifiedSyncer interface {
+	SyncEmailVerifiedFromProvider(ctx context.Context, userID string, verified bool) error
+}
+
+// EmailVerifiedSyncInterval is how often the same flag is pushed again for a user. A flag
+// different from the last one pushed is synced straight away
+var EmailVerifiedSyncInterval = 15 * time.Minute
+
+var emailVerifiedSyncs = &emailVerifiedThrottle{synced: make(map[string]emailVerifiedSync)}
+
+type emailVerifiedSync struct {
+	verified bool
+	at       time.Time
+}
+
+// emailVerifiedThrottle remembers the flag last synced for each user so that authenticated
+// requests don't each write to the users table
+type emailVerifiedThrottle struct {
+	mu        sync.Mutex
+	synced    map[string]emailVerifiedSync
+	lastPrune time.Time
+}
+
+// due reports whether verified should be synced for userID, and if so records it as synced
+func (t *emailVerifiedThrottle) due(userID string, verified bool) bool {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if now.Sub(t.lastPrune) > EmailVerifiedSyncInterval {
+		for id, s := range t.synced {
+			if now.Sub(s.at) > EmailVerifiedSyncInterval {
+				delete(t.synced, id)
+			}
+		}
+		t.lastPrune = now
+	}
+	if last, ok := t.synced[userID]; ok && last.verified == verified && now.Sub(last.at) <= EmailVerifiedSyncInterval {
+		return false
+	}
+	t.synced[userID] = emailVerifiedSync{verified: verified, at: now}
+	return true
+}
+
+// forget drops the record for userID so the next request retries the sync
+func (t *emailVerifiedThrottle) forget(userID string) {
+	t.mu.Lock()
+	delete(t.synced, userID)
+	t.mu.Unlock()
+}
+
+// syncEmailVerified pushes the email_verified claim of a freshly verified firebase token to
+// EmailVerifiedSyncer, throttled per user. Failures are logged, they never fail the request
+func syncEmailVerified(ctx context.Context, tk *auth.Token) {
+	if EmailVerifiedSyncer == nil || tk == nil {
+		return
+	}
+	verified, ok := tk.Claims["email_verified"].(bool)
+	if !ok || !emailVerifiedSyncs.due(tk.UID, verified) {
+		return
+	}
+	if err := EmailVerifiedSyncer.SyncEmailVerifiedFromProvider(ctx, tk.UID, verified); err != nil {
+		emailVerifiedSyncs.forget(tk.UID)
+		LoggerFromContext(ctx).Error().Err(err).Str("user_id", tk.UID).Msg("unable to sync email_verified from auth provider")
+	}
+}
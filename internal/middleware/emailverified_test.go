@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"firebase.google.com/go/auth"
+)
+
+type recordingSyncer struct {
+	calls int
+	err   error
+}
+
+func (s *recordingSyncer) SyncEmailVerifiedFromProvider(ctx context.Context, userID string, verified bool) error {
+	s.calls++
+	return s.err
+}
+
+func TestSyncEmailVerified(t *testing.T) {
+	type request struct {
+		uid       string
+		verified  interface{}
+		failWrite bool
+		wantWrite bool
+	}
+	tests := []struct {
+		name     string
+		requests []request
+	}{
+		{"first sight of a user is written", []request{
+			{uid: "a", verified: true, wantWrite: true},
+		}},
+		{"unchanged flag is not written again", []request{
+			{uid: "a", verified: true, wantWrite: true},
+			{uid: "a", verified: true},
+			{uid: "a", verified: true},
+		}},
+		{"changed flag is written straight away", []request{
+			{uid: "a", verified: false, wantWrite: true},
+			{uid: "a", verified: true, wantWrite: true},
+			{uid: "a", verified: true},
+		}},
+		{"users are throttled separately", []request{
+			{uid: "a", verified: true, wantWrite: true},
+			{uid: "b", verified: true, wantWrite: true},
+			{uid: "a", verified: true},
+		}},
+		{"failed write is retried", []request{
+			{uid: "a", verified: true, failWrite: true, wantWrite: true},
+			{uid: "a", verified: true, wantWrite: true},
+			{uid: "a", verified: true},
+		}},
+		{"token without the claim is skipped", []request{
+			{uid: "a", verified: nil},
+			{uid: "a", verified: "true"},
+		}},
+	}
+	prevSyncer, prevSyncs := EmailVerifiedSyncer, emailVerifiedSyncs
+	defer func() {
+		EmailVerifiedSyncer, emailVerifiedSyncs = prevSyncer, prevSyncs
+	}()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			syncer := &recordingSyncer{}
+			EmailVerifiedSyncer = syncer
+			emailVerifiedSyncs = &emailVerifiedThrottle{synced: make(map[string]emailVerifiedSync)}
+			for i, req := range tt.requests {
+				syncer.err = nil
+				if req.failWrite {
+					syncer.err = errors.New("connection refused")
+				}
+				claims := map[string]interface{}{}
+				if req.verified != nil {
+					claims["email_verified"] = req.verified
+				}
+				before := syncer.calls
+				syncEmailVerified(context.Background(), &auth.Token{UID: req.uid, Claims: claims})
+				if wrote := syncer.calls > before; wrote != req.wantWrite {
+					t.Errorf("request %d: wrote = %v, want %v", i, wrote, req.wantWrite)
+				}
+			}
+		})
+	}
+}
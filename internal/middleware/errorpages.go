@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/golang-cafe/job-board/internal/template"
+
+	stdtemplate "html/template"
+)
+
+// Views rendered by NotFoundHandler and InternalErrorHandler. They get the same data as the
+// error responder view, Status, StatusText and Message
+const (
+	NotFoundView      = "404.html"
+	InternalErrorView = "500.html"
+)
+
+// fallbackErrorPage is served when the error view is missing or fails to render
+const fallbackErrorPage = `<!DOCTYPE html><html lang="en"><head><meta charset="utf-8"><meta name="robots" content="noindex"><title>%[1]d %[2]s</title></head><body><h1>%[1]d %[2]s</h1><p><a href="/">Back to the home page</a></p></body></html>`
+
+// NotFoundHandler renders NotFoundView with a 404, for the router's NotFoundHandler
+func NotFoundHandler(t *template.Template) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		renderErrorPage(t, w, r, http.StatusNotFound, NotFoundView)
+	}
+}
+
+// InternalErrorHandler renders InternalErrorView with a 500, e.g. for RecoverMiddleware
+func InternalErrorHandler(t *template.Template) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		renderErrorPage(t, w, r, http.StatusInternalServerError, InternalErrorView)
+	}
+}
+
+// renderErrorPage answers JSON clients with the error envelope and everyone else with view,
+// or the built in page when t doesn't have it
+func renderErrorPage(t *template.Template, w http.ResponseWriter, r *http.Request, status int, view string) {
+	if wantsJSON(r) {
+		WriteJSONError(w, status, "", "", nil)
+		return
+	}
+	if t != nil && t.Verify(view) == nil {
+		// rendered into a buffer first, so a broken view still leaves room for the built in page
+		err := t.RenderContext(r.Context(), w, status, view, map[string]interface{}{
+			"Status":     status,
+			"StatusText": http.StatusText(status),
+			"Message":    http.StatusText(status),
+			"CSPNonce":   CSPNonceFromContext(r.Context()),
+		})
+		if err == nil || r.Context().Err() != nil {
+			return
+		}
+		LoggerFromContext(r.Context()).Error().Err(err).Str("view", view).Msg("unable to render error page")
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, fallbackErrorPage, status, stdtemplate.HTMLEscapeString(http.StatusText(status)))
+}
+
+// RecoverMiddleware turns a panic in next into a logged error and a response from onPanic,
+// typically InternalErrorHandler, instead of a dropped connection. Panics with
+// http.ErrAbortHandler are left to net/http, they are how handlers abort on purpose
+func RecoverMiddleware(next http.Handler, onPanic http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+			LoggerFromContext(r.Context()).Error().
+				Str("panic", fmt.Sprint(rec)).
+				Bytes("stack", debug.Stack()).
+				Stringer("url", r.URL).
+				Msg("recovered from panic")
+			onPanic(w, r)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
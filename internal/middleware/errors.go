@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/golang-cafe/job-board/internal/template"
+)
+
+// ErrorResponder writes error responses either as a rendered HTML page or as a JSON
+// body depending on what the client asked for
+type ErrorResponder struct {
+	tmpl *template.Template
+	view string
+}
+
+var errorResponder = &ErrorResponder{}
+
+func NewErrorResponder(tmpl *template.Template, view string) *ErrorResponder {
+	return &ErrorResponder{tmpl: tmpl, view: view}
+}
+
+// SetErrorResponder sets the responder used by the middlewares in this package
+func SetErrorResponder(e *ErrorResponder) {
+	errorResponder = e
+}
+
+func (e *ErrorResponder) Respond(w http.ResponseWriter, r *http.Request, status int, message string) {
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	if wantsJSON(r) {
+		WriteJSONError(w, status, "", message, nil)
+		return
+	}
+	if e.tmpl == nil {
+		http.Error(w, message, status)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	e.tmpl.Render(w, status, e.view, map[string]interface{}{
+		"Status":     status,
+		"StatusText": http.StatusText(status),
+		"Message":    message,
+		"CSPNonce":   CSPNonceFromContext(r.Context()),
+	})
+}
+
+func wantsJSON(r *http.Request) bool {
+	if r.Header.Get("X-Requested-With") == "XMLHttpRequest" {
+		return true
+	}
+	accept := r.Header.Get("Accept")
+	if strings.Contains(accept, "text/html") {
+		return false
+	}
+	return strings.Contains(accept, "application/json") || strings.HasPrefix(r.URL.Path, "/x/")
+}
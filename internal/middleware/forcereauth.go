@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ForceReauthAt looks up when a user was last forced to sign in again, e.g. after their
+// credentials leaked. When set, sessions issued before then are rejected and the user is
+// sent back to the sign in page
+var ForceReauthAt func(ctx context.Context, userID string) (time.Time, error)
+
+// reauthRequired reports whether a session of userID signed in at authTime predates their forced
+// re-authentication. Like tokenRevoked, lookup failures don't sign anyone out
+func reauthRequired(ctx context.Context, userID string, authTime time.Time) bool {
+	if ForceReauthAt == nil || userID == "" {
+		return false
+	}
+	at, err := ForceReauthAt(ctx, userID)
+	if err != nil || at.IsZero() {
+		return false
+	}
+	// auth times only have second precision
+	return authTime.Unix() < at.Unix()
+}
+
+// redirectToReauth sends page requests to the sign in page, asking for credentials even
+// though a session exists, other requests get a 401
+func redirectToReauth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet || wantsJSON(r) {
+		errorResponder.Respond(w, r, http.StatusUnauthorized, "please sign in again to continue")
+		return
+	}
+	http.Redirect(w, r, AuthRedirectURL(r, url.Values{"reauth": {"1"}}), http.StatusSeeOther)
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/sessions"
+)
+
+// FormFlash is a submitted form kept in the session across the redirect that follows a
+// failed validation, so the form can be shown again with the input and the errors
+type FormFlash struct {
+	Values url.Values
+	Errors map[string]string // keyed by field name
+}
+
+// formFlashKey is the flash key of the form. It is stored as JSON text, which survives the
+// gob encoding of the cookie store and the JSON encoding of PostgresSessionStore alike
+const formFlashKey = "form"
+
+// SetFormFlash stores form and errs for the next page render, see PopFormFlash. Fields whose
+// name mentions a password or token are left out, they must never be echoed back
+func SetFormFlash(w http.ResponseWriter, r *http.Request, sessionStore sessions.Store, form url.Values, errs map[string]string) error {
+	sess, err := GetSession(r, sessionStore)
+	if err != nil {
+		return err
+	}
+	values := url.Values{}
+	for name, v := range form {
+		lower := strings.ToLower(name)
+		if strings.Contains(lower, "password") || strings.Contains(lower, "token") {
+			continue
+		}
+		values[name] = v
+	}
+	flash, err := json.Marshal(FormFlash{Values: values, Errors: errs})
+	if err != nil {
+		return err
+	}
+	sess.AddFlash(string(flash), formFlashKey)
+	return SaveSession(r, w, sess)
+}
+
+// PopFormFlash returns the form stored by SetFormFlash, if any, and removes it from the session
+func PopFormFlash(w http.ResponseWriter, r *http.Request, sessionStore sessions.Store) (FormFlash, bool) {
+	sess, err := GetSession(r, sessionStore)
+	if err != nil {
+		return FormFlash{}, false
+	}
+	flashes := sess.Flashes(formFlashKey)
+	if len(flashes) == 0 {
+		return FormFlash{}, false
+	}
+	if err := SaveSession(r, w, sess); err != nil {
+		LoggerFromContext(r.Context()).Error().Err(err).Msg("unable to clear form flash")
+	}
+	flash, ok := flashes[len(flashes)-1].(string)
+	if !ok {
+		return FormFlash{}, false
+	}
+	var form FormFlash
+	if err := json.Unmarshal([]byte(flash), &form); err != nil {
+		LoggerFromContext(r.Context()).Error().Err(err).Msg("unable to decode form flash")
+		return FormFlash{}, false
+	}
+	return form, true
+}
@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/sessions"
+)
+
+// jsonSessionStore keeps a single session in memory, round tripping its values through
+// encoding/json on every save and load the way PostgresSessionStore does
+type jsonSessionStore struct {
+	data []byte
+}
+
+func (s *jsonSessionStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+func (s *jsonSessionStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	session.Options = &sessions.Options{Path: "/"}
+	session.IsNew = s.data == nil
+	if s.data == nil {
+		return session, nil
+	}
+	values := make(map[string]interface{})
+	if err := json.Unmarshal(s.data, &values); err != nil {
+		return session, err
+	}
+	for k, v := range values {
+		session.Values[k] = v
+	}
+	return session, nil
+}
+
+func (s *jsonSessionStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	values := make(map[string]interface{}, len(session.Values))
+	for k, v := range session.Values {
+		values[k.(string)] = v
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	s.data = data
+	return nil
+}
+
+func TestFormFlash(t *testing.T) {
+	stores := []struct {
+		name  string
+		store sessions.Store
+	}{
+		{"cookie store", NewSessionStore([]byte("0123456789abcdef0123456789abcdef"), SessionConfig{})},
+		{"json encoded store", &jsonSessionStore{}},
+	}
+	form := url.Values{"email": {"jane@"}, "password": {"hunter2"}, "next": {"/jobs"}}
+	errs := map[string]string{"email": "enter a valid email"}
+	want := FormFlash{Values: url.Values{"email": {"jane@"}, "next": {"/jobs"}}, Errors: errs}
+	for _, tt := range stores {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			if err := SetFormFlash(w, httptest.NewRequest("POST", "/x/beta", nil), tt.store, form, errs); err != nil {
+				t.Fatal(err)
+			}
+			next := func() *http.Request {
+				r := httptest.NewRequest("GET", "/", nil)
+				for _, c := range w.Result().Cookies() {
+					r.AddCookie(c)
+				}
+				w = httptest.NewRecorder()
+				return r
+			}
+			got, ok := PopFormFlash(w, next(), tt.store)
+			if !ok {
+				t.Fatal("no form flash after the redirect")
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("PopFormFlash() = %+v, want %+v", got, want)
+			}
+			if _, ok := PopFormFlash(w, next(), tt.store); ok {
+				t.Error("form flash was shown twice")
+			}
+		})
+	}
+}
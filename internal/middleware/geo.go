@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// GeoIPResolver maps an IP address to an ISO 3166-1 alpha-2 country code, e.g. a wrapper
+// around a MaxMind GeoLite2 database
+type GeoIPResolver interface {
+	Country(ip net.IP) (string, error)
+}
+
+type countryContextKey struct{}
+
+// CountryFromContext returns the upper case country code GeoMiddleware resolved for the
+// visitor, or "" when it couldn't be resolved or the request didn't go through it
+func CountryFromContext(ctx context.Context) string {
+	country, _ := ctx.Value(countryContextKey{}).(string)
+	return country
+}
+
+// GeoMiddleware stores the country of the client IP in the request context, see
+// CountryFromContext. Resolution failures leave the country empty, they never fail the request
+func GeoMiddleware(next http.Handler, db GeoIPResolver) http.Handler {
+	if db == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var country string
+		if ip := net.ParseIP(clientIP(r)); ip != nil {
+			if c, err := db.Country(ip); err == nil {
+				country = strings.ToUpper(strings.TrimSpace(c))
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), countryContextKey{}, country)))
+	})
+}
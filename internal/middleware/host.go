@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// HostAllowlistExemptPaths are served whatever the Host header, for load balancer health
+// checks that address the instance by IP
+var HostAllowlistExemptPaths = []string{"/health", "/healthz"}
+
+// HostAllowlistMiddleware responds 400 to requests whose Host isn't one of allowed, so
+// spoofed hosts never reach redirects or absolute urls built from the request. Entries
+// like "*.example.com" match any subdomain, but not example.com itself. Ports are ignored
+func HostAllowlistMiddleware(next http.Handler, allowed []string) http.Handler {
+	exact := make(map[string]bool, len(allowed))
+	var suffixes []string
+	for _, host := range allowed {
+		host = strings.ToLower(strings.TrimSpace(host))
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if strings.HasPrefix(host, "*.") {
+			suffixes = append(suffixes, host[1:])
+			continue
+		}
+		exact[host] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if containsString(HostAllowlistExemptPaths, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		host := strings.ToLower(r.Host)
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.TrimSuffix(host, ".")
+		if host != "" && (exact[host] || hasHostSuffix(host, suffixes)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+	})
+}
+
+func hasHostSuffix(host string, suffixes []string) bool {
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(host, suffix) && len(host) > len(suffix) {
+			return true
+		}
+	}
+	return false
+}
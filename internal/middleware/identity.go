@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/golang-cafe/job-board/internal/user"
+	"github.com/gorilla/sessions"
+)
+
+// ErrNoIdentity is reported by handlers that need IdentityFromContext when the request has
+// no verified session
+var ErrNoIdentity = errors.New("no verified session in the request")
+
+// identityContextKey is unexported so only this package can store an identity, and it only
+// does so after verifying the session jwt signature. Nothing a client sends ends up under it
+type identityContextKey struct{}
+
+// IdentityFromContext returns the session claims verified earlier in the request by
+// AdminAuthenticatedMiddleware or IdentityMiddleware, sparing handlers from parsing the jwt again
+func IdentityFromContext(ctx context.Context) (*UserJWT, bool) {
+	claims, ok := ctx.Value(identityContextKey{}).(*UserJWT)
+	return claims, ok && claims != nil
+}
+
+// withIdentity stores claims for IdentityFromContext, and makes the signed in user, or the
+// admin impersonating them, the actor of the user audit log rows the request adds
+func withIdentity(r *http.Request, claims *UserJWT) *http.Request {
+	actorID := claims.UserID
+	if claims.ImpersonatorID != "" {
+		actorID = claims.ImpersonatorID
+	}
+	ctx := user.WithActor(r.Context(), actorID)
+	return r.WithContext(context.WithValue(ctx, identityContextKey{}, claims))
+}
+
+// IdentityMiddleware verifies the session jwt, if there is one, and stores its claims for
+// IdentityFromContext and GetUserFromJWT. Requests without a valid session go through
+// unchanged, pair it with an authenticated middleware to require one. Server wraps every
+// route in it, so handlers read the signed in user with IdentityFromContext
+func IdentityMiddleware(sessionStore sessions.Store, jwtKey []byte, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := IdentityFromContext(r.Context()); !ok {
+			if claims, err := GetUserFromJWT(r, sessionStore, jwtKey); err == nil {
+				r = withIdentity(r, claims)
+				if claims.ImpersonatorID != "" {
+					logger := LoggerFromContext(r.Context()).With().Str("impersonator_id", claims.ImpersonatorID).Logger()
+					r = r.WithContext(WithLogger(r.Context(), logger))
+				}
+			}
+		}
+		next(w, r)
+	}
+}
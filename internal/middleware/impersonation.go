@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/golang-cafe/job-board/internal/user"
+	"github.com/gorilla/sessions"
+)
+
+const (
+	sessionKeyImpersonatorID        = "impersonator_id"
+	sessionKeyImpersonatedUserID    = "impersonated_user_id"
+	sessionKeyImpersonatedUserEmail = "impersonated_user_email"
+	sessionKeyImpersonatedUserType  = "impersonated_user_type"
+)
+
+var (
+	ErrNotAdmin                = errors.New("only admins can impersonate users")
+	ErrCannotImpersonateAdmin  = errors.New("admins can't be impersonated")
+	ErrImpersonatedUserMissing = errors.New("impersonated user not found")
+	// ErrImpersonating is returned for firebase token sessions while impersonating, as only
+	// the site jwt claims can be resolved to the impersonated user
+	ErrImpersonating = errors.New("this page isn't available while impersonating a user")
+)
+
+// ImpersonatorFromContext returns the id of the admin acting on behalf of the signed in user,
+// if IdentityMiddleware resolved the session to an impersonated user
+func ImpersonatorFromContext(ctx context.Context) (string, bool) {
+	claims, ok := IdentityFromContext(ctx)
+	if !ok || claims.ImpersonatorID == "" {
+		return "", false
+	}
+	return claims.ImpersonatorID, true
+}
+
+// Impersonate records in the session that the signed in admin is acting as targetUserID,
+// and adds an impersonate_start row to the user audit log. From the next request on
+// GetUserFromJWT resolves the session to the target user, while requests going through
+// IdentityMiddleware are logged, and their writes audited, under the real admin id. Admin
+// only routes keep seeing the admin, so they can stop the impersonation. Routes verifying
+// a firebase token reject the session with ErrImpersonating rather than act as the admin
+func Impersonate(w http.ResponseWriter, r *http.Request, sessionStore sessions.Store, userRepo user.UserStore, admin *UserJWT, targetUserID string) error {
+	if admin == nil || !admin.IsAdmin {
+		return ErrNotAdmin
+	}
+	target, err := userRepo.GetUser(r.Context(), targetUserID)
+	if err != nil {
+		return err
+	}
+	if target == nil {
+		return ErrImpersonatedUserMissing
+	}
+	// the IsAdmin claim is granted from the admin user type at sign in
+	if target.Type == user.UserTypeAdmin {
+		return ErrCannotImpersonateAdmin
+	}
+	sess, err := GetSession(r, sessionStore)
+	if err != nil {
+		return err
+	}
+	sess.Values[sessionKeyImpersonatorID] = admin.UserID
+	sess.Values[sessionKeyImpersonatedUserID] = target.ID
+	sess.Values[sessionKeyImpersonatedUserEmail] = target.Email
+	sess.Values[sessionKeyImpersonatedUserType] = target.Type
+	if err := userRepo.RecordAudit(user.WithActor(r.Context(), admin.UserID), admin.UserID, "impersonate_start", "", target.ID); err != nil {
+		return err
+	}
+	LoggerFromContext(r.Context()).Info().
+		Str("impersonator_id", admin.UserID).
+		Str("impersonated_user_id", target.ID).
+		Msg("impersonation started")
+	return SaveSession(r, w, sess)
+}
+
+// StopImpersonation drops the impersonated identity, returning the session to the admin, and
+// adds an impersonate_stop row to the user audit log
+func StopImpersonation(w http.ResponseWriter, r *http.Request, sessionStore sessions.Store, userRepo user.UserStore) error {
+	sess, err := GetSession(r, sessionStore)
+	if err != nil {
+		return err
+	}
+	targetID, ok := sess.Values[sessionKeyImpersonatedUserID].(string)
+	if !ok {
+		return nil
+	}
+	impersonatorID, _ := sess.Values[sessionKeyImpersonatorID].(string)
+	if err := userRepo.RecordAudit(user.WithActor(r.Context(), impersonatorID), impersonatorID, "impersonate_stop", "", targetID); err != nil {
+		return err
+	}
+	LoggerFromContext(r.Context()).Info().
+		Str("impersonator_id", impersonatorID).
+		Str("impersonated_user_id", targetID).
+		Msg("impersonation stopped")
+	delete(sess.Values, sessionKeyImpersonatorID)
+	delete(sess.Values, sessionKeyImpersonatedUserID)
+	delete(sess.Values, sessionKeyImpersonatedUserEmail)
+	delete(sess.Values, sessionKeyImpersonatedUserType)
+	return SaveSession(r, w, sess)
+}
+
+// IsImpersonating reports whether the signed in session currently resolves to an
+// impersonated user, for the banner offering to stop
+func IsImpersonating(r *http.Request, sessionStore sessions.Store, jwtKey []byte) bool {
+	claims, err := GetUserFromJWT(r, sessionStore, jwtKey)
+	return err == nil && claims.ImpersonatorID != ""
+}
+
+// applyImpersonation returns the claims of the impersonated user when the session holds an
+// impersonation started by the admin claims belong to, and claims unchanged otherwise
+func applyImpersonation(sess *sessions.Session, claims *UserJWT) *UserJWT {
+	impersonatorID, _ := sess.Values[sessionKeyImpersonatorID].(string)
+	targetID, _ := sess.Values[sessionKeyImpersonatedUserID].(string)
+	if impersonatorID == "" || targetID == "" || !claims.IsAdmin || impersonatorID != claims.UserID {
+		return claims
+	}
+	targetType, _ := sess.Values[sessionKeyImpersonatedUserType].(string)
+	impersonated := *claims
+	impersonated.UserID = targetID
+	impersonated.Email, _ = sess.Values[sessionKeyImpersonatedUserEmail].(string)
+	impersonated.Type = targetType
+	impersonated.IsAdmin = false
+	impersonated.IsDeveloper = targetType == user.UserTypeDeveloper
+	impersonated.IsRecruiter = targetType == user.UserTypeRecruiter
+	impersonated.ImpersonatorID = impersonatorID
+	return &impersonated
+}
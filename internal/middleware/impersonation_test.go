@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/golang-cafe/job-board/internal/user"
+)
+
+// TestImpersonationAudit impersonates a user, makes a write as them and stops. Every audit
+// row must name the admin as the actor
+func TestImpersonationAudit(t *testing.T) {
+	store := NewSessionStore([]byte("0123456789abcdef0123456789abcdef"), SessionConfig{})
+	users := user.NewMemStore()
+	target, err := users.CreateUserReturning(context.Background(), user.User{Email: "jane@example.com", Type: user.UserTypeDeveloper})
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin := &UserJWT{UserID: "admin-1", Email: "admin@example.com", Type: user.UserTypeAdmin, IsAdmin: true}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	sess, err := GetSession(r, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess.Values["jwt"] = signedSessionJWT(t, *admin)
+	w := httptest.NewRecorder()
+	if err := SaveSession(r, w, sess); err != nil {
+		t.Fatal(err)
+	}
+	// next sends the cookies w set on a new request to path and returns its recorder
+	next := func(w *httptest.ResponseRecorder, path string, h http.HandlerFunc) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", path, nil)
+		for _, c := range w.Result().Cookies() {
+			r.AddCookie(c)
+		}
+		rw := httptest.NewRecorder()
+		h(rw, r)
+		return rw
+	}
+
+	w = next(w, "/x/impersonate/"+target.ID, func(w http.ResponseWriter, r *http.Request) {
+		if err := Impersonate(w, r, store, users, admin, target.ID); err != nil {
+			t.Fatalf("Impersonate() = %v", err)
+		}
+	})
+	next(w, "/profile/home", IdentityMiddleware(store, testJWTKey, func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := IdentityFromContext(r.Context())
+		if !ok || claims.UserID != target.ID {
+			t.Fatalf("identity = %+v, want the impersonated user %s", claims, target.ID)
+		}
+		if err := users.ForceReauth(r.Context(), target.ID); err != nil {
+			t.Fatal(err)
+		}
+	}))
+	next(w, "/x/impersonate/stop", func(w http.ResponseWriter, r *http.Request) {
+		if err := StopImpersonation(w, r, store, users); err != nil {
+			t.Fatalf("StopImpersonation() = %v", err)
+		}
+	})
+
+	want := []user.AuditEntry{
+		{UserID: admin.UserID, Action: "impersonate_start", NewValue: target.ID, ActorID: admin.UserID},
+		{UserID: target.ID, Action: "force_reauth", ActorID: admin.UserID},
+		{UserID: admin.UserID, Action: "impersonate_stop", NewValue: target.ID, ActorID: admin.UserID},
+	}
+	if got := users.AuditLog(); !reflect.DeepEqual(got, want) {
+		t.Errorf("audit log = %+v, want %+v", got, want)
+	}
+}
+
+func TestAuthenticateFromCookieRejectsImpersonation(t *testing.T) {
+	store := NewSessionStore([]byte("0123456789abcdef0123456789abcdef"), SessionConfig{})
+	r := httptest.NewRequest("GET", "/", nil)
+	sess, err := GetSession(r, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess.Values["jwt"] = "firebase-id-token"
+	sess.Values[sessionKeyImpersonatorID] = "admin-1"
+	sess.Values[sessionKeyImpersonatedUserID] = "user-1"
+	w := httptest.NewRecorder()
+	if err := SaveSession(r, w, sess); err != nil {
+		t.Fatal(err)
+	}
+	r = httptest.NewRequest("GET", "/profile/home", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	// the session is rejected before the token reaches firebase, so no client is needed
+	if _, _, err := authenticateFromCookie(store, nil, r); err != ErrImpersonating {
+		t.Errorf("authenticateFromCookie() = %v, want %v", err, ErrImpersonating)
+	}
+}
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// ErrorBody is the envelope of every JSON error response, e.g.
+// {"error": {"code": "validation_failed", "message": "...", "fields": {"email": "..."}}}
+type ErrorBody struct {
+	Error ErrorDetail `json:"error"`
+}
+
+type ErrorDetail struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// WriteJSON writes v as the JSON body of a response with the given status
+func WriteJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// WriteJSONError writes the error envelope. code is a stable machine readable identifier,
+// defaulting to one derived from status, and fields maps invalid input fields to their problem
+func WriteJSONError(w http.ResponseWriter, status int, code, message string, fields map[string]string) {
+	if code == "" {
+		code = StatusCode(status)
+	}
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	WriteJSON(w, status, ErrorBody{Error: ErrorDetail{Code: code, Message: message, Fields: fields}})
+}
+
+// StatusCode returns the error code used for status when none is given, e.g. "payload_too_large" for 413
+func StatusCode(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return "error"
+	}
+	return strings.ToLower(strings.NewReplacer(" ", "_", "-", "_", "'", "").Replace(text))
+}
@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// LastSearchCookieName is the cookie SetLastSearch stores the visitor's last job search in
+	LastSearchCookieName = "last_search"
+	// lastSearchVersion is bumped whenever LastSearch changes shape, older cookies are then ignored
+	lastSearchVersion = 1
+	// maxLastSearchCookieLength keeps the cookie, sent with every request, small
+	maxLastSearchCookieLength = 512
+)
+
+// LastSearchTTL is how long the last search is remembered for
+var LastSearchTTL = 90 * 24 * time.Hour
+
+// LastSearch is the job search a visitor ran last. It is kept in a signed cookie rather than
+// in the session so it works for visitors who aren't signed in too
+type LastSearch struct {
+	Version  int    `json:"v"`
+	Query    string `json:"q,omitempty"`
+	Location string `json:"l,omitempty"`
+	Salary   string `json:"s,omitempty"`
+	Currency string `json:"c,omitempty"`
+}
+
+// IsEmpty reports whether s has no query or filter set
+func (s LastSearch) IsEmpty() bool {
+	return s.Query == "" && s.Location == "" && s.Salary == ""
+}
+
+// SetLastSearch stores s in a cookie signed with key. Empty searches and searches too long to
+// fit in the cookie are not stored
+func SetLastSearch(w http.ResponseWriter, r *http.Request, key []byte, s LastSearch) {
+	if s.IsEmpty() {
+		return
+	}
+	s.Version = lastSearchVersion
+	payload, err := json.Marshal(s)
+	if err != nil {
+		return
+	}
+	value := base64.RawURLEncoding.EncodeToString(payload) + "." + lastSearchSignature(key, payload)
+	if len(value) > maxLastSearchCookieLength {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     LastSearchCookieName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(LastSearchTTL.Seconds()),
+		Secure:   requestScheme(r) == "https",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// GetLastSearch returns the search stored by SetLastSearch. Cookies that were tampered with,
+// signed with another key or written in an older format are ignored
+func GetLastSearch(r *http.Request, key []byte) (LastSearch, bool) {
+	c, err := r.Cookie(LastSearchCookieName)
+	if err != nil || len(c.Value) > maxLastSearchCookieLength {
+		return LastSearch{}, false
+	}
+	parts := strings.SplitN(c.Value, ".", 2)
+	if len(parts) != 2 {
+		return LastSearch{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return LastSearch{}, false
+	}
+	if !hmac.Equal([]byte(parts[1]), []byte(lastSearchSignature(key, payload))) {
+		return LastSearch{}, false
+	}
+	var s LastSearch
+	if err := json.Unmarshal(payload, &s); err != nil || s.Version != lastSearchVersion || s.IsEmpty() {
+		return LastSearch{}, false
+	}
+	return s, true
+}
+
+func lastSearchSignature(key, payload []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("last-search\n"))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"golang.org/x/text/language"
+)
+
+// LocaleParam is the query parameter and cookie that override Accept-Language
+const LocaleParam = "lang"
+
+type localeContextKey struct{}
+
+// LocaleFromContext returns the locale picked by LocaleMiddleware, or "" outside of it
+func LocaleFromContext(ctx context.Context) string {
+	locale, _ := ctx.Value(localeContextKey{}).(string)
+	return locale
+}
+
+// LocaleMiddleware stores in the request context the entry of supported that best matches
+// the visitor, see LocaleFromContext. A ?lang= query parameter wins and is remembered in a
+// cookie, then the lang cookie, then Accept-Language by quality. defaultLocale is used when
+// nothing matches
+func LocaleMiddleware(next http.Handler, supported []string, defaultLocale string) http.Handler {
+	locales := []string{defaultLocale}
+	tags := []language.Tag{language.Make(defaultLocale)}
+	for _, l := range supported {
+		tag, err := language.Parse(l)
+		if err != nil || l == defaultLocale {
+			continue
+		}
+		locales = append(locales, l)
+		tags = append(tags, tag)
+	}
+	matcher := language.NewMatcher(tags)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var prefs []language.Tag
+		if override := r.URL.Query().Get(LocaleParam); override != "" {
+			if tag, err := language.Parse(override); err == nil {
+				prefs = []language.Tag{tag}
+				http.SetCookie(w, &http.Cookie{
+					Name:     LocaleParam,
+					Value:    tag.String(),
+					Path:     "/",
+					Expires:  time.Now().AddDate(1, 0, 0),
+					HttpOnly: true,
+					SameSite: http.SameSiteLaxMode,
+				})
+			}
+		}
+		if prefs == nil {
+			if c, err := r.Cookie(LocaleParam); err == nil {
+				if tag, err := language.Parse(c.Value); err == nil {
+					prefs = []language.Tag{tag}
+				}
+			}
+		}
+		if prefs == nil {
+			// ParseAcceptLanguage returns the tags sorted by quality value
+			prefs, _, _ = language.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+		}
+		locale := defaultLocale
+		if _, index, confidence := matcher.Match(prefs...); confidence != language.No {
+			locale = locales[index]
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), localeContextKey{}, locale)))
+	})
+}
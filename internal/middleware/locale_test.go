@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLocaleMiddleware(t *testing.T) {
+	tests := []struct {
+		name           string
+		acceptLanguage string
+		query          string
+		cookie         string
+		want           string
+	}{
+		{"no preference", "", "", "", "en"},
+		{"single language", "de", "", "", "de"},
+		{"region falls back to the language", "fr-CA", "", "", "fr"},
+		{"highest quality wins over order", "es;q=0.3, it;q=0.9, de;q=0.5", "", "", "it"},
+		{"missing q means 1", "pt;q=0.8, de", "", "", "de"},
+		{"unsupported languages are skipped", "ja, ko;q=0.9, de;q=0.1", "", "", "de"},
+		{"q=0 refuses a language", "de;q=0, fr;q=0.2", "", "", "fr"},
+		{"nothing supported falls back to the default", "ja, ko;q=0.9", "", "", "en"},
+		{"malformed header falls back to the default", ";;q=x,,", "", "", "en"},
+		{"cookie beats Accept-Language", "de", "", "es", "es"},
+		{"query beats the cookie", "de", "it", "es", "it"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			h := LocaleMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = LocaleFromContext(r.Context())
+			}), []string{"en", "de", "es", "fr", "it", "pt"}, "en")
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.acceptLanguage != "" {
+				r.Header.Set("Accept-Language", tt.acceptLanguage)
+			}
+			if tt.query != "" {
+				r.URL.RawQuery = LocaleParam + "=" + tt.query
+			}
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: LocaleParam, Value: tt.cookie})
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			if got != tt.want {
+				t.Errorf("locale = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"context"
+
+	"github.com/rs/zerolog"
+)
+
+type loggerContextKey struct{}
+
+// WithLogger returns a copy of ctx carrying logger
+func WithLogger(ctx context.Context, logger zerolog.Logger) context.Context {
+	return context.WithValue(ctx, loggerContextKey{}, logger)
+}
+
+// LoggerFromContext returns the request scoped logger, or a no-op logger when none is set
+func LoggerFromContext(ctx context.Context) *zerolog.Logger {
+	logger, ok := ctx.Value(loggerContextKey{}).(zerolog.Logger)
+	if !ok {
+		logger = zerolog.Nop()
+	}
+	return &logger
+}
+
+// withUserLogger adds the user_id field to the request scoped logger, if any
+func withUserLogger(ctx context.Context, userID string) context.Context {
+	logger, ok := ctx.Value(loggerContextKey{}).(zerolog.Logger)
+	if !ok {
+		return ctx
+	}
+	return WithLogger(ctx, logger.With().Str("user_id", userID).Logger())
+}
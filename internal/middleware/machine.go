@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MachineSignedRequests switches MachineAuthenticatedMiddleware from comparing the static
+// x-machine-token to verifying per request HMAC signatures. Set it at startup
+var MachineSignedRequests = false
+
+// MachineSignatureSkew is how far x-machine-timestamp may be from the server clock. Signatures
+// are remembered until their timestamp falls out of it, so each can only be used once
+var MachineSignatureSkew = 5 * time.Minute
+
+const (
+	MachineSignatureHeader = "x-machine-signature"
+	MachineTimestampHeader = "x-machine-timestamp"
+
+	// MaxMachineBodyBytes bounds the body read to verify a signature
+	MaxMachineBodyBytes = 1 << 20
+)
+
+// MachineSignature returns the hex encoded HMAC-SHA256, keyed with secret, of method, path,
+// the raw query, the unix timestamp and the hex encoded SHA-256 of body, joined by newlines
+func MachineSignature(secret []byte, method, path, rawQuery string, timestamp int64, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(method + "\n" + path + "\n" + rawQuery + "\n" + strconv.FormatInt(timestamp, 10) + "\n" + hex.EncodeToString(bodyHash[:])))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignMachineRequest sets the signature headers on req, for machine clients. The body is
+// read and replaced so the request can still be sent
+func SignMachineRequest(req *http.Request, secret []byte) error {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		if err != nil {
+			return err
+		}
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	now := time.Now().Unix()
+	req.Header.Set(MachineTimestampHeader, strconv.FormatInt(now, 10))
+	req.Header.Set(MachineSignatureHeader, MachineSignature(secret, req.Method, req.URL.Path, req.URL.RawQuery, now, body))
+	return nil
+}
+
+// seenSignatures holds the machine signatures already accepted, until they expire
+var seenSignatures = &signatureSet{expires: make(map[string]time.Time)}
+
+type signatureSet struct {
+	mu      sync.Mutex
+	expires map[string]time.Time
+}
+
+// add records signature until expiresAt, and reports false if it was already recorded
+func (s *signatureSet) add(signature string, expiresAt, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for sig, exp := range s.expires {
+		if !exp.After(now) {
+			delete(s.expires, sig)
+		}
+	}
+	if _, ok := s.expires[signature]; ok {
+		return false
+	}
+	s.expires[signature] = expiresAt
+	return true
+}
+
+// verifyMachineSignature checks the signature headers of r against secret and that they
+// weren't used before, leaving the body readable. Bodies over MaxMachineBodyBytes fail
+func verifyMachineSignature(w http.ResponseWriter, r *http.Request, secret []byte) bool {
+	timestamp, err := strconv.ParseInt(r.Header.Get(MachineTimestampHeader), 10, 64)
+	if err != nil {
+		return false
+	}
+	skew := time.Since(time.Unix(timestamp, 0))
+	if skew > MachineSignatureSkew || skew < -MachineSignatureSkew {
+		return false
+	}
+	signature, err := hex.DecodeString(r.Header.Get(MachineSignatureHeader))
+	if err != nil || len(signature) == 0 {
+		return false
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, MaxMachineBodyBytes))
+	if err != nil {
+		return false
+	}
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	expected, _ := hex.DecodeString(MachineSignature(secret, r.Method, r.URL.Path, r.URL.RawQuery, timestamp, body))
+	if !hmac.Equal(signature, expected) {
+		return false
+	}
+	// only valid signatures are recorded, so forged ones can't fill the set
+	return seenSignatures.add(hex.EncodeToString(signature), time.Unix(timestamp, 0).Add(MachineSignatureSkew), time.Now())
+}
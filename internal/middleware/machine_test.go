@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMachineSignedRequests(t *testing.T) {
+	MachineSignedRequests = true
+	defer func() { MachineSignedRequests = false }()
+	const secret = "test-machine-token"
+	h := MachineAuthenticatedMiddleware(secret, func(w http.ResponseWriter, r *http.Request) {})
+	// signed returns a request to target signed with secret, mutate changes it after signing
+	signed := func(target, body string, mutate func(*http.Request)) *http.Request {
+		r := httptest.NewRequest("POST", target, strings.NewReader(body))
+		if err := SignMachineRequest(r, []byte(secret)); err != nil {
+			t.Fatal(err)
+		}
+		if mutate != nil {
+			mutate(r)
+		}
+		return r
+	}
+	first := signed("/x/task/replayed", "{}", nil)
+	// a captured request sent again as is
+	replayed := httptest.NewRequest("POST", "/x/task/replayed", strings.NewReader("{}"))
+	replayed.Header = first.Header.Clone()
+
+	tests := []struct {
+		name     string
+		req      *http.Request
+		wantCode int
+	}{
+		{"signed request", signed("/x/task/a?dry=1", "{}", nil), http.StatusOK},
+		{"first use", first, http.StatusOK},
+		{"replayed signature", replayed, http.StatusUnauthorized},
+		{"query changed after signing", signed("/x/task/b?dry=1", "{}", func(r *http.Request) { r.URL.RawQuery = "dry=0" }), http.StatusUnauthorized},
+		{"body too large", signed("/x/task/c", strings.Repeat("a", MaxMachineBodyBytes+1), nil), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h(w, tt.req)
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+		})
+	}
+}
@@ -4,71 +4,298 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"firebase.google.com/go/auth"
 	"github.com/golang-cafe/job-board/internal/gzip"
+	"github.com/golang-cafe/job-board/internal/servertiming"
+	"github.com/golang-cafe/job-board/internal/user"
 
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/gorilla/sessions"
 	"github.com/rs/zerolog"
+	"github.com/segmentio/ksuid"
 )
 
 var (
 	ErrNoAuthSession           = errors.New("no authentication session")
 	ErrNoAuthCookie            = errors.New("no authentication cookie")
 	ErrTokenVerificationFailed = errors.New("token verification failed")
+	ErrTokenExpired            = errors.New("token expired")
+	ErrReauthRequired          = errors.New("reauthentication required")
+	ErrViewingAsPublic         = errors.New("admin is viewing the site as a visitor")
 )
 
+// HTTPSMiddleware redirects plain http requests to https outside dev. The scheme comes from
+// the TLS state, or from SchemeHeaders on requests from TrustedProxies, see requestScheme.
+// Behind a proxy that terminates TLS, list it in TrustedProxies or every request is redirected
 func HTTPSMiddleware(next http.Handler, env string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if env != "dev" && r.Header.Get("X-Forwarded-Proto") != "https" {
-			target := "https://" + r.Host + r.URL.Path
+		if env != "dev" && requestScheme(r) == "http" {
+			target := "https://" + r.Host + r.URL.RequestURI()
 			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
 		}
 
 		next.ServeHTTP(w, r)
 	})
 }
 
+// AccessLogSampleRate is the fraction of successful requests LoggingMiddleware logs, 1 logs all of them.
+// Client and server errors and requests slower than AccessLogSlowThreshold are always logged
+var (
+	AccessLogSampleRate    = 1.0
+	AccessLogSlowThreshold = time.Second
+)
+
+// AccessLogHeaders are the request headers LoggingMiddleware adds to each access log line,
+// besides x-forwarded-for. Headers in AccessLogDeniedHeaders are logged as redacted, whatever
+// operators put here
+var AccessLogHeaders []string
+
+// AccessLogDeniedHeaders hold credentials and are never written to the access log
+var AccessLogDeniedHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Machine-Token",
+	MachineSignatureHeader,
+}
+
+const redactedHeaderValue = "[redacted]"
+
+// loggedHeaders returns the AccessLogHeaders present on r, with denied ones redacted
+func loggedHeaders(r *http.Request) *zerolog.Event {
+	dict := zerolog.Dict()
+	for _, name := range AccessLogHeaders {
+		value := r.Header.Get(name)
+		if value == "" {
+			continue
+		}
+		for _, denied := range AccessLogDeniedHeaders {
+			if strings.EqualFold(name, denied) {
+				value = redactedHeaderValue
+				break
+			}
+		}
+		dict = dict.Str(strings.ToLower(name), value)
+	}
+	return dict
+}
+
+// sampled decides by request id, so every service seeing the same X-Request-ID keeps or drops it alike
+func sampled(requestID string, rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+	if rate <= 0 {
+		return false
+	}
+	h := fnv.New64a()
+	h.Write([]byte(requestID))
+	return float64(h.Sum64()%10000) < rate*10000
+}
+
 func LoggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get("X-Request-ID")
+		if requestID == "" {
+			requestID = ksuid.New().String()
+		}
 		logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: time.RFC3339}).
 			With().
 			Timestamp().
+			Str("request_id", requestID).
 			Logger()
-		logger.Info().
+		w.Header().Set("X-Request-ID", requestID)
+		r = r.WithContext(WithLogger(withRoutePattern(r.Context()), logger))
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		duration := time.Since(start)
+		if sw.status < http.StatusBadRequest && duration < AccessLogSlowThreshold && !sampled(requestID, AccessLogSampleRate) {
+			return
+		}
+		// logged once the request is served so the matched route is known
+		event := logger.Info()
+		if len(AccessLogHeaders) > 0 {
+			event = event.Dict("headers", loggedHeaders(r))
+		}
+		event.
 			Str("Host", r.Host).
 			Str("method", r.Method).
 			Stringer("url", r.URL).
+			Str("route", routeOrPath(r)).
+			Int("status", sw.status).
+			Dur("duration", duration).
 			Str("x-forwarded-for", r.Header.Get("x-forwarded-for")).
 			Msg("req")
-		next.ServeHTTP(w, r)
 	})
 }
 
-func HeadersMiddleware(next http.Handler, env string) http.Handler {
+// HeadersConfig holds the security headers that differ between deployments. Empty
+// fields fall back to DefaultReferrerPolicy and DefaultPermissionsPolicy
+type HeadersConfig struct {
+	ReferrerPolicy    string
+	PermissionsPolicy string
+	// NoIndexPaths are path prefixes, such as private profile pages, that get an
+	// X-Robots-Tag: noindex header even in production. Outside production every page gets it
+	NoIndexPaths []string
+	// HSTSMaxAge is the max-age of Strict-Transport-Security, zero uses DefaultHSTSMaxAge
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubDomains bool
+	// HSTSPreload adds the preload directive, asking browsers to ship the domain as https only.
+	// Preloading is one way in practice: removal from the list takes months to reach browsers,
+	// during which the site and every subdomain are unreachable over plain http. It is only
+	// honoured in prod, with includeSubDomains and a max-age of at least a year, as the
+	// preload list requires
+	HSTSPreload bool
+}
+
+// DefaultHSTSMaxAge is a year, the minimum accepted by the HSTS preload list
+const DefaultHSTSMaxAge = 365 * 24 * time.Hour
+
+// hstsHeader builds the Strict-Transport-Security value for cfg, dropping preload where it
+// would pin a development host or be rejected by the preload list anyway
+func hstsHeader(env string, cfg HeadersConfig) string {
+	maxAge := cfg.HSTSMaxAge
+	if maxAge <= 0 {
+		maxAge = DefaultHSTSMaxAge
+	}
+	value := "max-age=" + strconv.FormatInt(int64(maxAge/time.Second), 10)
+	if cfg.HSTSIncludeSubDomains {
+		value += "; includeSubDomains"
+	}
+	if !cfg.HSTSPreload {
+		return value
+	}
+	switch {
+	case env != "prod":
+		log.Printf("warning: not sending HSTS preload outside prod, env is %s", env)
+	case !cfg.HSTSIncludeSubDomains || maxAge < DefaultHSTSMaxAge:
+		log.Println("warning: not sending HSTS preload, it needs includeSubDomains and a max-age of at least a year")
+	default:
+		value += "; preload"
+	}
+	return value
+}
+
+const (
+	DefaultReferrerPolicy    = "strict-origin-when-cross-origin"
+	DefaultPermissionsPolicy = "geolocation=(), camera=(), microphone=(), payment=(), usb=(), interest-cohort=()"
+)
+
+func HeadersMiddleware(next http.Handler, env string, cfg HeadersConfig) http.Handler {
+	referrerPolicy := cfg.ReferrerPolicy
+	if referrerPolicy == "" {
+		referrerPolicy = DefaultReferrerPolicy
+	}
+	permissionsPolicy := cfg.PermissionsPolicy
+	if permissionsPolicy == "" {
+		permissionsPolicy = DefaultPermissionsPolicy
+	}
+	hsts := hstsHeader(env, cfg)
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if env != "prod" || hasPathPrefix(r.URL.Path, cfg.NoIndexPaths) {
+			w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+		}
 		if env != "dev" {
-			// filter out HeadlessChrome user agent
-			if strings.Contains(r.Header.Get("User-Agent"), "HeadlessChrome") {
-				w.WriteHeader(http.StatusTeapot)
-				return
-			}
 			w.Header().Set("Content-Security-Policy", "upgrade-insecure-requests")
 			w.Header().Set("X-Frame-Options", "deny")
 			w.Header().Set("X-XSS-Protection", "1; mode=block")
 			w.Header().Set("X-Content-Type-Options", "nosniff")
-			w.Header().Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
-			w.Header().Set("Referrer-Policy", "origin")
+			w.Header().Set("Strict-Transport-Security", hsts)
+			w.Header().Set("Referrer-Policy", referrerPolicy)
+			w.Header().Set("Permissions-Policy", permissionsPolicy)
 		}
 		next.ServeHTTP(w, r)
 	})
 }
 
+// StripTrailingSlashMiddleware permanently redirects any non-root path ending in a
+// slash to the same path without it, preserving the query string. Paths under
+// StaticPathPrefixes are left alone, http.FileServer redirects directories the other way
+func StripTrailingSlashMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" && strings.HasSuffix(r.URL.Path, "/") && !isStaticPath(r.URL.Path) {
+			// collapse leading slashes too so "//evil.com/" can't become a protocol relative redirect
+			target := "/" + strings.Trim(r.URL.Path, "/")
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			status := http.StatusMovedPermanently
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				// 301 lets clients turn the request into a GET, 308 keeps the method and body
+				status = http.StatusPermanentRedirect
+			}
+			http.Redirect(w, r, target, status)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AllowMethods responds with 405 and the list of permitted methods in the Allow
+// header when the request method isn't one of methods. OPTIONS requests are answered
+// with 204 and the same Allow header
+func AllowMethods(next http.HandlerFunc, methods ...string) http.HandlerFunc {
+	allowed := make(map[string]bool, len(methods)+1)
+	for _, m := range methods {
+		allowed[strings.ToUpper(m)] = true
+	}
+	if allowed[http.MethodGet] {
+		allowed[http.MethodHead] = true
+	}
+	allowed[http.MethodOptions] = true
+	allow := make([]string, 0, len(allowed))
+	for _, m := range append(append([]string{}, methods...), http.MethodHead, http.MethodOptions) {
+		m = strings.ToUpper(m)
+		if allowed[m] && !containsString(allow, m) {
+			allow = append(allow, m)
+		}
+	}
+	allowHeader := strings.Join(allow, ", ")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			w.Header().Set("Allow", allowHeader)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if !allowed[r.Method] {
+			w.Header().Set("Allow", allowHeader)
+			errorResponder.Respond(w, r, http.StatusMethodNotAllowed, "")
+			return
+		}
+		next(w, r)
+	})
+}
+
+func hasPathPrefix(path string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(items []string, s string) bool {
+	for _, item := range items {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
 func GzipMiddleware(next http.Handler) http.Handler {
 	return gzip.GzipHandler(next)
 }
@@ -81,95 +308,190 @@ type UserJWT struct {
 	Email       string    `json:"email"`
 	Type        string    `json:"type"`
 	CreatedAt   time.Time `json:"created_at"`
+	// TokenVersion is the users.token_version the jwt was issued for
+	TokenVersion int `json:"token_version,omitempty"`
+	// AuthTime is when the user signed in, as a unix time. Unlike IssuedAt it is kept when
+	// SessionRenewalMiddleware re-issues the jwt, so it is what ForceReauth is checked against
+	AuthTime int64 `json:"auth_time,omitempty"`
+	// ImpersonatorID is set by GetUserFromJWT when an admin impersonates the user, it is
+	// never part of a signed jwt
+	ImpersonatorID string `json:"-"`
 	jwt.StandardClaims
 }
 
-func AdminAuthenticatedMiddleware(sessionStore *sessions.CookieStore, jwtKey []byte, next http.HandlerFunc) http.HandlerFunc {
+// signedInAt is when the user signed in, falling back to the issue time of jwts issued
+// before AuthTime was added, which renewals kept moving
+func (c *UserJWT) signedInAt() time.Time {
+	if c.AuthTime > 0 {
+		return time.Unix(c.AuthTime, 0)
+	}
+	return time.Unix(c.IssuedAt, 0)
+}
+
+// TokenVersion looks up the current token version of a user. When set, session jwts issued
+// for an older version, e.g. before the user changed role, are rejected as if they had expired
+var TokenVersion func(ctx context.Context, userID string) (int, error)
+
+// tokenRevoked reports whether claims were issued before the last token version bump or
+// before the user was forced to re-authenticate, or belong to a user that no longer exists,
+// e.g. one deleted or merged into another. Other lookup failures are not treated as
+// revocations so a database outage doesn't log everyone out
+func tokenRevoked(r *http.Request, claims *UserJWT) bool {
+	if claims.UserID == "" {
+		return false
+	}
+	if reauthRequired(r.Context(), claims.UserID, claims.signedInAt()) {
+		return true
+	}
+	if TokenVersion == nil {
+		return false
+	}
+	current, err := TokenVersion(r.Context(), claims.UserID)
+	if errors.Is(err, user.ErrUserNotFound) {
+		return true
+	}
+	if err != nil {
+		return false
+	}
+	return claims.TokenVersion < current
+}
+
+func AdminAuthenticatedMiddleware(sessionStore sessions.Store, jwtKey []byte, next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		sess, err := sessionStore.Get(r, "____gc")
+		sess, err := GetSession(r, sessionStore)
 		if err != nil {
-			http.Redirect(w, r, "/auth", http.StatusUnauthorized)
+			authEvent(r, AuthEventNoSession).Msg("auth")
+			RedirectToAuth(w, r)
 			return
 		}
 		tk, ok := sess.Values["jwt"].(string)
 		if !ok {
-			http.Redirect(w, r, "/auth", http.StatusUnauthorized)
+			authEvent(r, AuthEventNoSession).Msg("auth")
+			RedirectToAuth(w, r)
 			return
 		}
-		token, err := jwt.ParseWithClaims(tk, &UserJWT{}, func(token *jwt.Token) (interface{}, error) {
-			return jwtKey, nil
-		})
-		if !token.Valid {
-			http.Redirect(w, r, "/auth", http.StatusUnauthorized)
-			return
-		}
-		claims, ok := token.Claims.(*UserJWT)
-		if !ok {
-			http.Redirect(w, r, "/auth", http.StatusUnauthorized)
+		claims, err := parseSessionJWT(tk, jwtKey)
+		if err != nil || tokenRevoked(r, claims) {
+			authEvent(r, AuthEventTokenVerificationFailed).Msg("auth")
+			RedirectToAuth(w, r)
 			return
 		}
 		if !claims.IsAdmin {
-			http.Redirect(w, r, "/auth", http.StatusUnauthorized)
+			authEvent(r, AuthEventRoleDenied).Str("user_id", claims.UserID).Str("user_type", claims.Type).Msg("auth")
+			RedirectToAuth(w, r)
 			return
 		}
-		next(w, r)
+		authEvent(r, AuthEventSuccess).Str("user_id", claims.UserID).Str("user_type", claims.Type).Msg("auth")
+		next(w, withIdentity(r, claims))
 	})
 }
 
 func MachineAuthenticatedMiddleware(machineToken string, next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if MachineSignedRequests {
+			if !verifyMachineSignature(w, r, []byte(machineToken)) {
+				authEvent(r, AuthEventTokenVerificationFailed).Str("user_type", "machine").Msg("auth")
+				errorResponder.Respond(w, r, http.StatusUnauthorized, "invalid, expired or reused machine signature")
+				return
+			}
+			next(w, r)
+			return
+		}
 		token := r.Header.Get("x-machine-token")
 		if token != machineToken {
-			w.WriteHeader(http.StatusUnauthorized)
+			authEvent(r, AuthEventTokenVerificationFailed).Str("user_type", "machine").Msg("auth")
+			errorResponder.Respond(w, r, http.StatusUnauthorized, "invalid machine token")
 			return
 		}
 		next(w, r)
 	})
 }
 
-func authenticateFromCookie(sessionStore *sessions.CookieStore, authClient *auth.Client, r *http.Request) (*auth.Token, error) {
-	sess, err := sessionStore.Get(r, "____gc")
+func authenticateFromCookie(sessionStore sessions.Store, authClient *auth.Client, r *http.Request) (*http.Request, *auth.Token, error) {
+	sess, err := GetSession(r, sessionStore)
 	if err != nil {
-		return nil, ErrNoAuthSession
-	}
-
-	if sess.IsNew {
-		fmt.Println("Session is new")
+		return r, nil, ErrNoAuthSession
 	}
 
 	tk, ok := sess.Values["jwt"].(string)
 	if !ok {
-		return nil, ErrNoAuthCookie
+		return r, nil, ErrNoAuthCookie
+	}
+	if _, ok := sess.Values[sessionKeyImpersonatedUserID]; ok {
+		return r, nil, ErrImpersonating
 	}
 
-	authToken, err := authClient.VerifyIDToken(context.Background(), tk)
+	// fail fast while firebase is unreachable rather than piling requests up behind it
+	if !AuthBreaker.Allow() {
+		return r, nil, ErrTokenVerificationFailed
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), AuthVerifyTimeout)
+	verifyStart := time.Now()
+	authToken, err := authClient.VerifyIDToken(ctx, tk)
+	cancel()
+	servertiming.RecordTiming(r.Context(), "auth", time.Since(verifyStart))
 	if err != nil {
-		return nil, ErrTokenVerificationFailed
+		if isUpstreamFailure(err) {
+			AuthBreaker.Failure()
+		} else {
+			AuthBreaker.Success()
+		}
+		// the firebase sdk has no typed error for expired tokens
+		if strings.Contains(err.Error(), "has expired") {
+			return r, nil, ErrTokenExpired
+		}
+		return r, nil, ErrTokenVerificationFailed
 	}
+	AuthBreaker.Success()
+	if reauthRequired(r.Context(), authToken.UID, authTime(authToken)) {
+		return r, nil, ErrReauthRequired
+	}
+	syncEmailVerified(r.Context(), authToken)
+	activeUsers.touch(authToken.UID)
 
-	return authToken, nil
+	return r, authToken, nil
 }
 
-func UserAuthenticatedMiddleware(sessionStore *sessions.CookieStore, authClient *auth.Client, next http.HandlerFunc) http.HandlerFunc {
+func UserAuthenticatedMiddleware(sessionStore sessions.Store, authClient *auth.Client, next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		tk, err := authenticateFromCookie(sessionStore, authClient, r)
-		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
+		r, tk, err := authenticateFromCookie(sessionStore, authClient, r)
+		if err != nil || tk == nil {
+			authEvent(r, authFailureEvent(err)).Msg("auth")
+			if err == ErrReauthRequired {
+				redirectToReauth(w, r)
+				return
+			}
+			if err == ErrImpersonating {
+				errorResponder.Respond(w, r, http.StatusForbidden, err.Error())
+				return
+			}
+			errorResponder.Respond(w, r, http.StatusUnauthorized, "you need to sign in to access this resource")
 			return
 		}
+		authEvent(r, AuthEventSuccess).Str("user_id", tk.UID).Msg("auth")
 
 		//TODO: Use predefined context key.
-		r = r.WithContext(context.WithValue(r.Context(), "authToken", tk))
+		r = r.WithContext(context.WithValue(withUserLogger(r.Context(), tk.UID), "authToken", tk))
 		next(w, r)
 	})
 }
 
-func UserAuthenticatedPageMiddleware(sessionStore *sessions.CookieStore, authClient *auth.Client, next http.HandlerFunc) http.HandlerFunc {
+func UserAuthenticatedPageMiddleware(sessionStore sessions.Store, authClient *auth.Client, next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		tk, err := authenticateFromCookie(sessionStore, authClient, r)
+		r, tk, err := authenticateFromCookie(sessionStore, authClient, r)
+		if err != nil || tk == nil {
+			authEvent(r, authFailureEvent(err)).Msg("auth")
+		}
 		if err == ErrNoAuthSession || err == ErrNoAuthCookie {
-			fmt.Println("redirecting to auth")
-			http.Redirect(w, r, "/auth", http.StatusUnauthorized)
+			RedirectToAuth(w, r)
+			return
+		}
+		if err == ErrReauthRequired {
+			redirectToReauth(w, r)
+			return
+		}
+		if err == ErrImpersonating {
+			errorResponder.Respond(w, r, http.StatusForbidden, err.Error())
 			return
 		}
 		directTo := r.URL.Path
@@ -177,34 +499,72 @@ func UserAuthenticatedPageMiddleware(sessionStore *sessions.CookieStore, authCli
 			directTo = "/profile/home"
 		}
 
-		if err == ErrTokenVerificationFailed {
+		if err == ErrTokenVerificationFailed || err == ErrTokenExpired {
 			// The token exists but has expired. Serve the auto login page that attempts to re-login and redirect.
 			http.Redirect(w, r, fmt.Sprintf("/autologin?directto=%s", directTo), http.StatusSeeOther)
 			return
 		}
-		r = r.WithContext(context.WithValue(r.Context(), "authToken", tk))
+		if err != nil || tk == nil {
+			RedirectToAuth(w, r)
+			return
+		}
+		authEvent(r, AuthEventSuccess).Str("user_id", tk.UID).Msg("auth")
+		r = r.WithContext(context.WithValue(withUserLogger(r.Context(), tk.UID), "authToken", tk))
 		next(w, r)
 	})
 }
 
 // For page
-func InjectAuthTokenMiddleware(sessionStore *sessions.CookieStore, authClient *auth.Client, next http.HandlerFunc) http.HandlerFunc {
+func InjectAuthTokenMiddleware(sessionStore sessions.Store, authClient *auth.Client, next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		tk, err := authenticateFromCookie(sessionStore, authClient, r)
+		r, tk, err := authenticateFromCookie(sessionStore, authClient, r)
 		directTo := r.URL.Path
-		if err == ErrTokenVerificationFailed {
+		if err == ErrTokenVerificationFailed || err == ErrTokenExpired {
+			authEvent(r, authFailureEvent(err)).Msg("auth")
 			http.Redirect(w, r, fmt.Sprintf("/autologin?directto=%s", directTo), http.StatusSeeOther)
 			return
 		}
-		if err == nil {
-			r = r.WithContext(context.WithValue(r.Context(), "authToken", tk))
+		if err == ErrReauthRequired {
+			authEvent(r, authFailureEvent(err)).Msg("auth")
+			redirectToReauth(w, r)
+			return
+		}
+		if err == nil && tk != nil {
+			r = r.WithContext(context.WithValue(withUserLogger(r.Context(), tk.UID), "authToken", tk))
 		}
 		next(w, r)
 	})
 }
 
-func GetUserFromJWT(r *http.Request, sessionStore *sessions.CookieStore, jwtKey []byte) (*UserJWT, error) {
-	sess, err := sessionStore.Get(r, "____gc")
+// parseSessionJWT verifies tk, the site signed jwt stored in the session, against jwtKey.
+// tk comes straight from the cookie so anything wrong with it, down to a panic while
+// decoding, is reported as an error for callers to treat as not signed in
+func parseSessionJWT(tk string, jwtKey []byte) (claims *UserJWT, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			claims, err = nil, fmt.Errorf("malformed session jwt: %v", rec)
+		}
+	}()
+	claims = &UserJWT{}
+	token, err := jwt.ParseWithClaims(tk, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return jwtKey, nil
+	})
+	if err != nil || token == nil || !token.Valid {
+		return nil, errors.New("token is invalid or expired")
+	}
+	return claims, nil
+}
+
+// GetUserFromJWT returns the claims of the session jwt, reusing the ones already verified in
+// this request by AdminAuthenticatedMiddleware or IdentityMiddleware when there are some
+func GetUserFromJWT(r *http.Request, sessionStore sessions.Store, jwtKey []byte) (*UserJWT, error) {
+	if claims, ok := IdentityFromContext(r.Context()); ok {
+		return claims, nil
+	}
+	sess, err := GetSession(r, sessionStore)
 	if err != nil {
 		return nil, errors.New("could not find cookie")
 	}
@@ -212,21 +572,21 @@ func GetUserFromJWT(r *http.Request, sessionStore *sessions.CookieStore, jwtKey
 	if !ok {
 		return nil, errors.New("could not find jwt in session")
 	}
-	token, err := jwt.ParseWithClaims(tk, &UserJWT{}, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
-	})
-	if !token.Valid {
-		return nil, errors.New("token is expired")
+	claims, err := parseSessionJWT(tk, jwtKey)
+	if err != nil {
+		return nil, err
 	}
-	claims, ok := token.Claims.(*UserJWT)
-	if !ok {
-		return nil, errors.New("could not convert jwt claims to UserJWT")
+	if tokenRevoked(r, claims) {
+		return nil, errors.New("token has been revoked")
 	}
-	return claims, nil
+	if viewingAsPublic(sess, claims.UserID) {
+		return nil, ErrViewingAsPublic
+	}
+	return applyImpersonation(sess, claims), nil
 }
 
-func IsSignedOn(r *http.Request, sessionStore *sessions.CookieStore, jwtKey []byte) bool {
-	sess, err := sessionStore.Get(r, "____gc")
+func IsSignedOn(r *http.Request, sessionStore sessions.Store, jwtKey []byte) bool {
+	sess, err := GetSession(r, sessionStore)
 	if err != nil {
 		return false
 	}
@@ -234,14 +594,9 @@ func IsSignedOn(r *http.Request, sessionStore *sessions.CookieStore, jwtKey []by
 	if !ok {
 		return false
 	}
-	token, err := jwt.ParseWithClaims(tk, &UserJWT{}, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
-	})
-	if !token.Valid {
-		return false
-	}
-	if !ok {
+	claims, err := parseSessionJWT(tk, jwtKey)
+	if err != nil {
 		return false
 	}
-	return true
+	return !tokenRevoked(r, claims) && !viewingAsPublic(sess, claims.UserID)
 }
@@ -0,0 +1,154 @@
+package middleware
+
+import (
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestAllowMethods(t *testing.T) {
+	tests := []struct {
+		name       string
+		methods    []string
+		method     string
+		wantStatus int
+		wantAllow  string
+	}{
+		{"allowed method reaches the handler", []string{http.MethodPost}, http.MethodPost, http.StatusOK, ""},
+		{"other method gets a 405", []string{http.MethodPost}, http.MethodGet, http.StatusMethodNotAllowed, "POST, OPTIONS"},
+		{"GET allows HEAD", []string{http.MethodGet}, http.MethodHead, http.StatusOK, ""},
+		{"GET lists HEAD", []string{http.MethodGet}, http.MethodDelete, http.StatusMethodNotAllowed, "GET, HEAD, OPTIONS"},
+		{"methods are case insensitive", []string{"get", "post"}, http.MethodPost, http.StatusOK, ""},
+		{"lowercase methods are listed upper case", []string{"get", "post"}, http.MethodPut, http.StatusMethodNotAllowed, "GET, POST, HEAD, OPTIONS"},
+		{"OPTIONS is answered without the handler", []string{http.MethodPut, http.MethodDelete}, http.MethodOptions, http.StatusNoContent, "PUT, DELETE, OPTIONS"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			h := AllowMethods(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+			}, tt.methods...)
+			rec := httptest.NewRecorder()
+			h(rec, httptest.NewRequest(tt.method, "/x/endpoint", nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Allow"); got != tt.wantAllow {
+				t.Errorf("Allow = %q, want %q", got, tt.wantAllow)
+			}
+			if wantCalled := tt.wantStatus == http.StatusOK; called != wantCalled {
+				t.Errorf("handler called = %v, want %v", called, wantCalled)
+			}
+		})
+	}
+}
+
+func FuzzParseSessionJWT(f *testing.F) {
+	valid := signedSessionJWT(f, UserJWT{UserID: "1", Email: "jane@example.com", IsAdmin: true})
+	parts := strings.Split(valid, ".")
+	for _, seed := range []string{
+		"",
+		".",
+		"..",
+		"a.b.c",
+		valid,
+		valid + "x",
+		parts[0] + "." + parts[1] + ".",
+		"eyJhbGciOiJub25lIiwidHlwIjoiSldUIn0." + parts[1] + ".",
+		"eyJhbGciOiJIUzI1NiJ9.bnVsbA." + parts[2],
+		"eyJhbGciOiJIUzI1NiJ9.WzEsMiwzXQ." + parts[2],
+		"\x00\xff.\xfe.\x01",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, tk string) {
+		claims, err := parseSessionJWT(tk, testJWTKey)
+		if err == nil && claims == nil {
+			t.Fatalf("parseSessionJWT(%q) returned neither claims nor an error", tk)
+		}
+		if err != nil && claims != nil {
+			t.Fatalf("parseSessionJWT(%q) returned claims along with %v", tk, err)
+		}
+	})
+}
+
+// TestSessionJWTCheckersRejectGarbage feeds random bytes as the session jwt, and as the raw
+// cookie, to everything reading the site jwt. None may panic or let the request through
+func TestSessionJWTCheckersRejectGarbage(t *testing.T) {
+	store := NewSessionStore([]byte("0123456789abcdef0123456789abcdef"), SessionConfig{})
+	checkers := []struct {
+		name   string
+		signed func(r *http.Request) bool
+	}{
+		{"AdminAuthenticatedMiddleware", func(r *http.Request) bool {
+			called := false
+			AdminAuthenticatedMiddleware(store, testJWTKey, func(w http.ResponseWriter, r *http.Request) {
+				called = true
+			})(httptest.NewRecorder(), r)
+			return called
+		}},
+		{"GetUserFromJWT", func(r *http.Request) bool {
+			claims, err := GetUserFromJWT(r, store, testJWTKey)
+			return err == nil && claims != nil
+		}},
+		{"IsSignedOn", func(r *http.Request) bool {
+			return IsSignedOn(r, store, testJWTKey)
+		}},
+	}
+	valid := signedSessionJWT(t, UserJWT{UserID: "1", Email: "jane@example.com", IsAdmin: true})
+	rnd := rand.New(rand.NewSource(1))
+	for _, c := range checkers {
+		t.Run(c.name, func(t *testing.T) {
+			if !c.signed(sessionRequest(t, store, valid)) {
+				t.Fatal("rejected a valid session jwt")
+			}
+			for i := 0; i < 200; i++ {
+				b := make([]byte, rnd.Intn(512))
+				rnd.Read(b)
+				for _, r := range []*http.Request{sessionRequest(t, store, string(b)), rawCookieRequest(b)} {
+					func() {
+						defer func() {
+							if rec := recover(); rec != nil {
+								t.Fatalf("panicked on %q: %v", b, rec)
+							}
+						}()
+						if c.signed(r) {
+							t.Fatalf("accepted %q as signed in", b)
+						}
+					}()
+				}
+			}
+		})
+	}
+}
+
+// sessionRequest returns a request carrying a session cookie with tk as its jwt
+func sessionRequest(t *testing.T, store *sessions.CookieStore, tk string) *http.Request {
+	t.Helper()
+	r := httptest.NewRequest("GET", "/manage", nil)
+	sess, err := store.New(r, SessionCookieName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess.Values["jwt"] = tk
+	w := httptest.NewRecorder()
+	if err := sess.Save(r, w); err != nil {
+		t.Fatal(err)
+	}
+	r = httptest.NewRequest("GET", "/manage", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	return r
+}
+
+// rawCookieRequest returns a request whose session cookie is b itself, not a signed session
+func rawCookieRequest(b []byte) *http.Request {
+	r := httptest.NewRequest("GET", "/manage", nil)
+	r.Header.Set("Cookie", SessionCookieName+"="+string(b))
+	return r
+}
@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/golang-cafe/job-board/internal/gzip"
+)
+
+// precompressedEncodings are the encodings built at deploy time, in order of preference
+var precompressedEncodings = []struct {
+	encoding  string
+	extension string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// PrecompressedFileServer serves files from root like http.FileServer, but when the client
+// accepts it and a sibling file.br or file.gz exists, that is served instead with the matching
+// Content-Encoding and the Content-Type of the original file. Other requests fall through to
+// the plain file, which GzipMiddleware still compresses on the fly
+func PrecompressedFileServer(root http.FileSystem) http.Handler {
+	files := http.FileServer(root)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.URL.Query().Get("v") != "" {
+			// versioned by the template assetURL func, a new deploy changes the url
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		}
+		name := path.Clean("/" + r.URL.Path)
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			for _, pc := range precompressedEncodings {
+				if !gzip.AcceptsEncoding(r, pc.encoding) {
+					continue
+				}
+				if servePrecompressed(w, r, root, name, pc.encoding, pc.extension) {
+					return
+				}
+			}
+		}
+		files.ServeHTTP(w, r)
+	})
+}
+
+func servePrecompressed(w http.ResponseWriter, r *http.Request, root http.FileSystem, name, encoding, extension string) bool {
+	f, err := root.Open(name + extension)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil || stat.IsDir() {
+		return false
+	}
+	ctype := mime.TypeByExtension(path.Ext(name))
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Content-Encoding", encoding)
+	http.ServeContent(w, r, name, stat.ModTime(), f)
+	return true
+}
@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PreviewTokenTTL is how long a preview token stays valid after it is issued
+var PreviewTokenTTL = 30 * time.Minute
+
+// PreviewTokenParam is the query parameter PreviewMiddleware reads the token from
+const PreviewTokenParam = "preview"
+
+var (
+	ErrPreviewTokenInvalid = errors.New("invalid preview token")
+	ErrPreviewTokenExpired = errors.New("preview token expired")
+)
+
+type previewContextKey struct{}
+
+// PreviewScope is what a verified preview token grants: read access to one resource for one subject
+type PreviewScope struct {
+	Subject  string
+	Resource string
+}
+
+// NewPreviewToken returns a token, signed with key, that lets subject view resource until ttl elapses.
+// Tokens are not stored anywhere, they are only good for the resource they were issued for
+func NewPreviewToken(key []byte, subject, resource string, ttl time.Duration) string {
+	payload := subject + "\n" + resource + "\n" + strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + previewSignature(key, payload)
+}
+
+// VerifyPreviewToken checks token against key and resource and returns the subject it was issued to
+func VerifyPreviewToken(key []byte, token, resource string) (string, error) {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return "", ErrPreviewTokenInvalid
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", ErrPreviewTokenInvalid
+	}
+	payload := string(raw)
+	if !hmac.Equal([]byte(parts[1]), []byte(previewSignature(key, payload))) {
+		return "", ErrPreviewTokenInvalid
+	}
+	fields := strings.Split(payload, "\n")
+	if len(fields) != 3 || fields[0] == "" || fields[1] != resource {
+		return "", ErrPreviewTokenInvalid
+	}
+	expiresAt, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return "", ErrPreviewTokenInvalid
+	}
+	if time.Now().Unix() > expiresAt {
+		return "", ErrPreviewTokenExpired
+	}
+	return fields[0], nil
+}
+
+func previewSignature(key []byte, payload string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("preview\n" + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// PreviewMiddleware lets through read only requests carrying a valid preview token for the
+// resource returned by resource(r), without requiring a verified account. The granted scope is
+// stored in the request context, see GetPreviewScope. Anything other than GET and HEAD is
+// rejected so a preview token can never be used to change or publish the resource
+func PreviewMiddleware(key []byte, resource func(r *http.Request) string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			errorResponder.Respond(w, r, http.StatusMethodNotAllowed, "previews are read only")
+			return
+		}
+		res := resource(r)
+		subject, err := VerifyPreviewToken(key, r.URL.Query().Get(PreviewTokenParam), res)
+		if err == ErrPreviewTokenExpired {
+			errorResponder.Respond(w, r, http.StatusForbidden, "this preview link has expired, please request a new one")
+			return
+		}
+		if err != nil {
+			errorResponder.Respond(w, r, http.StatusNotFound, "not found")
+			return
+		}
+		ctx := context.WithValue(r.Context(), previewContextKey{}, PreviewScope{Subject: subject, Resource: res})
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// GetPreviewScope returns the scope granted by PreviewMiddleware, if the request went through it
+func GetPreviewScope(r *http.Request) (PreviewScope, bool) {
+	scope, ok := r.Context().Value(previewContextKey{}).(PreviewScope)
+	return scope, ok
+}
+
+// DenyPreviewMiddleware rejects requests that were authorised by a preview token, for handlers
+// that publish or otherwise change a resource and must never be reachable with one
+func DenyPreviewMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := GetPreviewScope(r); ok || r.URL.Query().Get(PreviewTokenParam) != "" {
+			errorResponder.Respond(w, r, http.StatusForbidden, "you need to verify your email before publishing")
+			return
+		}
+		next(w, r)
+	}
+}
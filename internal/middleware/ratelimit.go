@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"firebase.google.com/go/auth"
+	"github.com/gorilla/sessions"
+)
+
+// RateLimitWindow is the length of the fixed window the rate limits are counted over
+var RateLimitWindow = time.Minute
+
+// RateLimiter counts requests per key in fixed windows. Signed in users are keyed on
+// their user ID and get the authenticated limit, everybody else is keyed on the client
+// IP and gets the anonymous one, so an office full of signed in users sharing an IP
+// isn't throttled like a scraper. A limit of 0 disables limiting for that kind of traffic
+type RateLimiter struct {
+	anonymous     int
+	authenticated int
+
+	mu      sync.Mutex
+	windows map[string]*rateWindow
+	pruned  time.Time
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+func NewRateLimiter(anonymous, authenticated int) *RateLimiter {
+	return &RateLimiter{
+		anonymous:     anonymous,
+		authenticated: authenticated,
+		windows:       make(map[string]*rateWindow),
+	}
+}
+
+// allow records a request for key and reports whether it is within limit, along with
+// the requests left and when the current window resets
+func (l *RateLimiter) allow(key string, limit int) (bool, int, time.Time) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.evictIdle(now)
+	w, ok := l.windows[key]
+	if !ok || now.Sub(w.start) > RateLimitWindow {
+		w = &rateWindow{start: now}
+		l.windows[key] = w
+	}
+	w.count++
+	reset := w.start.Add(RateLimitWindow)
+	if w.count > limit {
+		return false, 0, reset
+	}
+	return true, limit - w.count, reset
+}
+
+// evictIdle drops the keys whose window is over, at most once a window, so the map only
+// holds the clients seen in the last window. l.mu must be held
+func (l *RateLimiter) evictIdle(now time.Time) {
+	if now.Sub(l.pruned) <= RateLimitWindow {
+		return
+	}
+	for k, w := range l.windows {
+		if now.Sub(w.start) > RateLimitWindow {
+			delete(l.windows, k)
+		}
+	}
+	l.pruned = now
+}
+
+// RateLimitMiddleware throttles requests with limiter. Static files under StaticPathPrefixes
+// don't count, a single page loads plenty of them
+func RateLimitMiddleware(limiter *RateLimiter, sessionStore sessions.Store, jwtKey []byte, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isStaticPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key, limit := "ip:"+clientIP(r), limiter.anonymous
+		if userID := rateLimitUserID(r, sessionStore, jwtKey); userID != "" {
+			key, limit = "user:"+userID, limiter.authenticated
+		}
+		if limit <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ok, remaining, reset := limiter.allow(key, limit)
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		if !ok {
+			retryAfter := int(time.Until(reset).Seconds()) + 1
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			LoggerFromContext(r.Context()).Warn().Str("key", key).Msg("rate limited")
+			errorResponder.Respond(w, r, http.StatusTooManyRequests, "too many requests, please slow down")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitUserID returns the signed in user, either from an auth token already in the
+// context or from a valid site jwt in the session. It doesn't verify firebase tokens as
+// that would cost a round trip on every request
+func rateLimitUserID(r *http.Request, sessionStore sessions.Store, jwtKey []byte) string {
+	if tk, ok := r.Context().Value("authToken").(*auth.Token); ok && tk != nil {
+		return tk.UID
+	}
+	claims, err := GetUserFromJWT(r, sessionStore, jwtKey)
+	if err != nil || claims.UserID == "" {
+		return ""
+	}
+	return claims.UserID
+}
+
+// StaticPathPrefixes are the paths static files are served under by http.FileServer
+var StaticPathPrefixes = []string{"/s/", "/scripts/"}
+
+func isStaticPath(p string) bool {
+	for _, prefix := range StaticPathPrefixes {
+		if strings.HasPrefix(p, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/sessions"
+)
+
+// SessionRenewalMiddleware re-issues the site signed jwt stored in the session once less
+// than threshold (e.g. 0.2 for 20%) of its lifetime is left, so active users aren't logged out
+// mid session. The renewed token keeps the lifetime of the original one. Requests without
+// a session jwt, such as machine token requests, are left untouched
+func SessionRenewalMiddleware(sessionStore sessions.Store, jwtKey []byte, threshold float64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-machine-token") == "" && r.Header.Get(MachineSignatureHeader) == "" {
+			if err := migrateLegacySession(w, r, sessionStore); err != nil {
+				LoggerFromContext(r.Context()).Error().Err(err).Msg("unable to migrate legacy session cookie")
+			}
+			if threshold > 0 {
+				if err := renewSessionJWT(w, r, sessionStore, jwtKey, threshold); err != nil {
+					LoggerFromContext(r.Context()).Error().Err(err).Msg("unable to renew session jwt")
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func renewSessionJWT(w http.ResponseWriter, r *http.Request, sessionStore sessions.Store, jwtKey []byte, threshold float64) error {
+	sess, err := GetSession(r, sessionStore)
+	if err != nil || sess.IsNew && len(sess.Values) == 0 {
+		return nil
+	}
+	tk, ok := sess.Values["jwt"].(string)
+	if !ok {
+		return nil
+	}
+	claims, err := parseSessionJWT(tk, jwtKey)
+	// firebase id tokens and expired tokens don't verify with the site key and are not renewed here
+	if err != nil || claims.IssuedAt == 0 || claims.ExpiresAt == 0 {
+		return nil
+	}
+	now := time.Now().UTC()
+	ttl := time.Duration(claims.ExpiresAt-claims.IssuedAt) * time.Second
+	remaining := time.Unix(claims.ExpiresAt, 0).Sub(now)
+	if ttl <= 0 || remaining > time.Duration(float64(ttl)*threshold) {
+		return nil
+	}
+	// a revoked session, e.g. one issued before ForceReauth, must run out rather than be extended
+	if tokenRevoked(r, claims) {
+		return nil
+	}
+	if claims.AuthTime == 0 {
+		claims.AuthTime = claims.IssuedAt
+	}
+	claims.IssuedAt = now.Unix()
+	claims.ExpiresAt = now.Add(ttl).Unix()
+	ss, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtKey)
+	if err != nil {
+		return err
+	}
+	sess.Values["jwt"] = ss
+	return SaveSession(r, w, sess)
+}
@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+type routePatternKey struct{}
+
+// routePattern is filled in by the router, which only sees the request after the
+// outer middlewares have passed it on, so they share a pointer through the context
+type routePattern struct {
+	pattern string
+}
+
+// withRoutePattern makes sure ctx can carry a route pattern set further down the chain
+func withRoutePattern(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(routePatternKey{}).(*routePattern); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, routePatternKey{}, &routePattern{})
+}
+
+// SetRoutePattern records the route template matched for r, e.g. "/job/{slug}". It is a
+// no-op unless r went through LoggingMiddleware or TracingMiddleware
+func SetRoutePattern(r *http.Request, pattern string) {
+	if rp, ok := r.Context().Value(routePatternKey{}).(*routePattern); ok {
+		rp.pattern = pattern
+	}
+}
+
+// RoutePattern returns the route template matched for r, or "" if none was recorded
+func RoutePattern(r *http.Request) string {
+	if rp, ok := r.Context().Value(routePatternKey{}).(*routePattern); ok {
+		return rp.pattern
+	}
+	return ""
+}
+
+// routeOrPath is the matched route template, falling back to the raw path for unmatched requests
+func routeOrPath(r *http.Request) string {
+	if pattern := RoutePattern(r); pattern != "" {
+		return pattern
+	}
+	return r.URL.Path
+}
+
+// MuxRoutePatternMiddleware is a mux.MiddlewareFunc recording the path template of the
+// matched route with SetRoutePattern. Register it with router.Use
+func MuxRoutePatternMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route := mux.CurrentRoute(r); route != nil {
+			if pattern, err := route.GetPathTemplate(); err == nil {
+				SetRoutePattern(r, pattern)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
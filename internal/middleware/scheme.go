@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+)
+
+// SchemeHeaders are the headers the proxy in front of the server sets to the scheme the
+// client used, checked in order. A "Forwarded" entry is parsed for its proto parameter
+var SchemeHeaders = []string{"X-Forwarded-Proto"}
+
+// requestScheme returns "https" or "http" as seen by the client. SchemeHeaders are only
+// believed on requests from TrustedProxies, otherwise the TLS state of the connection
+// decides, so a plain http request without a trusted proxy is always "http"
+func requestScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	if !fromTrustedProxy(r) {
+		return "http"
+	}
+	for _, name := range SchemeHeaders {
+		var proto string
+		if strings.EqualFold(name, "Forwarded") {
+			proto = forwardedProto(r.Header.Get(name))
+		} else {
+			proto = firstHeaderValue(r.Header.Get(name))
+		}
+		if proto == "https" || proto == "http" {
+			return proto
+		}
+	}
+	return "http"
+}
+
+// forwardedProto returns the proto parameter of the first element of an RFC 7239 Forwarded
+// header, e.g. "https" for `for=192.0.2.60;proto=https;by=203.0.113.43, for=198.51.100.17`
+func forwardedProto(v string) string {
+	if i := strings.Index(v, ","); i >= 0 {
+		v = v[:i]
+	}
+	for _, pair := range strings.Split(v, ";") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) == 2 && strings.EqualFold(strings.TrimSpace(kv[0]), "proto") {
+			return strings.ToLower(strings.Trim(strings.TrimSpace(kv[1]), `"`))
+		}
+	}
+	return ""
+}
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/golang-cafe/job-board/internal/servertiming"
+)
+
+// ServerTimingMiddleware sends the durations recorded with servertiming.RecordTiming while
+// serving the request, plus the total time until the response headers, in a Server-Timing
+// header so they show up in the browser devtools
+func ServerTimingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, timings := servertiming.NewContext(r.Context())
+		tw := &timingWriter{ResponseWriter: w, timings: timings, start: time.Now()}
+		next.ServeHTTP(tw, r.WithContext(ctx))
+	})
+}
+
+// timingWriter adds the Server-Timing header right before the headers are written, which is
+// as late as it can be set
+type timingWriter struct {
+	http.ResponseWriter
+	timings     *servertiming.Timings
+	start       time.Time
+	wroteHeader bool
+}
+
+func (w *timingWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.Header().Set("Server-Timing", w.timings.Header(time.Since(w.start)))
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *timingWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,214 @@
+package middleware
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+const DefaultSessionCookieName = "____gc"
+
+// SessionCookieName is the cookie sessions are read from and written to. Set it at startup
+var SessionCookieName = DefaultSessionCookieName
+
+// LegacySessionCookieNames are previous session cookie names still accepted when there
+// is no session under SessionCookieName. Sessions found under them are moved to
+// SessionCookieName on the next request, so the cookie can be renamed without signing
+// everybody out. Drop the old names once sessions created before the rename have expired
+var LegacySessionCookieNames []string
+
+// RememberMeSessionDuration is how long a "remember me" session survives, including
+// across browser restarts. Longer lived cookies widen the window in which a stolen
+// cookie or an unattended shared device can be used, so it is strictly opt-in.
+const RememberMeSessionDuration = 30 * 24 * time.Hour
+
+// BrowserSessionDuration bounds the jwt of sessions whose cookie is dropped when the browser closes.
+// It can be overridden at startup
+var BrowserSessionDuration = 24 * time.Hour
+
+// MaxSessionCookieSize is the smallest per cookie limit browsers enforce, including the
+// name and attributes. Larger cookies are dropped without any error on the client
+const MaxSessionCookieSize = 4096
+
+// sessionCookieWarnSize is where SaveSession starts warning that the cookie is close to the limit
+const sessionCookieWarnSize = MaxSessionCookieSize * 9 / 10
+
+var ErrSessionTooLarge = errors.New("session cookie exceeds the browser size limit, trim the jwt claims or use SESSION_STORE=postgres")
+
+type SessionConfig struct {
+	Name   string // session cookie name, DefaultSessionCookieName when empty
+	Secure bool   // only send the cookie over https
+	// Embedded switches the session cookie to SameSite=None so it survives inside
+	// third party iframes. Browsers reject SameSite=None cookies that are not Secure,
+	// so this only works when the site is served over https.
+	Embedded           bool
+	EmbeddedCookieName string // cookie name used when Embedded is set, so it doesn't clash with the main app session
+}
+
+// CookieName returns the session cookie name for the configured mode
+func (c SessionConfig) CookieName() string {
+	if c.Embedded && c.EmbeddedCookieName != "" {
+		return c.EmbeddedCookieName
+	}
+	if c.Name != "" {
+		return c.Name
+	}
+	return DefaultSessionCookieName
+}
+
+// GetSession returns the session of the request, falling back to the first of
+// LegacySessionCookieNames holding a session when there is none under SessionCookieName.
+// The legacy values are copied so saving the session writes it under the new name
+func GetSession(r *http.Request, sessionStore sessions.Store) (*sessions.Session, error) {
+	sess, err := sessionStore.Get(r, SessionCookieName)
+	if err != nil || !sess.IsNew {
+		return sess, err
+	}
+	if legacy := legacySession(r, sessionStore); legacy != nil {
+		for k, v := range legacy.Values {
+			sess.Values[k] = v
+		}
+	}
+	return sess, nil
+}
+
+func legacySession(r *http.Request, sessionStore sessions.Store) *sessions.Session {
+	for _, name := range LegacySessionCookieNames {
+		if _, err := r.Cookie(name); err != nil {
+			continue
+		}
+		legacy, err := sessionStore.Get(r, name)
+		if err != nil || legacy.IsNew {
+			continue
+		}
+		return legacy
+	}
+	return nil
+}
+
+// migrateLegacySession moves a session found under one of LegacySessionCookieNames to
+// SessionCookieName and expires the legacy cookie
+func migrateLegacySession(w http.ResponseWriter, r *http.Request, sessionStore sessions.Store) error {
+	if len(LegacySessionCookieNames) == 0 {
+		return nil
+	}
+	if _, err := r.Cookie(SessionCookieName); err == nil {
+		return nil
+	}
+	legacy := legacySession(r, sessionStore)
+	if legacy == nil {
+		return nil
+	}
+	sess, err := sessionStore.Get(r, SessionCookieName)
+	if err != nil {
+		return err
+	}
+	for k, v := range legacy.Values {
+		sess.Values[k] = v
+	}
+	if err := SaveSession(r, w, sess); err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{Name: legacy.Name(), Value: "", Path: legacy.Options.Path, Domain: legacy.Options.Domain, MaxAge: -1})
+	return nil
+}
+
+// NewSessionStore returns a cookie store with SameSite=Lax by default, or
+// SameSite=None; Secure when configured for embedded deployments
+func NewSessionStore(key []byte, cfg SessionConfig) *sessions.CookieStore {
+	store := sessions.NewCookieStore(key)
+	store.Options = sessionOptions(cfg)
+	return store
+}
+
+// sessionOptions are the cookie options new sessions start with, for any store
+func sessionOptions(cfg SessionConfig) *sessions.Options {
+	opts := &sessions.Options{
+		Path:     "/",
+		MaxAge:   int(RememberMeSessionDuration.Seconds()),
+		HttpOnly: true,
+		Secure:   cfg.Secure,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if cfg.Embedded {
+		if !cfg.Secure {
+			log.Println("warning: embedded sessions use SameSite=None which requires https, forcing Secure cookies")
+		}
+		opts.Secure = true
+		opts.SameSite = http.SameSiteNoneMode
+	}
+	return opts
+}
+
+// SessionDuration returns how long the session and the jwt stored in it should be valid for
+func SessionDuration(remember bool) time.Duration {
+	if remember {
+		return RememberMeSessionDuration
+	}
+	return BrowserSessionDuration
+}
+
+// sessionKeyRemember holds the remember me choice made at sign in. The stores hand every
+// loaded session their default, long lived, options, so SaveSession re-applies it on each save
+const sessionKeyRemember = "remember"
+
+// SetSessionDuration makes the session cookie long lived when remember is set,
+// otherwise the cookie only lasts until the browser is closed. The choice sticks to the
+// session for as long as it is saved through SaveSession. Use SessionDuration for the jwt
+// expiry so the token and the cookie don't diverge
+func SetSessionDuration(session *sessions.Session, remember bool) {
+	session.Values[sessionKeyRemember] = remember
+	applySessionDuration(session)
+}
+
+// applySessionDuration sets the cookie MaxAge from the remember me choice stored in the
+// session. Sessions without one keep the store default, and deletions are left alone
+func applySessionDuration(session *sessions.Session) {
+	remember, ok := session.Values[sessionKeyRemember].(bool)
+	if !ok || session.Options.MaxAge < 0 {
+		return
+	}
+	if remember {
+		session.Options.MaxAge = int(RememberMeSessionDuration.Seconds())
+		return
+	}
+	session.Options.MaxAge = 0
+}
+
+// SaveSession saves the session like session.Save, keeping the cookie lifetime chosen with
+// SetSessionDuration. For cookie backed sessions it first measures the encoded cookie and
+// returns ErrSessionTooLarge instead of setting a cookie the browser would silently discard
+func SaveSession(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	applySessionDuration(session)
+	if store, ok := session.Store().(*sessions.CookieStore); ok && session.Options.MaxAge >= 0 {
+		size, err := sessionCookieSize(store, session)
+		if err != nil {
+			return err
+		}
+		if size > MaxSessionCookieSize {
+			return ErrSessionTooLarge
+		}
+		if size > sessionCookieWarnSize {
+			LoggerFromContext(r.Context()).Warn().Int("size", size).Msg("session cookie is close to the browser size limit")
+		}
+	}
+	return session.Save(r, w)
+}
+
+// sessionCookieSize returns the length of the Set-Cookie value the store would write for session
+func sessionCookieSize(store *sessions.CookieStore, session *sessions.Session) (int, error) {
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.Values, store.Codecs...)
+	if err != nil {
+		// the codecs refuse values over their own length cap, which is the same failure
+		if strings.Contains(err.Error(), "value is too long") {
+			return 0, ErrSessionTooLarge
+		}
+		return 0, err
+	}
+	return len(sessions.NewCookie(session.Name(), encoded, session.Options).String()), nil
+}
@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+var testJWTKey = []byte("test-jwt-signing-key")
+
+// signedSessionJWT signs claims with testJWTKey like the sign in handlers do
+func signedSessionJWT(t testing.TB, claims UserJWT) string {
+	t.Helper()
+	if claims.ExpiresAt == 0 {
+		claims.ExpiresAt = time.Now().Add(time.Hour).Unix()
+	}
+	ss, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(testJWTKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ss
+}
+
+func TestSaveSession(t *testing.T) {
+	tests := []struct {
+		name       string
+		claims     UserJWT
+		wantErr    error
+		wantCookie bool
+	}{
+		{"regular claims", UserJWT{UserID: "1", Email: "jane@example.com", Type: "developer"}, nil, true},
+		{"oversized claims", UserJWT{UserID: "1", Email: strings.Repeat("a", MaxSessionCookieSize) + "@example.com"}, ErrSessionTooLarge, false},
+	}
+	store := NewSessionStore([]byte("0123456789abcdef0123456789abcdef"), SessionConfig{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			sess, err := GetSession(r, store)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sess.Values["jwt"] = signedSessionJWT(t, tt.claims)
+			w := httptest.NewRecorder()
+			if err := SaveSession(r, w, sess); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("SaveSession() = %v, want %v", err, tt.wantErr)
+			}
+			if gotCookie := w.Header().Get("Set-Cookie") != ""; gotCookie != tt.wantCookie {
+				t.Errorf("cookie set = %v, want %v", gotCookie, tt.wantCookie)
+			}
+		})
+	}
+}
+
+// TestSessionDurationSurvivesRenewal signs in, then has the session jwt renewed on a later
+// request. The renewed cookie must keep the lifetime chosen at sign in
+func TestSessionDurationSurvivesRenewal(t *testing.T) {
+	tests := []struct {
+		name       string
+		remember   bool
+		wantMaxAge bool
+	}{
+		{"browser session", false, false},
+		{"remember me", true, true},
+	}
+	store := NewSessionStore([]byte("0123456789abcdef0123456789abcdef"), SessionConfig{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now()
+			claims := UserJWT{UserID: "1", Email: "jane@example.com"}
+			claims.IssuedAt = now.Add(-23 * time.Hour).Unix()
+			claims.ExpiresAt = now.Add(time.Hour).Unix()
+			r := httptest.NewRequest("GET", "/", nil)
+			sess, err := GetSession(r, store)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sess.Values["jwt"] = signedSessionJWT(t, claims)
+			SetSessionDuration(sess, tt.remember)
+			w := httptest.NewRecorder()
+			if err := SaveSession(r, w, sess); err != nil {
+				t.Fatal(err)
+			}
+
+			r = httptest.NewRequest("GET", "/profile/home", nil)
+			for _, c := range w.Result().Cookies() {
+				r.AddCookie(c)
+			}
+			w = httptest.NewRecorder()
+			SessionRenewalMiddleware(store, testJWTKey, 0.2, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+			cookie := w.Header().Get("Set-Cookie")
+			if cookie == "" {
+				t.Fatal("session jwt was not renewed")
+			}
+			hasMaxAge := strings.Contains(cookie, "Max-Age=") || strings.Contains(cookie, "Expires=")
+			if hasMaxAge != tt.wantMaxAge {
+				t.Errorf("renewed cookie %q has Max-Age/Expires = %v, want %v", cookie, hasMaxAge, tt.wantMaxAge)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/segmentio/ksuid"
+)
+
+// PostgresSessionStore is a sessions.Store keeping session values server side in the
+// sessions table. The cookie only carries the signed session id.
+// Values are stored as JSON so keys must be strings and numbers are read back as float64.
+// The MaxAge of each session is stored along, so browser sessions stay browser sessions
+type PostgresSessionStore struct {
+	db      *sql.DB
+	Codecs  []securecookie.Codec
+	Options *sessions.Options
+}
+
+// NewPostgresSessionStore returns a store whose cookies are set up from cfg like those of
+// NewSessionStore
+func NewPostgresSessionStore(db *sql.DB, cfg SessionConfig, keyPairs ...[]byte) *PostgresSessionStore {
+	return &PostgresSessionStore{
+		db:      db,
+		Codecs:  securecookie.CodecsFromPairs(keyPairs...),
+		Options: sessionOptions(cfg),
+	}
+}
+
+func (s *PostgresSessionStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+func (s *PostgresSessionStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+	c, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	if err := securecookie.DecodeMulti(name, c.Value, &session.ID, s.Codecs...); err != nil {
+		return session, err
+	}
+	err = s.load(r.Context(), session)
+	if err == sql.ErrNoRows {
+		// expired or destroyed server side, start over with a fresh session
+		session.ID = ""
+		return session, nil
+	}
+	if err != nil {
+		return session, err
+	}
+	session.IsNew = false
+	return session, nil
+}
+
+// Save persists the session and sets the session id cookie. A negative MaxAge destroys the session
+func (s *PostgresSessionStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if _, err := s.db.ExecContext(r.Context(), `DELETE FROM sessions WHERE id = $1`, session.ID); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+	if session.ID == "" {
+		id, err := ksuid.NewRandom()
+		if err != nil {
+			return err
+		}
+		session.ID = id.String()
+	}
+	if err := s.save(r.Context(), session); err != nil {
+		return err
+	}
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// DeleteExpiredSessions removes sessions past their expiry and returns how many were deleted
+func (s *PostgresSessionStore) DeleteExpiredSessions(ctx context.Context) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM sessions WHERE expires_at < NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *PostgresSessionStore) load(ctx context.Context, session *sessions.Session) error {
+	var data []byte
+	var maxAge sql.NullInt64
+	row := s.db.QueryRowContext(ctx, `SELECT data, max_age FROM sessions WHERE id = $1 AND expires_at > NOW()`, session.ID)
+	if err := row.Scan(&data, &maxAge); err != nil {
+		return err
+	}
+	if maxAge.Valid {
+		session.Options.MaxAge = int(maxAge.Int64)
+	}
+	values := make(map[string]interface{})
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	for k, v := range values {
+		session.Values[k] = v
+	}
+	return nil
+}
+
+func (s *PostgresSessionStore) save(ctx context.Context, session *sessions.Session) error {
+	values := make(map[string]interface{}, len(session.Values))
+	for k, v := range session.Values {
+		key, ok := k.(string)
+		if !ok {
+			return fmt.Errorf("session value key %v is not a string", k)
+		}
+		values[key] = v
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	ttl := time.Duration(session.Options.MaxAge) * time.Second
+	if ttl == 0 {
+		// browser session cookies don't carry an expiry, bound them server side
+		ttl = BrowserSessionDuration
+	}
+	_, err = s.db.ExecContext(
+		ctx,
+		`INSERT INTO sessions (id, data, max_age, expires_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (id) DO UPDATE SET data = EXCLUDED.data, max_age = EXCLUDED.max_age, expires_at = EXCLUDED.expires_at`,
+		session.ID, data, session.Options.MaxAge, time.Now().Add(ttl))
+	return err
+}
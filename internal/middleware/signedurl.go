@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Query parameters SignURL adds to the links it signs
+const (
+	SignedURLExpiresParam   = "expires"
+	SignedURLSignatureParam = "signature"
+)
+
+var (
+	ErrSignedURLInvalid = errors.New("invalid url signature")
+	ErrSignedURLExpired = errors.New("signed url expired")
+)
+
+// SignURL returns path, which may carry a query string of its own, with an expiry and an HMAC
+// signature over the path, the query and the expiry, so it can be shared, e.g. by email, and
+// checked with VerifySignedURL without any server side state
+func SignURL(path string, expires time.Time, secret []byte) string {
+	u, err := url.Parse(path)
+	if err != nil {
+		return path
+	}
+	q := u.Query()
+	q.Del(SignedURLSignatureParam)
+	q.Set(SignedURLExpiresParam, strconv.FormatInt(expires.Unix(), 10))
+	u.RawQuery = q.Encode()
+	q.Set(SignedURLSignatureParam, signedURLSignature(secret, u.Path, u.RawQuery))
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// VerifySignedURL checks the request URL was produced by SignURL with secret and hasn't expired
+func VerifySignedURL(r *http.Request, secret []byte) (bool, error) {
+	q := r.URL.Query()
+	signature := q.Get(SignedURLSignatureParam)
+	expires, err := strconv.ParseInt(q.Get(SignedURLExpiresParam), 10, 64)
+	if signature == "" || err != nil {
+		return false, ErrSignedURLInvalid
+	}
+	q.Del(SignedURLSignatureParam)
+	if !hmac.Equal([]byte(signature), []byte(signedURLSignature(secret, r.URL.Path, q.Encode()))) {
+		return false, ErrSignedURLInvalid
+	}
+	if time.Now().Unix() > expires {
+		return false, ErrSignedURLExpired
+	}
+	return true, nil
+}
+
+func signedURLSignature(secret []byte, path, rawQuery string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("signed-url\n" + path + "\n" + rawQuery))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// RequireSignedURL responds 403 to requests whose URL wasn't signed with secret or has expired
+func RequireSignedURL(secret []byte, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ok, err := VerifySignedURL(r, secret)
+		if err == ErrSignedURLExpired {
+			errorResponder.Respond(w, r, http.StatusForbidden, "this link has expired")
+			return
+		}
+		if !ok {
+			errorResponder.Respond(w, r, http.StatusForbidden, "this link is not valid")
+			return
+		}
+		next(w, r)
+	}
+}
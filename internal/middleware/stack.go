@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/golang-cafe/job-board/internal/template"
+	"github.com/gorilla/sessions"
+)
+
+// Config holds the settings of the standard middleware chain assembled by BuildStack
+type Config struct {
+	Env string // "dev" turns off the https redirect, the bad bot checks and the production security headers
+	// BlockedPaths are turned away before anything else runs, see BlockPathsMiddleware
+	BlockedPaths []string
+	// Gzip compresses responses for clients that accept it
+	Gzip bool
+	// MaxURILength is the longest request URL served, see MaxURILengthMiddleware. 0 disables
+	MaxURILength int
+	// ServerTiming sends the Server-Timing header, see ServerTimingMiddleware
+	ServerTiming bool
+	// AllowedHosts are the hosts requests are served for, nil serves any host
+	AllowedHosts []string
+	// HTTPS redirects plain http requests to https, see HTTPSMiddleware
+	HTTPS   bool
+	Headers HeadersConfig
+	// RateLimiter throttles requests, nil disables rate limiting. SessionStore and JWTKey
+	// tell signed in users apart from anonymous ones for it
+	RateLimiter  *RateLimiter
+	SessionStore sessions.Store
+	JWTKey       []byte
+	BadBot       BadBotConfig
+	// Healthy reports whether the database is up, see RequireHealthyDatabase. nil skips the check
+	Healthy func() bool
+	// App adds the middlewares of the application, e.g. session renewal, right around the
+	// handler once the request made it through the rest of the chain. nil adds none
+	App func(http.Handler) http.Handler
+	// Template renders the 500 page after a panic, nil serves the built in page
+	Template *template.Template
+}
+
+// BuildStack returns the standard middleware chain configured by cfg. From the outside in:
+// blocked paths, gzip, the url length check, server timing, tracing, logging, which also
+// assigns the X-Request-ID, recover, the host allowlist, https, headers and the CSP nonce,
+// rate limiting, bad bots, trailing slashes, the database health check and finally cfg.App.
+// Cheap rejections come first so probes never reach logging, logging wraps recover so panics
+// are logged with the request id, and bad bots are checked after rate limiting so a flood of
+// them is throttled first. Each middleware can still be used on its own
+func BuildStack(cfg Config) func(http.Handler) http.Handler {
+	onPanic := InternalErrorHandler(cfg.Template)
+	return func(next http.Handler) http.Handler {
+		h := next
+		if cfg.App != nil {
+			h = cfg.App(h)
+		}
+		if cfg.Healthy != nil {
+			h = RequireHealthyDatabase(cfg.Healthy, h)
+		}
+		h = StripTrailingSlashMiddleware(h)
+		h = BadBotMiddleware(h, cfg.Env, cfg.BadBot)
+		if cfg.RateLimiter != nil {
+			h = RateLimitMiddleware(cfg.RateLimiter, cfg.SessionStore, cfg.JWTKey, h)
+		}
+		h = CSPNonceMiddleware(h)
+		h = HeadersMiddleware(h, cfg.Env, cfg.Headers)
+		if cfg.HTTPS {
+			h = HTTPSMiddleware(h, cfg.Env)
+		}
+		if cfg.AllowedHosts != nil {
+			h = HostAllowlistMiddleware(h, cfg.AllowedHosts)
+		}
+		h = RecoverMiddleware(h, onPanic)
+		h = LoggingMiddleware(h)
+		h = TracingMiddleware(h)
+		if cfg.ServerTiming {
+			h = ServerTimingMiddleware(h)
+		}
+		h = MaxURILengthMiddleware(h, cfg.MaxURILength)
+		if cfg.Gzip {
+			h = GzipMiddleware(h)
+		}
+		return BlockPathsMiddleware(h, cfg.BlockedPaths)
+	}
+}
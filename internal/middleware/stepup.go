@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"firebase.google.com/go/auth"
+)
+
+// RequireRecentAuth lets the request through only if the user signed in, i.e. entered
+// their credentials, within maxAge. It must wrap a handler behind one of the user
+// authenticated middlewares as it reads the auth token they put in the context.
+// Page requests with a stale sign in are redirected to the sign in page, which sends
+// the user back to the original url afterwards, other requests get a 401
+func RequireRecentAuth(maxAge time.Duration, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tk, ok := r.Context().Value("authToken").(*auth.Token)
+		if !ok || tk == nil {
+			errorResponder.Respond(w, r, http.StatusUnauthorized, "you need to sign in to access this resource")
+			return
+		}
+		if time.Since(authTime(tk)) <= maxAge {
+			next(w, r)
+			return
+		}
+		if r.Method != http.MethodGet || wantsJSON(r) {
+			errorResponder.Respond(w, r, http.StatusUnauthorized, "please sign in again to continue")
+			return
+		}
+		http.Redirect(w, r, AuthRedirectURL(r, url.Values{"reauth": {"1"}}), http.StatusSeeOther)
+	}
+}
+
+// authTime is when the user last provided credentials, falling back to when the token was issued
+func authTime(tk *auth.Token) time.Time {
+	if tk.AuthTime > 0 {
+		return time.Unix(tk.AuthTime, 0)
+	}
+	return time.Unix(tk.IssuedAt, 0)
+}
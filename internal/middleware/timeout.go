@@ -0,0 +1,20 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/golang-cafe/job-board/internal/database"
+)
+
+// WithQueryTimeout bounds each database query made while serving the request by d,
+// independently of the deadline of the request as a whole, so one slow query fails
+// fast instead of using up the whole request budget. A d of 0 leaves queries unbounded
+func WithQueryTimeout(d time.Duration, next http.Handler) http.Handler {
+	if d <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(database.WithQueryTimeout(r.Context(), d)))
+	})
+}
@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+)
+
+// TimeZoneCookieName is the cookie holding the visitor's IANA time zone, e.g. "Europe/Rome",
+// set client side from Intl.DateTimeFormat().resolvedOptions().timeZone
+const TimeZoneCookieName = "tz"
+
+// TimeZoneFromRequest returns the visitor's time zone from the tz cookie, or "" when it is
+// missing or not a zone known to the server
+func TimeZoneFromRequest(r *http.Request) string {
+	c, err := r.Cookie(TimeZoneCookieName)
+	if err != nil || c.Value == "" || len(c.Value) > 64 {
+		return ""
+	}
+	if _, err := time.LoadLocation(c.Value); err != nil {
+		return ""
+	}
+	return c.Value
+}
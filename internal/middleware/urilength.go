@@ -0,0 +1,18 @@
+package middleware
+
+import "net/http"
+
+// MaxURILengthMiddleware responds 414 to requests whose URL, query string included, is longer than
+// maxLen before they reach logging, tracing or the handlers. A maxLen of 0 or less disables the check
+func MaxURILengthMiddleware(next http.Handler, maxLen int) http.Handler {
+	if maxLen <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.URL.String()) > maxLen {
+			errorResponder.Respond(w, r, http.StatusRequestURITooLong, "the requested URL is too long")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
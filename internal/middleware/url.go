@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// AuthRedirectPath is the sign in page the authenticated page middlewares send signed out
+// users to. It may carry its own query string
+var AuthRedirectPath = "/auth"
+
+// SafeNextPath reports whether next can be followed after signing in: only paths on this
+// site are, never a scheme relative //host or /\host that browsers treat as another site
+func SafeNextPath(next string) bool {
+	return strings.HasPrefix(next, "/") && !strings.HasPrefix(next, "//") && !strings.HasPrefix(next, "/\\")
+}
+
+// AuthRedirectURL is the sign in url for r, with the page being opened as the next param
+// for GET requests so the user comes back to it afterwards. extra is appended to the query
+func AuthRedirectURL(r *http.Request, extra url.Values) string {
+	q := url.Values{}
+	for k, v := range extra {
+		q[k] = v
+	}
+	if next := r.URL.RequestURI(); r.Method == http.MethodGet && SafeNextPath(next) {
+		q.Set("next", next)
+	}
+	if len(q) == 0 {
+		return AuthRedirectPath
+	}
+	sep := "?"
+	if strings.Contains(AuthRedirectPath, "?") {
+		sep = "&"
+	}
+	return AuthRedirectPath + sep + q.Encode()
+}
+
+// RedirectToAuth sends the user to AuthRedirectURL with a 303, browsers don't follow the
+// Location of a 401. API requests, which can't sign in from a redirect, get a plain 401
+func RedirectToAuth(w http.ResponseWriter, r *http.Request) {
+	if wantsJSON(r) {
+		errorResponder.Respond(w, r, http.StatusUnauthorized, "")
+		return
+	}
+	http.Redirect(w, r, AuthRedirectURL(r, nil), http.StatusSeeOther)
+}
+
+// SiteHost is the configured host of the site, AbsoluteURL uses it rather than anything the
+// request says when set. Set it at startup
+var SiteHost string
+
+// AbsoluteURL builds an absolute url for path as seen by the client, taking the scheme
+// from requestScheme and the host from SiteHost. Without SiteHost the host is taken from
+// X-Forwarded-Host when the request comes from one of TrustedProxies, and from Host otherwise
+func AbsoluteURL(r *http.Request, path string) string {
+	scheme := requestScheme(r)
+	host := SiteHost
+	if host == "" {
+		host = r.Host
+		if fwdHost := firstHeaderValue(r.Header.Get("X-Forwarded-Host")); fwdHost != "" && fromTrustedProxy(r) {
+			host = fwdHost
+		}
+	}
+	if path != "" && !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return scheme + "://" + host + path
+}
+
+// firstHeaderValue returns the first entry of a comma separated header appended to by proxies
+func firstHeaderValue(v string) string {
+	if i := strings.Index(v, ","); i >= 0 {
+		v = v[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(v))
+}
@@ -0,0 +1,75 @@
+package middleware
+
+import "strings"
+
+type UAClass int
+
+const (
+	UAClassUnknown UAClass = iota
+	UAClassBrowser
+	UAClassGoodBot
+	UAClassBadBot
+)
+
+func (c UAClass) String() string {
+	switch c {
+	case UAClassBrowser:
+		return "browser"
+	case UAClassGoodBot:
+		return "good-bot"
+	case UAClassBadBot:
+		return "bad-bot"
+	}
+	return "unknown"
+}
+
+// BadBotTokens, GoodBotTokens and BrowserTokens are matched case-insensitively
+// against the User-Agent header, in that order. They can be overridden at startup.
+var (
+	BadBotTokens = []string{
+		"HeadlessChrome",
+		"PhantomJS",
+		"python-requests",
+		"scrapy",
+	}
+	GoodBotTokens = []string{
+		"Googlebot",
+		"Bingbot",
+		"DuckDuckBot",
+		"Slurp",
+		"Applebot",
+		"Twitterbot",
+		"LinkedInBot",
+		"facebookexternalhit",
+	}
+	BrowserTokens = []string{
+		"Mozilla",
+		"Opera",
+	}
+)
+
+func ClassifyUserAgent(ua string) UAClass {
+	if ua == "" {
+		return UAClassUnknown
+	}
+	ua = strings.ToLower(ua)
+	if containsAnyToken(ua, BadBotTokens) {
+		return UAClassBadBot
+	}
+	if containsAnyToken(ua, GoodBotTokens) {
+		return UAClassGoodBot
+	}
+	if containsAnyToken(ua, BrowserTokens) {
+		return UAClassBrowser
+	}
+	return UAClassUnknown
+}
+
+func containsAnyToken(ua string, tokens []string) bool {
+	for _, t := range tokens {
+		if strings.Contains(ua, strings.ToLower(t)) {
+			return true
+		}
+	}
+	return false
+}
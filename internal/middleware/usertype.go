@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"firebase.google.com/go/auth"
+	"github.com/golang-cafe/job-board/internal/user"
+)
+
+type userContextKey struct{}
+
+// UserFromContext returns the user loaded by RequireUserType, if any
+func UserFromContext(ctx context.Context) (*user.User, bool) {
+	u, ok := ctx.Value(userContextKey{}).(*user.User)
+	return u, ok && u != nil
+}
+
+// RequireUserType must be used behind UserAuthenticatedMiddleware. It loads the
+// authenticated user and responds with 403 unless their type is one of types.
+// The loaded user is stored in the request context so handlers don't query it again
+func RequireUserType(repo user.UserStore, next http.HandlerFunc, types ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, ok := UserFromContext(r.Context())
+		if !ok {
+			tk, ok := r.Context().Value("authToken").(*auth.Token)
+			if !ok {
+				errorResponder.Respond(w, r, http.StatusUnauthorized, "")
+				return
+			}
+			var err error
+			u, err = repo.GetUser(r.Context(), tk.UID)
+			if err != nil {
+				LoggerFromContext(r.Context()).Error().Err(err).Msg("unable to load user")
+				errorResponder.Respond(w, r, http.StatusInternalServerError, "")
+				return
+			}
+			if u == nil {
+				authEvent(r, AuthEventRoleDenied).Str("user_id", tk.UID).Msg("auth")
+				errorResponder.Respond(w, r, http.StatusForbidden, "")
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), userContextKey{}, u))
+		}
+		for _, t := range types {
+			if u.Type == t {
+				next(w, r)
+				return
+			}
+		}
+		authEvent(r, AuthEventRoleDenied).Str("user_id", u.ID).Str("user_type", u.Type).Msg("auth")
+		errorResponder.Respond(w, r, http.StatusForbidden, "your account type can't access this resource")
+	}
+}
@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gorilla/sessions"
+)
+
+// sessionKeyViewAsPublic holds the id of the admin who asked to browse as an anonymous
+// visitor. It is only ever written by SetViewAsPublic, behind the admin middleware
+const sessionKeyViewAsPublic = "view_as_public"
+
+// SetViewAsPublic turns browsing as an anonymous visitor on or off for the admin's session.
+// While on, GetUserFromJWT and IsSignedOn treat the session as signed out so pages render as
+// they would for a visitor. Admin only routes still see the admin, so the flag can be turned off
+func SetViewAsPublic(w http.ResponseWriter, r *http.Request, sessionStore sessions.Store, jwtKey []byte, on bool) error {
+	profile, err := GetUserFromJWT(r, sessionStore, jwtKey)
+	if err != nil {
+		return err
+	}
+	if !profile.IsAdmin {
+		return ErrNotAdmin
+	}
+	sess, err := GetSession(r, sessionStore)
+	if err != nil {
+		return err
+	}
+	if on {
+		sess.Values[sessionKeyViewAsPublic] = profile.UserID
+	} else {
+		delete(sess.Values, sessionKeyViewAsPublic)
+	}
+	return SaveSession(r, w, sess)
+}
+
+// IsViewingAsPublic reports whether an admin turned on browsing as an anonymous visitor
+func IsViewingAsPublic(r *http.Request, sessionStore sessions.Store) bool {
+	sess, err := GetSession(r, sessionStore)
+	if err != nil {
+		return false
+	}
+	id, ok := sess.Values[sessionKeyViewAsPublic].(string)
+	return ok && id != ""
+}
+
+// viewingAsPublic reports whether the session of userID should be treated as signed out,
+// which requires the flag to have been set by that same user
+func viewingAsPublic(sess *sessions.Session, userID string) bool {
+	id, _ := sess.Values[sessionKeyViewAsPublic].(string)
+	return id != "" && id == userID
+}
@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SecurityTxt is the content of /.well-known/security.txt as described in RFC 9116
+type SecurityTxt struct {
+	Contacts           []string // mailto: or https: URIs, at least one is required
+	Policy             string   // URL of the vulnerability disclosure policy, optional
+	Expires            time.Time
+	PreferredLanguages []string
+	Canonical          string
+}
+
+func (s SecurityTxt) String() string {
+	var b strings.Builder
+	for _, c := range s.Contacts {
+		fmt.Fprintf(&b, "Contact: %s\n", c)
+	}
+	if s.Policy != "" {
+		fmt.Fprintf(&b, "Policy: %s\n", s.Policy)
+	}
+	fmt.Fprintf(&b, "Expires: %s\n", s.Expires.UTC().Format(time.RFC3339))
+	if len(s.PreferredLanguages) > 0 {
+		fmt.Fprintf(&b, "Preferred-Languages: %s\n", strings.Join(s.PreferredLanguages, ", "))
+	}
+	if s.Canonical != "" {
+		fmt.Fprintf(&b, "Canonical: %s\n", s.Canonical)
+	}
+	return b.String()
+}
+
+// wellKnownContentTypes covers the .well-known documents that have no file extension
+var wellKnownContentTypes = map[string]string{
+	"apple-app-site-association": "application/json",
+	"assetlinks.json":            "application/json",
+	"change-password":            "text/plain; charset=utf-8",
+}
+
+// WellKnownFileServer serves the files directly inside dir under /.well-known/, such as
+// apple-app-site-association, so new entries can be added by deployment without a code change.
+// Subdirectories, hidden files and directory listings are not served
+func WellKnownFileServer(dir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/.well-known/")
+		if dir == "" || name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+			http.NotFound(w, r)
+			return
+		}
+		path := filepath.Join(dir, name)
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			http.NotFound(w, r)
+			return
+		}
+		if ct, ok := wellKnownContentTypes[name]; ok {
+			w.Header().Set("Content-Type", ct)
+		}
+		http.ServeFile(w, r, path)
+	})
+}
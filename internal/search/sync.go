@@ -0,0 +1,130 @@
+package search
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Document is an entry of the external search index. IDs are namespaced by type, e.g. "job:42"
+type Document struct {
+	ID     string                 `json:"id"`
+	Type   string                 `json:"type"`
+	Fields map[string]interface{} `json:"fields"`
+}
+
+// IndexSyncer is told about every change the repositories make to indexed entities.
+// Upsert creates the document or merges Fields into the existing one. Implementations
+// must not block, the calls are made on the write path
+type IndexSyncer interface {
+	Upsert(doc Document)
+	Delete(id string)
+}
+
+// NopSyncer discards every update, it is the default when no search backend is configured
+type NopSyncer struct{}
+
+func (NopSyncer) Upsert(Document) {}
+func (NopSyncer) Delete(string)   {}
+
+// Indexer is the client of the search backend, e.g. OpenSearch or Algolia
+type Indexer interface {
+	Upsert(ctx context.Context, doc Document) error
+	Delete(ctx context.Context, id string) error
+}
+
+type operation struct {
+	doc    Document
+	delete bool
+}
+
+// AsyncSyncer queues updates in a buffered channel and applies them to an Indexer on its own
+// goroutine, retrying failures with exponential backoff. Updates arriving while the buffer is
+// full are dropped and logged rather than blocking the caller
+type AsyncSyncer struct {
+	indexer    Indexer
+	queue      chan operation
+	mu         sync.RWMutex // guards closed, so nothing is sent on the queue once Close closed it
+	closed     bool
+	wg         sync.WaitGroup
+	MaxRetries int
+	Backoff    time.Duration // delay before the first retry, doubled on every attempt
+	Timeout    time.Duration // per attempt
+}
+
+func NewAsyncSyncer(indexer Indexer, bufferSize int) *AsyncSyncer {
+	s := &AsyncSyncer{
+		indexer:    indexer,
+		queue:      make(chan operation, bufferSize),
+		MaxRetries: 5,
+		Backoff:    time.Second,
+		Timeout:    10 * time.Second,
+	}
+	s.wg.Add(1)
+	go s.run()
+	return s
+}
+
+func (s *AsyncSyncer) Upsert(doc Document) {
+	s.enqueue(operation{doc: doc})
+}
+
+func (s *AsyncSyncer) Delete(id string) {
+	s.enqueue(operation{doc: Document{ID: id}, delete: true})
+}
+
+// Close stops accepting updates and waits for the queued ones to be applied. Updates made
+// after Close are dropped and logged. It is safe to call more than once
+func (s *AsyncSyncer) Close() {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+func (s *AsyncSyncer) enqueue(op operation) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		log.Printf("search index syncer is closed, dropping update for %s", op.doc.ID)
+		return
+	}
+	select {
+	case s.queue <- op:
+	default:
+		log.Printf("search index queue is full, dropping update for %s", op.doc.ID)
+	}
+}
+
+func (s *AsyncSyncer) run() {
+	defer s.wg.Done()
+	for op := range s.queue {
+		if err := s.apply(op); err != nil {
+			log.Printf("unable to sync %s to the search index: %v", op.doc.ID, err)
+		}
+	}
+}
+
+func (s *AsyncSyncer) apply(op operation) error {
+	backoff := s.Backoff
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+		var err error
+		if op.delete {
+			err = s.indexer.Delete(ctx, op.doc.ID)
+		} else {
+			err = s.indexer.Upsert(ctx, op.doc)
+		}
+		cancel()
+		if err == nil || attempt >= s.MaxRetries {
+			return err
+		}
+		log.Printf("search index update for %s failed, attempt %d: %v", op.doc.ID, attempt+1, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
@@ -0,0 +1,46 @@
+package search
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+type recordingIndexer struct {
+	mu      sync.Mutex
+	upserts []string
+	deletes []string
+}
+
+func (ix *recordingIndexer) Upsert(ctx context.Context, doc Document) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.upserts = append(ix.upserts, doc.ID)
+	return nil
+}
+
+func (ix *recordingIndexer) Delete(ctx context.Context, id string) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.deletes = append(ix.deletes, id)
+	return nil
+}
+
+func TestAsyncSyncerClose(t *testing.T) {
+	ix := &recordingIndexer{}
+	s := NewAsyncSyncer(ix, 10)
+	s.Upsert(Document{ID: "job:1"})
+	s.Delete("user:1")
+	s.Close()
+	if len(ix.upserts) != 1 || len(ix.deletes) != 1 {
+		t.Fatalf("applied upserts %v and deletes %v before Close returned, want one of each", ix.upserts, ix.deletes)
+	}
+
+	// updates made after Close, e.g. by requests still in flight on shutdown, are dropped
+	s.Upsert(Document{ID: "job:2"})
+	s.Delete("user:2")
+	s.Close()
+	if len(ix.upserts) != 1 || len(ix.deletes) != 1 {
+		t.Errorf("applied upserts %v and deletes %v after Close, want none", ix.upserts, ix.deletes)
+	}
+}
@@ -27,11 +27,13 @@ import (
 	"github.com/dustin/go-humanize"
 	"github.com/golang-cafe/job-board/internal/company"
 	"github.com/golang-cafe/job-board/internal/config"
+	"github.com/golang-cafe/job-board/internal/currency"
 	"github.com/golang-cafe/job-board/internal/database"
 	"github.com/golang-cafe/job-board/internal/developer"
 	"github.com/golang-cafe/job-board/internal/email"
 	"github.com/golang-cafe/job-board/internal/job"
 	"github.com/golang-cafe/job-board/internal/middleware"
+	"github.com/golang-cafe/job-board/internal/servertiming"
 	"github.com/golang-cafe/job-board/internal/template"
 	"github.com/golang-cafe/job-board/internal/user"
 	"github.com/gorilla/mux"
@@ -56,10 +58,11 @@ type Server struct {
 	router         *mux.Router
 	tmpl           *template.Template
 	emailClient    email.Client
-	SessionStore   *sessions.CookieStore
+	SessionStore   sessions.Store
 	bigCache       *bigcache.BigCache
 	emailRe        *regexp.Regexp
 	firebaseClient *auth.Client
+	health         *database.Health
 }
 
 func NewServer(
@@ -68,7 +71,7 @@ func NewServer(
 	r *mux.Router,
 	t *template.Template,
 	emailClient email.Client,
-	sessionStore *sessions.CookieStore,
+	sessionStore sessions.Store,
 
 ) Server {
 	creds := option.WithCredentialsFile(cfg.FirebaseCredentialFile)
@@ -91,10 +94,12 @@ func NewServer(
 		bigCache:       bigCache,
 		emailRe:        regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$"),
 		firebaseClient: c,
+		health:         database.NewHealth(conn),
 	}
 	if err != nil {
 		svr.Log(err, "unable to initialise big cache")
 	}
+	r.Use(middleware.MuxRoutePatternMiddleware)
 
 	return svr
 }
@@ -290,6 +295,45 @@ func (s Server) RenderSalaryForLocation(w http.ResponseWriter, r *http.Request,
 	})
 }
 
+// convertSalaries rewrites the salary range of jobs into the currency code using the
+// stored fx rates. Jobs without a known currency or rate keep their own currency
+func (s Server) convertSalaries(jobRepo *job.Repository, jobs []*job.JobPost, code string) {
+	if len(jobs) == 0 {
+		return
+	}
+	rates, err := database.GetFXRates(s.Conn, code)
+	if err != nil {
+		s.Log(err, fmt.Sprintf("unable to get fx rates for %s", code))
+		return
+	}
+	ids := make([]int, len(jobs))
+	for i, j := range jobs {
+		ids[i] = j.ID
+	}
+	currencies, err := jobRepo.SalaryCurrencyISOByJobIDs(ids)
+	if err != nil {
+		s.Log(err, "unable to get salary currencies for jobs")
+		return
+	}
+	symbol := currency.Symbol(code)
+	for _, j := range jobs {
+		base, ok := currencies[j.ID]
+		if !ok {
+			continue
+		}
+		rate := 1.0
+		if base != code {
+			if rate, ok = rates[base]; !ok {
+				continue
+			}
+		}
+		if base != code {
+			j.OriginalSalaryRange = j.SalaryRange
+		}
+		j.SalaryRange = fmt.Sprintf("%s%s to %s%s", symbol, humanize.Comma(int64(float64(j.SalaryMin)*rate)), symbol, humanize.Comma(int64(float64(j.SalaryMax)*rate)))
+	}
+}
+
 func (s Server) RenderPageForLocationAndTag(w http.ResponseWriter, r *http.Request, jobRepo *job.Repository, data map[string]interface{}, location, tag, page, salary, currency, htmlView string) {
 	var validSalary bool
 	for _, band := range s.GetConfig().AvailableSalaryBands {
@@ -506,6 +550,10 @@ func (s Server) RenderPageForLocationAndTag(w http.ResponseWriter, r *http.Reque
 	if data == nil {
 		data = make(map[string]interface{})
 	}
+	if profile, ok := data["LoggedUser"].(*user.User); ok && profile != nil && profile.PreferredCurrency != "" {
+		s.convertSalaries(jobRepo, jobsForPage, profile.PreferredCurrency)
+		s.convertSalaries(jobRepo, pinnedJobs, profile.PreferredCurrency)
+	}
 	data["Jobs"] = jobsForPage
 	data["PinnedJobs"] = pinnedJobs
 	data["JobsMinusOne"] = len(jobsForPage) - 1
@@ -542,6 +590,9 @@ func (s Server) RenderPageForLocationAndTag(w http.ResponseWriter, r *http.Reque
 	data["NewJobsLastWeek"] = newJobsLastWeek
 	data["NewJobsLastMonth"] = newJobsLastMonth
 	data["EmailSubscribersCount"] = humanize.Comma(int64(emailSubscribersCount))
+	if !isLandingPage {
+		middleware.SetLastSearch(w, r, s.cfg.JwtSigningKey, middleware.LastSearch{Query: tag, Location: location, Salary: salary, Currency: currency})
+	}
 
 	s.Render(r, w, http.StatusOK, htmlView, data)
 }
@@ -1100,6 +1151,11 @@ func (s Server) RenderPostAJobForLocation(w http.ResponseWriter, r *http.Request
 }
 
 func (s Server) Render(r *http.Request, w http.ResponseWriter, status int, htmlView string, data interface{}) error {
+	return s.RenderAs(r, w, status, "text/html; charset=utf-8", htmlView, data)
+}
+
+// RenderAs is Render for views that aren't HTML, the response gets contentType rather than text/html
+func (s Server) RenderAs(r *http.Request, w http.ResponseWriter, status int, contentType, view string, data interface{}) error {
 	dataMap := make(map[string]interface{}, 0)
 	if data != nil {
 		dataMap = data.(map[string]interface{})
@@ -1130,12 +1186,54 @@ func (s Server) Render(r *http.Request, w http.ResponseWriter, status int, htmlV
 	dataMap["DevDirectoryPlan2IDPrice"] = s.GetConfig().DevDirectoryPlanID2Price / 100
 	dataMap["DevDirectoryPlan3IDPrice"] = s.GetConfig().DevDirectoryPlanID3Price / 100
 	dataMap["MonthAndYear"] = time.Now().UTC().Format("January 2006")
+	dataMap["Query"] = r.URL.Query()
+	dataMap["CSPNonce"] = middleware.CSPNonceFromContext(r.Context())
+	dataMap["CaptchaSiteKey"] = s.cfg.CaptchaSiteKey
+	dataMap["CaptchaTurnstile"] = s.cfg.CaptchaVerifyURL == middleware.TurnstileVerifyURL
+	dataMap["IsImpersonating"] = middleware.IsImpersonating(r, s.SessionStore, s.cfg.JwtSigningKey)
+	dataMap["IsViewingAsPublic"] = middleware.IsViewingAsPublic(r, s.SessionStore)
+	dataMap["BaseURL"] = middleware.AbsoluteURL(r, "")
+	dataMap["TimeZone"] = middleware.TimeZoneFromRequest(r)
+	lastSearch, hasLastSearch := middleware.GetLastSearch(r, s.cfg.JwtSigningKey)
+	dataMap["HasLastSearch"] = hasLastSearch
+	dataMap["LastSearch"] = lastSearch
+	if locale := middleware.LocaleFromContext(r.Context()); locale != "" {
+		dataMap["Locale"] = locale
+	}
+
+	tw := &renderTimer{ResponseWriter: w, r: r, start: time.Now()}
+	if contentType == "text/html; charset=utf-8" {
+		// pages are the expensive renders, don't finish them for clients that went away
+		return s.tmpl.RenderContext(r.Context(), tw, status, view, dataMap)
+	}
+	return s.tmpl.RenderAs(tw, status, contentType, view, dataMap)
+}
+
+// renderTimer records the time spent rendering a template up to its headers being written,
+// the point after which it can no longer make it into the Server-Timing header
+type renderTimer struct {
+	http.ResponseWriter
+	r        *http.Request
+	start    time.Time
+	recorded bool
+}
+
+func (w *renderTimer) WriteHeader(status int) {
+	if !w.recorded {
+		w.recorded = true
+		servertiming.RecordTiming(w.r.Context(), "render", time.Since(w.start))
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
 
-	return s.tmpl.Render(w, status, htmlView, dataMap)
+// FlashForm keeps form and its validation errors for the page the user is redirected to next,
+// which reads them back with middleware.PopFormFlash. Redirect after calling it
+func (s Server) FlashForm(w http.ResponseWriter, r *http.Request, form url.Values, errs map[string]string) error {
+	return middleware.SetFormFlash(w, r, s.SessionStore, form, errs)
 }
 
 func (s Server) XML(w http.ResponseWriter, status int, data []byte) {
-	w.Header().Set("Content-Type", "text/xml")
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
 	w.WriteHeader(status)
 	w.Write(data)
 }
@@ -1176,6 +1274,8 @@ func (s Server) Redirect(w http.ResponseWriter, r *http.Request, status int, dst
 func (s Server) Run() error {
 	httpAddr := fmt.Sprintf(":%s", s.cfg.HttpPort)
 	httpsAddr := fmt.Sprintf(":%s", s.cfg.HttpsPort)
+	// both listeners share the chain, and so the rate limiter
+	handler := middleware.BuildStack(s.stackConfig())(s.router)
 
 	if s.cfg.Env == "prod" {
 		log.Println("Running in production with https")
@@ -1194,10 +1294,8 @@ func (s Server) Run() error {
 		}
 
 		server := &http.Server{
-			Addr: httpsAddr,
-			Handler: middleware.GzipMiddleware(
-				middleware.LoggingMiddleware(middleware.HeadersMiddleware(s.router, s.cfg.Env)),
-			),
+			Addr:      httpsAddr,
+			Handler:   handler,
 			TLSConfig: certManager.TLSConfig(),
 		}
 
@@ -1210,12 +1308,92 @@ func (s Server) Run() error {
 	log.Printf("local env http://0.0.0.0%s", httpAddr)
 	httpAddr = fmt.Sprintf("0.0.0.0%s", httpAddr)
 
-	return http.ListenAndServe(
-		httpAddr,
-		middleware.GzipMiddleware(
-			middleware.LoggingMiddleware(middleware.HeadersMiddleware(s.router, s.cfg.Env)),
-		),
-	)
+	return http.ListenAndServe(httpAddr, handler)
+}
+
+// DatabaseHealth tracks whether the database is reachable, repositories report their errors to it
+func (s Server) DatabaseHealth() *database.Health {
+	return s.health
+}
+
+// blockedPaths are the exploit probe paths turned away before logging, BLOCKED_PATHS replaces the defaults
+func (s Server) blockedPaths() []string {
+	if s.cfg.BlockedPaths == nil {
+		return middleware.DefaultBlockedPaths
+	}
+	return s.cfg.BlockedPaths
+}
+
+// stackConfig configures the middleware chain every request goes through, see middleware.BuildStack
+func (s Server) stackConfig() middleware.Config {
+	return middleware.Config{
+		Env:          s.cfg.Env,
+		BlockedPaths: s.blockedPaths(),
+		Gzip:         true,
+		MaxURILength: s.cfg.MaxURILength,
+		ServerTiming: s.cfg.ServerTiming, // exposes backend timings so it is off unless configured
+		AllowedHosts: s.allowedHosts(),
+		Headers:      s.headersConfig(),
+		RateLimiter:  middleware.NewRateLimiter(s.cfg.RateLimitAnonymous, s.cfg.RateLimitAuthenticated),
+		SessionStore: s.SessionStore,
+		JWTKey:       s.cfg.JwtSigningKey,
+		BadBot:       s.badBotConfig(),
+		Healthy:      s.health.Healthy,
+		App: func(next http.Handler) http.Handler {
+			// identity goes inside session renewal so it verifies the renewed jwt
+			identity := middleware.IdentityMiddleware(s.SessionStore, s.cfg.JwtSigningKey, s.betaGate(middleware.WithQueryTimeout(s.cfg.DatabaseQueryTimeout, middleware.LocaleMiddleware(next, s.cfg.Locales, s.cfg.DefaultLocale))).ServeHTTP)
+			return s.sessionRenewal(identity)
+		},
+		Template: s.tmpl,
+	}
+}
+
+// allowedHosts are the Host headers the site answers to
+func (s Server) allowedHosts() []string {
+	hosts := append([]string{s.cfg.SiteHost, "www." + s.cfg.SiteHost}, s.cfg.AllowedHosts...)
+	if s.cfg.Env == "dev" {
+		hosts = append(hosts, "localhost", "127.0.0.1", "0.0.0.0")
+	}
+	return hosts
+}
+
+func (s Server) headersConfig() middleware.HeadersConfig {
+	return middleware.HeadersConfig{
+		ReferrerPolicy:        s.cfg.ReferrerPolicy,
+		PermissionsPolicy:     s.cfg.PermissionsPolicy,
+		NoIndexPaths:          s.cfg.NoIndexPaths,
+		HSTSMaxAge:            s.cfg.HSTSMaxAge,
+		HSTSIncludeSubDomains: s.cfg.HSTSIncludeSubDomains,
+		HSTSPreload:           s.cfg.HSTSPreload,
+	}
+}
+
+func (s Server) badBotConfig() middleware.BadBotConfig {
+	return middleware.BadBotConfig{
+		Mode:       s.cfg.BadBotMode,
+		Delay:      s.cfg.BadBotDelay,
+		MaxTarpits: s.cfg.BadBotMaxTarpits,
+	}
+}
+
+// betaGate shows the coming soon page to everyone but the emails on BETA_ALLOWLIST and the
+// admin, while the allowlist is set
+func (s Server) betaGate(next http.Handler) http.Handler {
+	if len(s.cfg.BetaAllowlist) == 0 {
+		return middleware.BetaGateMiddleware(next, nil, s.SessionStore, s.GetAuthClient(), s.cfg.JwtSigningKey)
+	}
+	allowed := make(map[string]bool, len(s.cfg.BetaAllowlist)+1)
+	for _, e := range s.cfg.BetaAllowlist {
+		allowed[e] = true
+	}
+	allowed[strings.ToLower(s.cfg.AdminEmail)] = true
+	return middleware.BetaGateMiddleware(next, func(email string) bool {
+		return allowed[email]
+	}, s.SessionStore, s.GetAuthClient(), s.cfg.JwtSigningKey)
+}
+
+func (s Server) sessionRenewal(next http.Handler) http.Handler {
+	return middleware.SessionRenewalMiddleware(s.SessionStore, s.cfg.JwtSigningKey, s.cfg.SessionRenewalThreshold, next)
 }
 
 func (s Server) GetJWTSigningKey() []byte {
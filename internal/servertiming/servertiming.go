@@ -0,0 +1,76 @@
+// Package servertiming collects the durations spent in the parts of a request, such as
+// database queries or template rendering, for the Server-Timing response header
+package servertiming
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+type timingsKey struct{}
+
+type entry struct {
+	name  string
+	dur   time.Duration
+	count int
+}
+
+// Timings is the set of durations recorded for one request. Durations recorded under the
+// same name add up, e.g. every query of a request is reported as a single db entry
+type Timings struct {
+	mu      sync.Mutex
+	entries []*entry
+}
+
+// NewContext returns a copy of ctx that RecordTiming records into, and the Timings it records to
+func NewContext(ctx context.Context) (context.Context, *Timings) {
+	t := &Timings{}
+	return context.WithValue(ctx, timingsKey{}, t), t
+}
+
+// RecordTiming adds d to the name entry of the request ctx belongs to. It is a no-op when
+// ctx doesn't come from a request served with server timing enabled
+func RecordTiming(ctx context.Context, name string, d time.Duration) {
+	t, ok := ctx.Value(timingsKey{}).(*Timings)
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, e := range t.entries {
+		if e.name == name {
+			e.dur += d
+			e.count++
+			return
+		}
+	}
+	t.entries = append(t.entries, &entry{name: name, dur: d, count: 1})
+}
+
+// Since records the time elapsed since start, meant to be deferred
+func Since(ctx context.Context, name string, start time.Time) {
+	RecordTiming(ctx, name, time.Since(start))
+}
+
+// Header formats the recorded entries followed by total as a Server-Timing header value
+func (t *Timings) Header(total time.Duration) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	metrics := make([]string, 0, len(t.entries)+1)
+	for _, e := range t.entries {
+		metric := fmt.Sprintf("%s;dur=%s", e.name, milliseconds(e.dur))
+		if e.count > 1 {
+			metric += fmt.Sprintf(`;desc="%d calls"`, e.count)
+		}
+		metrics = append(metrics, metric)
+	}
+	metrics = append(metrics, "total;dur="+milliseconds(total))
+	return strings.Join(metrics, ", ")
+}
+
+func milliseconds(d time.Duration) string {
+	return fmt.Sprintf("%.1f", float64(d)/float64(time.Millisecond))
+}
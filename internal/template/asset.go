@@ -0,0 +1,64 @@
+package template
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"path"
+	"sync"
+)
+
+// assetHashLength is how many hex characters of the sha256 go into ?v=, plenty to tell deploys apart
+const assetHashLength = 10
+
+var (
+	assetHashes   = map[string]string{}
+	assetHashesMu sync.RWMutex
+)
+
+// LoadAssetHashes hashes the content of every file in fsys, e.g. os.DirFS("./static/assets"),
+// and caches it under urlPrefix followed by the file path, the url the file is served at.
+// A missing root is not an error, assets under it just get no version
+func LoadAssetHashes(fsys fs.FS, urlPrefix string) error {
+	hashes := map[string]string{}
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		b, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(b)
+		hashes[path.Join("/", urlPrefix, p)] = hex.EncodeToString(sum[:])[:assetHashLength]
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) && len(hashes) == 0 {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	assetHashesMu.Lock()
+	defer assetHashesMu.Unlock()
+	for p, h := range hashes {
+		assetHashes[p] = h
+	}
+	return nil
+}
+
+// assetURL appends the content hash of the asset at p as ?v=, so browsers can cache it for
+// long and still fetch the new content after a deploy. Unknown assets are returned as is
+func assetURL(p string) string {
+	assetHashesMu.RLock()
+	h, ok := assetHashes[p]
+	assetHashesMu.RUnlock()
+	if !ok {
+		return p
+	}
+	return p + "?v=" + h
+}
@@ -0,0 +1,166 @@
+package template
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+	"github.com/aymerick/douceur/css"
+	"github.com/aymerick/douceur/parser"
+	"golang.org/x/net/html"
+)
+
+// RenderEmail renders the named template for an email. Most email clients ignore <style>
+// blocks, so their rules are copied onto the style attribute of the elements they match,
+// inline styles keep precedence. Rules that can't be inlined, like @media queries or :hover,
+// are left in a <style> block for the clients that do support it. text is a plaintext
+// rendering of the same content, for the text/plain part of the message
+func (t *Template) RenderEmail(name string, data interface{}) (htmlBody string, text string, err error) {
+	var buf bytes.Buffer
+	if err := t.current().ExecuteTemplate(&buf, name, data); err != nil {
+		return "", "", err
+	}
+	doc, err := goquery.NewDocumentFromReader(&buf)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to parse email %s: %w", name, err)
+	}
+	if err := inlineCSS(doc); err != nil {
+		return "", "", fmt.Errorf("unable to inline css of email %s: %w", name, err)
+	}
+	htmlBody, err = goquery.OuterHtml(doc.Selection)
+	if err != nil {
+		return "", "", err
+	}
+	return htmlBody, emailText(doc), nil
+}
+
+func inlineCSS(doc *goquery.Document) error {
+	var (
+		nodes    []*html.Node
+		inlined  = make(map[*html.Node][]string)
+		original = make(map[*html.Node]string)
+	)
+	var err error
+	doc.Find("style").EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		var sheet *css.Stylesheet
+		sheet, err = parser.Parse(s.Text())
+		if err != nil {
+			return false
+		}
+		var kept []string
+		for _, rule := range sheet.Rules {
+			if rule.Kind != css.QualifiedRule {
+				kept = append(kept, rule.String())
+				continue
+			}
+			declarations := make([]string, 0, len(rule.Declarations))
+			for _, d := range rule.Declarations {
+				declarations = append(declarations, d.String())
+			}
+			var unmatched []string
+			for _, selector := range rule.Selectors {
+				sel, err := cascadia.Compile(selector)
+				if err != nil {
+					unmatched = append(unmatched, selector)
+					continue
+				}
+				doc.FindMatcher(sel).Each(func(_ int, el *goquery.Selection) {
+					node := el.Get(0)
+					if _, ok := inlined[node]; !ok {
+						nodes = append(nodes, node)
+						original[node], _ = el.Attr("style")
+					}
+					inlined[node] = append(inlined[node], declarations...)
+				})
+			}
+			if len(unmatched) > 0 {
+				kept = append(kept, strings.Join(unmatched, ", ")+" { "+strings.Join(declarations, " ")+" }")
+			}
+		}
+		if len(kept) == 0 {
+			s.Remove()
+		} else {
+			s.SetText(strings.Join(kept, "\n"))
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	// rules are applied in document order and ignore specificity, keep them simple
+	for _, node := range nodes {
+		style := strings.Join(inlined[node], " ")
+		if o := strings.TrimSpace(original[node]); o != "" {
+			if !strings.HasSuffix(o, ";") {
+				o += ";"
+			}
+			style += " " + o
+		}
+		goquery.NewDocumentFromNode(node).SetAttr("style", style)
+	}
+	return nil
+}
+
+var (
+	emailTextSpaces   = regexp.MustCompile(`[ \t]+`)
+	emailTextNewlines = regexp.MustCompile(`\n{3,}`)
+)
+
+var emailTextBlocks = map[string]bool{
+	"p": true, "div": true, "table": true, "tr": true, "h1": true, "h2": true, "h3": true,
+	"h4": true, "h5": true, "h6": true, "ul": true, "ol": true, "blockquote": true,
+	"hr": true, "pre": true, "section": true, "header": true, "footer": true,
+}
+
+// emailText renders doc as plaintext: block elements end up on their own lines and links
+// are followed by their url, which would otherwise be lost
+func emailText(doc *goquery.Document) string {
+	var b strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			b.WriteString(strings.ReplaceAll(n.Data, "\n", " "))
+			return
+		case html.ElementNode:
+			switch n.Data {
+			case "head", "script", "style", "title":
+				return
+			case "br":
+				b.WriteString("\n")
+				return
+			}
+		}
+		block := n.Type == html.ElementNode && emailTextBlocks[n.Data]
+		if block {
+			b.WriteString("\n")
+		}
+		if n.Type == html.ElementNode && n.Data == "li" {
+			b.WriteString("\n- ")
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+		if n.Type == html.ElementNode && n.Data == "a" {
+			href := goquery.NewDocumentFromNode(n).AttrOr("href", "")
+			label := strings.TrimSpace(goquery.NewDocumentFromNode(n).Text())
+			if href != "" && href != label && !strings.HasPrefix(href, "#") {
+				b.WriteString(" (" + href + ")")
+			}
+		}
+		if block {
+			b.WriteString("\n")
+		}
+	}
+	for _, n := range doc.Nodes {
+		walk(n)
+	}
+	lines := strings.Split(b.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(emailTextSpaces.ReplaceAllString(line, " "))
+	}
+	return strings.TrimSpace(emailTextNewlines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
@@ -0,0 +1,110 @@
+package template
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"golang.org/x/text/language"
+)
+
+// relTimeLocale translates humanize.Time for one language. past and future wrap the
+// quantity, e.g. "hace %s" and "dentro de %s", units holds the singular and plural
+// of second, minute, hour, day, week, month and year
+type relTimeLocale struct {
+	now        string
+	past       string
+	future     string
+	longWhile  string
+	magnitudes []humanize.RelTimeMagnitude
+}
+
+func newRelTimeLocale(now, past, future, longWhile string, units [7][2]string) relTimeLocale {
+	const (
+		second = iota
+		minute
+		hour
+		day
+		week
+		month
+		year
+	)
+	one := func(u int) string { return "1 " + units[u][0] }
+	many := func(u int) string { return "%d " + units[u][1] }
+	return relTimeLocale{
+		now:       now,
+		past:      past,
+		future:    future,
+		longWhile: longWhile,
+		magnitudes: []humanize.RelTimeMagnitude{
+			{D: 2 * time.Second, Format: one(second), DivBy: 1},
+			{D: time.Minute, Format: many(second), DivBy: time.Second},
+			{D: 2 * time.Minute, Format: one(minute), DivBy: 1},
+			{D: time.Hour, Format: many(minute), DivBy: time.Minute},
+			{D: 2 * time.Hour, Format: one(hour), DivBy: 1},
+			{D: humanize.Day, Format: many(hour), DivBy: time.Hour},
+			{D: 2 * humanize.Day, Format: one(day), DivBy: 1},
+			{D: humanize.Week, Format: many(day), DivBy: humanize.Day},
+			{D: 2 * humanize.Week, Format: one(week), DivBy: 1},
+			{D: humanize.Month, Format: many(week), DivBy: humanize.Week},
+			{D: 2 * humanize.Month, Format: one(month), DivBy: 1},
+			{D: humanize.Year, Format: many(month), DivBy: humanize.Month},
+			{D: 18 * humanize.Month, Format: one(year), DivBy: 1},
+			{D: humanize.LongTime, Format: many(year), DivBy: humanize.Year},
+			{D: math.MaxInt64, Format: longWhile, DivBy: 1},
+		},
+	}
+}
+
+// relTimeLocales are keyed by base language, English is handled by humanize itself
+var relTimeLocales = map[string]relTimeLocale{
+	"de": newRelTimeLocale("jetzt", "vor %s", "in %s", "langer Zeit", [7][2]string{
+		{"Sekunde", "Sekunden"}, {"Minute", "Minuten"}, {"Stunde", "Stunden"}, {"Tag", "Tagen"},
+		{"Woche", "Wochen"}, {"Monat", "Monaten"}, {"Jahr", "Jahren"},
+	}),
+	"es": newRelTimeLocale("ahora", "hace %s", "dentro de %s", "mucho tiempo", [7][2]string{
+		{"segundo", "segundos"}, {"minuto", "minutos"}, {"hora", "horas"}, {"día", "días"},
+		{"semana", "semanas"}, {"mes", "meses"}, {"año", "años"},
+	}),
+	"fr": newRelTimeLocale("maintenant", "il y a %s", "dans %s", "longtemps", [7][2]string{
+		{"seconde", "secondes"}, {"minute", "minutes"}, {"heure", "heures"}, {"jour", "jours"},
+		{"semaine", "semaines"}, {"mois", "mois"}, {"an", "ans"},
+	}),
+	"it": newRelTimeLocale("adesso", "%s fa", "tra %s", "molto tempo", [7][2]string{
+		{"secondo", "secondi"}, {"minuto", "minuti"}, {"ora", "ore"}, {"giorno", "giorni"},
+		{"settimana", "settimane"}, {"mese", "mesi"}, {"anno", "anni"},
+	}),
+	"pt": newRelTimeLocale("agora", "há %s", "daqui a %s", "muito tempo", [7][2]string{
+		{"segundo", "segundos"}, {"minuto", "minutos"}, {"hora", "horas"}, {"dia", "dias"},
+		{"semana", "semanas"}, {"mês", "meses"}, {"ano", "anos"},
+	}),
+}
+
+// localHumantime is humantime in the language of the visitor's locale, e.g. "hace 3 días".
+// Languages without a translation fall back to English
+func localHumantime(data map[string]interface{}, t time.Time) string {
+	locale, _ := data["Locale"].(string)
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return humanize.Time(t)
+	}
+	base, _ := tag.Base()
+	loc, ok := relTimeLocales[base.String()]
+	if !ok {
+		return humanize.Time(t)
+	}
+	now := time.Now()
+	diff := now.Sub(t)
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff < time.Second {
+		return loc.now
+	}
+	phrase := humanize.CustomRelTime(t, now, "", "", loc.magnitudes)
+	if t.After(now) {
+		return fmt.Sprintf(loc.future, phrase)
+	}
+	return fmt.Sprintf(loc.past, phrase)
+}
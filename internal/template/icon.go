@@ -0,0 +1,60 @@
+package template
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+
+	stdtemplate "html/template"
+)
+
+var (
+	icons   = map[string]stdtemplate.HTML{}
+	iconsMu sync.RWMutex
+)
+
+// RegisterIcon makes svg available to templates as {{ icon "name" }}. The markup is
+// rendered as is, so it must come from a trusted source
+func RegisterIcon(name, svg string) error {
+	svg = strings.TrimSpace(svg)
+	if !strings.HasPrefix(svg, "<svg") {
+		return fmt.Errorf("icon %s is not an svg element", name)
+	}
+	iconsMu.Lock()
+	defer iconsMu.Unlock()
+	icons[name] = stdtemplate.HTML(svg)
+	return nil
+}
+
+// LoadIcons registers every .svg file in the root of fsys, e.g. os.DirFS("./static/icons")
+// or an embed.FS, under its file name without the extension
+func LoadIcons(fsys fs.FS) error {
+	matches, err := fs.Glob(fsys, "*.svg")
+	if err != nil {
+		return err
+	}
+	for _, m := range matches {
+		b, err := fs.ReadFile(fsys, m)
+		if err != nil {
+			return err
+		}
+		if err := RegisterIcon(strings.TrimSuffix(path.Base(m), ".svg"), string(b)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// icon returns the markup of the registered icon, or an empty placeholder of the same
+// size for unknown names so a missing icon doesn't break the layout
+func icon(name string) stdtemplate.HTML {
+	iconsMu.RLock()
+	svg, ok := icons[name]
+	iconsMu.RUnlock()
+	if !ok {
+		return stdtemplate.HTML(fmt.Sprintf(`<span class="icon icon-missing" data-icon="%s" style="display:inline-block;width:1em;height:1em;"></span>`, stdtemplate.HTMLEscapeString(name)))
+	}
+	return svg
+}
@@ -0,0 +1,69 @@
+package template
+
+import (
+	humanize "github.com/dustin/go-humanize"
+	"golang.org/x/text/currency"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/number"
+)
+
+// localePrinter returns a printer for the locale stored under "Locale" in the render data,
+// or false when there is none or it doesn't parse
+func localePrinter(data map[string]interface{}) (*message.Printer, bool) {
+	locale, _ := data["Locale"].(string)
+	if locale == "" {
+		return nil, false
+	}
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return nil, false
+	}
+	return message.NewPrinter(tag), true
+}
+
+// localNumber formats n with the thousands and decimal separators of the visitor's
+// locale, e.g. "1.000,5" for German visitors. Without a locale it behaves like humannumber
+func localNumber(data map[string]interface{}, n interface{}) string {
+	p, ok := localePrinter(data)
+	if !ok {
+		switch n.(type) {
+		case float32, float64:
+			return humanize.Commaf(toFloat(n))
+		}
+		return humanize.Comma(int64(toFloat(n)))
+	}
+	return p.Sprint(number.Decimal(n))
+}
+
+// localCurrency formats amount in the ISO 4217 currency code using the visitor's locale.
+// Unknown currencies and requests without a locale fall back to the currency symbol
+// followed by the US grouped amount
+func localCurrency(data map[string]interface{}, amount interface{}, code string) string {
+	unit, err := currency.ParseISO(code)
+	p, ok := localePrinter(data)
+	if err != nil || !ok {
+		return currencySymbol(code) + localNumber(nil, amount)
+	}
+	return p.Sprint(currency.Symbol(unit.Amount(amount)))
+}
+
+func toFloat(n interface{}) float64 {
+	switch v := n.(type) {
+	case int:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return 0
+}
@@ -0,0 +1,40 @@
+package template
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	stdtemplate "html/template"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+const (
+	minQRCodeSize = 64
+	maxQRCodeSize = 1024
+	// maxQRCodeData is about the most a medium recovery QR code can hold, anything longer is no URL
+	maxQRCodeData = 2048
+)
+
+// qrCode renders data, usually a job URL, as an <img> holding a PNG QR code of size by size pixels
+// inlined as a data URI, so printed material can link to a page without an external service.
+// size is clamped between 64 and 1024 pixels. Empty or overly long data renders nothing
+func qrCode(data string, size int) stdtemplate.HTML {
+	if data == "" || len(data) > maxQRCodeData {
+		return ""
+	}
+	if size < minQRCodeSize {
+		size = minQRCodeSize
+	}
+	if size > maxQRCodeSize {
+		size = maxQRCodeSize
+	}
+	png, err := qrcode.Encode(data, qrcode.Medium, size)
+	if err != nil {
+		return ""
+	}
+	return stdtemplate.HTML(fmt.Sprintf(
+		`<img class="qr-code" src="data:image/png;base64,%s" width="%d" height="%d" alt="QR code for %s">`,
+		base64.StdEncoding.EncodeToString(png), size, size, stdtemplate.HTMLEscapeString(data),
+	))
+}
@@ -1,9 +1,22 @@
 package template
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
 	"log"
+	"math"
 	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	stdtemplate "html/template"
@@ -11,16 +24,23 @@ import (
 	customtemplate "github.com/alecthomas/template"
 	humanize "github.com/dustin/go-humanize"
 	"github.com/fsnotify/fsnotify"
+	"github.com/golang-cafe/job-board/internal/currency"
+	"github.com/tdewolff/minify/v2"
+	"github.com/tdewolff/minify/v2/html"
 	blackfriday "gopkg.in/russross/blackfriday.v2"
 )
 
 type Template struct {
+	mu        sync.RWMutex // guards templates, swapped by the dev reloader
 	templates *customtemplate.Template
 	funcMap   stdtemplate.FuncMap
 	watcher   *fsnotify.Watcher
+	minifier  *minify.M
 }
 
-func NewTemplate(env string) *Template {
+// NewTemplate parses the views. With minifyHTML set rendered pages have insignificant
+// whitespace collapsed, leaving <pre>, <textarea>, <script> and <style> content untouched
+func NewTemplate(env string, minifyHTML bool) *Template {
 	funcMap := customtemplate.FuncMap{
 		"add": func(a, b int) int {
 			return a + b
@@ -46,6 +66,9 @@ func NewTemplate(env string) *Template {
 			return t.After(time.Now())
 		},
 		"truncateName": func(s string) string {
+			if s == "" {
+				return ""
+			}
 			parts := strings.Split(s, " ")
 			return parts[0]
 		},
@@ -58,34 +81,63 @@ func NewTemplate(env string) *Template {
 		"mul": func(a int, b int) int {
 			return a * b
 		},
-		"currencysymbol": func(currency string) string {
-			symbols := map[string]string{
-				"USD": "$",
-				"EUR": "€",
-				"JPY": "¥",
-				"GBP": "£",
-				"AUD": "A$",
-				"CAD": "C$",
-				"CHF": "Fr",
-				"CNY": "元",
-				"HKD": "HK$",
-				"NZD": "NZ$",
-				"SEK": "kr",
-				"KRW": "₩",
-				"SGD": "S$",
-				"NOK": "kr",
-				"MXN": "MX$",
-				"INR": "₹",
-				"RUB": "₽",
-				"ZAR": "R",
-				"TRY": "₺",
-				"BRL": "R$",
+		"currencysymbol":      currencySymbol,
+		"salaryRange":         salaryRange,
+		"salaryWithPeriod":    salaryWithPeriod,
+		"salaryConvertedNote": salaryConvertedNote,
+		"default":             defaultValue,
+		"coalesce":            coalesce,
+		"paginate":            paginate,
+		"setQuery":            setQuery,
+		"hasQuery":            hasQuery,
+		"toggleQuery":         toggleQuery,
+		"inQuery":             hasQuery,
+		"joinNatural":         joinNatural,
+		"join":                strings.Join,
+		"timeTag":             timeTag,
+		"maskEmail":           maskEmail,
+		"highlight":           highlight,
+		"fieldValue":          fieldValue,
+		"fieldError":          fieldError,
+		"contrastColor":       contrastColor,
+		"userColor":           userColor,
+		"applicantBadge":      applicantBadge,
+		"userPaletteColor":    userPaletteColor,
+		"supportedCurrencies": currency.Supported,
+		"localNumber":         localNumber,
+		"localCurrency":       localCurrency,
+		"localHumantime":      localHumantime,
+		"inZone":              inZone,
+		"localDate":           localDate,
+		"localTimeTag":        localTimeTag,
+		"icon":                icon,
+		"assetURL":            assetURL,
+		"pageTitle":           pageTitle,
+		"srcset":              srcset,
+		"sizes":               sizes,
+		"qrCode":              qrCode,
+		"jsArgs":              jsArgs,
+		"hasFlag": func(flags map[string]bool, name string) bool {
+			return flags[name]
+		},
+		"isBookmarked": func(bookmarkedIDs []string, jobID string) bool {
+			for _, id := range bookmarkedIDs {
+				if id == jobID {
+					return true
+				}
 			}
-			symbol, ok := symbols[currency]
-			if !ok {
-				return "$"
+			return false
+		},
+		"cspNonce": func(data map[string]interface{}) string {
+			nonce, _ := data["CSPNonce"].(string)
+			return nonce
+		},
+		"absoluteURL": func(data map[string]interface{}, path string) string {
+			baseURL, _ := data["BaseURL"].(string)
+			if path != "" && !strings.HasPrefix(path, "/") {
+				path = "/" + path
 			}
-			return symbol
+			return baseURL + path
 		},
 	}
 
@@ -93,6 +145,16 @@ func NewTemplate(env string) *Template {
 		templates: createTemplateFromGlob(funcMap, "static/views/*.html"),
 		funcMap:   stdtemplate.FuncMap(funcMap),
 	}
+	if minifyHTML {
+		t.minifier = minify.New()
+		// no js or css minifiers are registered so inline scripts and styles are copied as is
+		t.minifier.Add("text/html", &html.Minifier{
+			KeepDocumentTags: true,
+			KeepEndTags:      true,
+			KeepQuotes:       true,
+			KeepComments:     false,
+		})
+	}
 
 	if env != "dev" {
 		return t
@@ -104,18 +166,37 @@ func NewTemplate(env string) *Template {
 	}
 	// Purposefully not closing watcher. We want to watch for the duration of the programs life.
 
-	// Start listening for events.
+	// Start listening for events. Editors often save in several writes, and tools touch several
+	// files at once, so reloading waits for ReloadDebounce of quiet. A template that fails to
+	// parse, e.g. a half written file, is logged and the previous templates are kept
 	go func() {
+		debounce := time.NewTimer(ReloadDebounce)
+		debounce.Stop()
 		for {
 			select {
 			case event, ok := <-watcher.Events:
 				if !ok {
 					return
 				}
-				if event.Has(fsnotify.Write) {
-					log.Printf("modified file %s, reloading templates", event.Name)
-					t.templates = createTemplateFromGlob(funcMap, "static/views/*.html")
+				if event.Has(fsnotify.Write) || event.Has(fsnotify.Create) || event.Has(fsnotify.Rename) || event.Has(fsnotify.Remove) {
+					if !debounce.Stop() {
+						select {
+						case <-debounce.C:
+						default:
+						}
+					}
+					debounce.Reset(ReloadDebounce)
+				}
+			case <-debounce.C:
+				templates, err := parseTemplates(funcMap, "static/views/*.html")
+				if err != nil {
+					log.Printf("unable to reload templates, keeping the previous ones: %v", err)
+					continue
 				}
+				log.Println("views changed, reloaded templates")
+				t.mu.Lock()
+				t.templates = templates
+				t.mu.Unlock()
 			case err, ok := <-watcher.Errors:
 				if !ok {
 					return
@@ -133,8 +214,447 @@ func NewTemplate(env string) *Template {
 	return t
 }
 
+// currencySymbol is currency.Symbol, under a name that doesn't clash with golang.org/x/text/currency
+func currencySymbol(code string) string {
+	return currency.Symbol(code)
+}
+
+// salaryRange formats a compensation range as "$80k – $120k", collapsing to a
+// single figure when both bounds are equal or one of them is missing
+func salaryRange(min, max int, currency string) string {
+	symbol := currencySymbol(currency)
+	switch {
+	case min <= 0 && max <= 0:
+		return ""
+	case min <= 0 || min == max:
+		return symbol + abbreviateAmount(max)
+	case max <= 0:
+		return symbol + abbreviateAmount(min)
+	}
+	return symbol + abbreviateAmount(min) + " – " + symbol + abbreviateAmount(max)
+}
+
+var salaryPeriodSuffixes = map[string]string{
+	"":      "/yr",
+	"year":  "/yr",
+	"month": "/mo",
+	"hour":  "/hr",
+}
+
+// salaryWithPeriod formats amount like salaryRange does and appends the pay period,
+// e.g. "$45/hr". An empty period is treated as yearly, unknown periods get no suffix
+func salaryWithPeriod(amount int, currency, period string) string {
+	return currencySymbol(currency) + abbreviateAmount(amount) + salaryPeriodSuffixes[strings.ToLower(period)]
+}
+
+// abbreviateAmount shortens n using k/M suffixes, keeping one decimal only when needed
+func abbreviateAmount(n int) string {
+	var (
+		value  float64
+		suffix string
+	)
+	switch {
+	case n >= 1000000:
+		value, suffix = float64(n)/1000000, "M"
+	case n >= 1000:
+		value, suffix = float64(n)/1000, "k"
+	default:
+		return strconv.Itoa(n)
+	}
+	return strconv.FormatFloat(math.Round(value*10)/10, 'f', -1, 64) + suffix
+}
+
+// joinNatural joins items the way they would be written in a sentence, e.g.
+// "Go, Rust, and Python". The Oxford comma is used unless oxford is passed as false
+func joinNatural(items []string, oxford ...bool) string {
+	switch len(items) {
+	case 0:
+		return ""
+	case 1:
+		return items[0]
+	case 2:
+		return items[0] + " and " + items[1]
+	}
+	last := " and "
+	if len(oxford) == 0 || oxford[0] {
+		last = ", and "
+	}
+	return strings.Join(items[:len(items)-1], ", ") + last + items[len(items)-1]
+}
+
+// srcset returns a srcset attribute value pointing at the resized webp variants of basePath,
+// e.g. srcset "/s/logo.png" 320 640 gives "/s/logo-320w.webp 320w, /s/logo-640w.webp 640w".
+// An empty basePath gives an empty string so the browser falls back to src
+func srcset(basePath string, widths ...int) string {
+	basePath = strings.TrimSpace(basePath)
+	if basePath == "" {
+		return ""
+	}
+	if dot := strings.LastIndex(basePath, "."); dot > strings.LastIndex(basePath, "/") {
+		basePath = basePath[:dot]
+	}
+	candidates := make([]string, 0, len(widths))
+	for _, w := range widths {
+		if w <= 0 {
+			continue
+		}
+		candidates = append(candidates, fmt.Sprintf("%s-%dw.webp %dw", basePath, w, w))
+	}
+	return strings.Join(candidates, ", ")
+}
+
+// sizes joins media conditions and slot widths into a sizes attribute value, e.g.
+// sizes "(max-width: 600px) 100vw" "50vw". Without arguments it is "100vw"
+func sizes(entries ...string) string {
+	parts := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if e = strings.TrimSpace(e); e != "" {
+			parts = append(parts, e)
+		}
+	}
+	if len(parts) == 0 {
+		return "100vw"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// defaultValue returns value unless it is nil or the zero value of its type, in which case fallback is returned
+func defaultValue(fallback, value interface{}) interface{} {
+	if isEmpty(value) {
+		return fallback
+	}
+	return value
+}
+
+// coalesce returns the first non-empty value, or nil when all values are empty
+func coalesce(values ...interface{}) interface{} {
+	for _, v := range values {
+		if !isEmpty(v) {
+			return v
+		}
+	}
+	return nil
+}
+
+func isEmpty(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return v.IsZero()
+}
+
+// paginate returns the page numbers to render for a pager, always including the
+// first and last page plus window neighbours around current. Gaps are marked with -1
+// e.g. paginate(5, 20, 1) returns [1 -1 4 5 6 -1 20]
+func paginate(current, total, window int) []int {
+	if total < 1 {
+		return []int{}
+	}
+	if current < 1 {
+		current = 1
+	}
+	if current > total {
+		current = total
+	}
+	if window < 0 {
+		window = 0
+	}
+	pages := make([]int, 0, 2*window+5)
+	prev := 0
+	for p := 1; p <= total; p++ {
+		if p != 1 && p != total && (p < current-window || p > current+window) {
+			continue
+		}
+		if p-prev > 1 {
+			pages = append(pages, -1)
+		}
+		pages = append(pages, p)
+		prev = p
+	}
+	return pages
+}
+
+// setQuery returns the encoded query string with key set to value, preserving the other params
+func setQuery(current url.Values, key, value string) string {
+	q := cloneQuery(current)
+	q.Set(key, value)
+	return q.Encode()
+}
+
+// hasQuery reports whether key is set to value in the current query
+func hasQuery(current url.Values, key, value string) bool {
+	for _, v := range current[key] {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// toggleQuery returns the encoded current query with value added to the values of key,
+// or removed from them if already present, leaving other values of key untouched.
+// Removing the last value drops key altogether
+func toggleQuery(current url.Values, key, value string) string {
+	q := cloneQuery(current)
+	values := q[key][:0]
+	found := false
+	for _, v := range q[key] {
+		if v == value {
+			found = true
+			continue
+		}
+		values = append(values, v)
+	}
+	if !found {
+		values = append(values, value)
+	}
+	if len(values) == 0 {
+		q.Del(key)
+	} else {
+		q[key] = values
+	}
+	return q.Encode()
+}
+
+func cloneQuery(current url.Values) url.Values {
+	q := make(url.Values, len(current))
+	for k, v := range current {
+		q[k] = append([]string(nil), v...)
+	}
+	return q
+}
+
+// maskEmail hides an email address behind its first character and domain, e.g.
+// "j•••@example.com". Single character local parts are masked entirely and values
+// without an @ keep only their first character
+func maskEmail(email string) string {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return ""
+	}
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		first := []rune(email)[0]
+		return string(first) + "•••"
+	}
+	local, domain := []rune(email[:at]), email[at+1:]
+	if len(local) <= 1 {
+		return "•••@" + domain
+	}
+	return string(local[0]) + "•••@" + domain
+}
+
+// highlight HTML escapes text and wraps case insensitive occurrences of each term of
+// query in <mark>. With wholeWord set only complete words match, otherwise any substring
+// does. Matches are found on the raw text and overlapping or adjacent ones are merged
+// before escaping, so neither the query nor the text can inject or break markup
+func highlight(text, query string, wholeWord bool) stdtemplate.HTML {
+	terms := strings.Fields(query)
+	if len(terms) == 0 || text == "" {
+		return stdtemplate.HTML(stdtemplate.HTMLEscapeString(text))
+	}
+	var matches [][]int
+	for _, term := range terms {
+		pattern := regexp.QuoteMeta(term)
+		if wholeWord {
+			pattern = `\b` + pattern + `\b`
+		}
+		matches = append(matches, regexp.MustCompile("(?i)"+pattern).FindAllStringIndex(text, -1)...)
+	}
+	if len(matches) == 0 {
+		return stdtemplate.HTML(stdtemplate.HTMLEscapeString(text))
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i][0] < matches[j][0] })
+	merged := [][]int{matches[0]}
+	for _, m := range matches[1:] {
+		last := merged[len(merged)-1]
+		if m[0] <= last[1] {
+			if m[1] > last[1] {
+				last[1] = m[1]
+			}
+			continue
+		}
+		merged = append(merged, m)
+	}
+	var b strings.Builder
+	prev := 0
+	for _, m := range merged {
+		b.WriteString(stdtemplate.HTMLEscapeString(text[prev:m[0]]))
+		b.WriteString("<mark>")
+		b.WriteString(stdtemplate.HTMLEscapeString(text[m[0]:m[1]]))
+		b.WriteString("</mark>")
+		prev = m[1]
+	}
+	b.WriteString(stdtemplate.HTMLEscapeString(text[prev:]))
+	return stdtemplate.HTML(b.String())
+}
+
+// fieldValue is the HTML escaped value the user gave the form field, to fill the input
+// back in when the form is shown again after a failed validation
+func fieldValue(form url.Values, name string) string {
+	return stdtemplate.HTMLEscapeString(form.Get(name))
+}
+
+// fieldError is the HTML escaped validation error of the form field, empty if it is valid
+func fieldError(errs map[string]string, name string) string {
+	return stdtemplate.HTMLEscapeString(errs[name])
+}
+
+// contrastColor returns "#000000" or "#ffffff", whichever contrasts more with the
+// background color hex ("#abc" or "#aabbcc", the # is optional). Invalid colors get
+// black text as most backgrounds on the site are light
+func contrastColor(hex string) string {
+	hex = strings.TrimPrefix(strings.TrimSpace(hex), "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 {
+		return "#000000"
+	}
+	rgb, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return "#000000"
+	}
+	// relative luminance as defined by WCAG 2
+	linear := func(c uint64) float64 {
+		v := float64(c) / 255
+		if v <= 0.03928 {
+			return v / 12.92
+		}
+		return math.Pow((v+0.055)/1.055, 2.4)
+	}
+	l := 0.2126*linear(rgb>>16&0xff) + 0.7152*linear(rgb>>8&0xff) + 0.0722*linear(rgb&0xff)
+	// contrast against black is (l+0.05)/0.05 and against white 1.05/(l+0.05)
+	if (l+0.05)*(l+0.05) > 0.05*1.05 {
+		return "#000000"
+	}
+	return "#ffffff"
+}
+
+// jsArgs marshals args into the JSON array data-args takes, the arguments event-handlers-js
+// calls the data-onclick function with. Pipe it to html inside the attribute
+func jsArgs(args ...interface{}) (string, error) {
+	b, err := json.Marshal(args)
+	return string(b), err
+}
+
+// salaryConvertedNote renders converted, a salary converted to the visitor's currency, marked
+// as an estimate with original, the salary as posted, in the tooltip. Without a conversion
+// it renders converted as is
+func salaryConvertedNote(original, converted string, isEstimate bool) stdtemplate.HTML {
+	if !isEstimate || original == "" || original == converted {
+		return stdtemplate.HTML(stdtemplate.HTMLEscapeString(converted))
+	}
+	return stdtemplate.HTML(fmt.Sprintf(
+		`<span class="salary-estimate" title="Estimated from %s">≈ %s <small>(est.)</small></span>`,
+		stdtemplate.HTMLEscapeString(original),
+		stdtemplate.HTMLEscapeString(converted),
+	))
+}
+
+// ApplicantBadgeFloor and ApplicantBadgeCap bound the applicant counts applicantBadge shows exactly
+var (
+	ApplicantBadgeFloor = 5
+	ApplicantBadgeCap   = 50
+)
+
+// applicantBadge returns the applicant count to show on a job: nothing below ApplicantBadgeFloor,
+// so low counts on new posts stay private, the exact count up to ApplicantBadgeCap and "N+",
+// N being the cap, above it
+func applicantBadge(count int) string {
+	if count < ApplicantBadgeFloor {
+		return ""
+	}
+	if ApplicantBadgeCap > 0 && count > ApplicantBadgeCap {
+		return strconv.Itoa(ApplicantBadgeCap) + "+"
+	}
+	return strconv.Itoa(count)
+}
+
+// userPalette is a curated set of avatar backgrounds that all keep white text readable
+var userPalette = []string{
+	"#1f77b4", "#d62728", "#2ca02c", "#9467bd", "#8c564b",
+	"#e377c2", "#17becf", "#bc5090", "#003f5c", "#665191",
+	"#d45087", "#2f4b7c", "#00876c", "#a05195", "#b35806",
+}
+
+func userColorHash(seed string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(strings.ToLower(strings.TrimSpace(seed))))
+	return h.Sum32()
+}
+
+// userColor maps a stable seed, such as a user ID or email, to an HSL color for avatar
+// placeholders so the same user always gets the same color. Saturation and lightness are
+// fixed so white initials on top stay readable whatever the hue
+func userColor(seed string) string {
+	return fmt.Sprintf("hsl(%d, 55%%, 42%%)", userColorHash(seed)%360)
+}
+
+// userPaletteColor is like userColor but picks from userPalette, its hex result can be
+// passed on to contrastColor
+func userPaletteColor(seed string) string {
+	return userPalette[userColorHash(seed)%uint32(len(userPalette))]
+}
+
+// timeTag renders t as a humanized <time> element carrying the machine readable
+// timestamp and the full date as a tooltip. Zero times render nothing
+func timeTag(t time.Time) stdtemplate.HTML {
+	if t.IsZero() {
+		return ""
+	}
+	return stdtemplate.HTML(fmt.Sprintf(
+		`<time datetime="%s" title="%s">%s</time>`,
+		t.UTC().Format(time.RFC3339),
+		t.UTC().Format("Mon, 2 Jan 2006 15:04 MST"),
+		stdtemplate.HTMLEscapeString(humanize.Time(t)),
+	))
+}
+
+// ReloadDebounce is how long the dev reloader waits after the last change to the views
+var ReloadDebounce = 300 * time.Millisecond
+
 func createTemplateFromGlob(funcMap customtemplate.FuncMap, glob string) *customtemplate.Template {
-	return customtemplate.Must(customtemplate.New("stdtmpl").Funcs(funcMap).ParseGlob(glob))
+	return customtemplate.Must(parseTemplates(funcMap, glob))
+}
+
+// parseTemplates parses glob, turning the panics some malformed templates cause into errors
+func parseTemplates(funcMap customtemplate.FuncMap, glob string) (tmpl *customtemplate.Template, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("parsing %s panicked: %v", glob, rec)
+		}
+	}()
+	return customtemplate.New("stdtmpl").Funcs(funcMap).ParseGlob(glob)
+}
+
+// current returns the parsed templates, the dev reloader may replace them at any time
+func (t *Template) current() *customtemplate.Template {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.templates
+}
+
+// Verify returns an error listing every name in required that isn't a parsed template,
+// so a missing view fails startup rather than the first request that renders it
+func (t *Template) Verify(required ...string) error {
+	var missing []string
+	for _, name := range required {
+		if t.current().Lookup(name) == nil {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing templates: %s", strings.Join(missing, ", "))
+	}
+	return nil
 }
 
 func (t *Template) JSEscapeString(s string) string {
@@ -142,8 +662,59 @@ func (t *Template) JSEscapeString(s string) string {
 }
 
 func (t *Template) Render(w http.ResponseWriter, status int, name string, data interface{}) error {
+	return t.RenderAs(w, status, "text/html; charset=utf-8", name, data)
+}
+
+// RenderAs renders the named template with an explicit Content-Type, for views that aren't
+// HTML such as XML feeds, which would otherwise be sniffed as text/html. Only HTML is minified
+func (t *Template) RenderAs(w http.ResponseWriter, status int, contentType, name string, data interface{}) error {
+	w.Header().Set("Content-Type", contentType)
+	if t.minifier == nil || !strings.HasPrefix(contentType, "text/html") {
+		w.WriteHeader(status)
+		return t.current().ExecuteTemplate(w, name, data)
+	}
+	// render fully first so a template error can still be reported with a proper status
+	var buf bytes.Buffer
+	if err := t.current().ExecuteTemplate(&buf, name, data); err != nil {
+		return err
+	}
+	w.WriteHeader(status)
+	return t.minifier.Minify("text/html", w, &buf)
+}
+
+// RenderContext renders the named HTML template like Render, but stops as soon as ctx is
+// done, e.g. when the client of the request went away, and returns ctx.Err() without writing
+// anything. The page is rendered into a buffer whose writes fail once ctx is done, which
+// aborts the template execution at its next write
+func (t *Template) RenderContext(ctx context.Context, w http.ResponseWriter, status int, name string, data interface{}) error {
+	var buf bytes.Buffer
+	err := t.current().ExecuteTemplate(&contextWriter{ctx: ctx, w: &buf}, name, data)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.WriteHeader(status)
-	return t.templates.ExecuteTemplate(w, name, data)
+	if t.minifier == nil {
+		_, err := buf.WriteTo(w)
+		return err
+	}
+	return t.minifier.Minify("text/html", w, &buf)
+}
+
+// contextWriter fails every write once ctx is done
+type contextWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (c *contextWriter) Write(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.w.Write(p)
 }
 
 func (t *Template) StringToHTML(s string) stdtemplate.HTML {
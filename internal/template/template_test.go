@@ -0,0 +1,104 @@
+package template
+
+import (
+	"net/url"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestPaginate(t *testing.T) {
+	tests := []struct {
+		name                   string
+		current, total, window int
+		want                   []int
+	}{
+		{"no pages", 1, 0, 2, []int{}},
+		{"single page", 1, 1, 2, []int{1}},
+		{"small total shows every page", 2, 4, 2, []int{1, 2, 3, 4}},
+		{"first page", 1, 20, 1, []int{1, 2, -1, 20}},
+		{"last page", 20, 20, 1, []int{1, -1, 19, 20}},
+		{"middle page", 5, 20, 1, []int{1, -1, 4, 5, 6, -1, 20}},
+		{"no gap next to the first page", 3, 20, 1, []int{1, 2, 3, 4, -1, 20}},
+		{"zero window", 10, 20, 0, []int{1, -1, 10, -1, 20}},
+		{"current past the end is clamped", 30, 5, 1, []int{1, -1, 4, 5}},
+		{"current before the start is clamped", -3, 5, 1, []int{1, 2, -1, 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := paginate(tt.current, tt.total, tt.window); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("paginate(%d, %d, %d) = %v, want %v", tt.current, tt.total, tt.window, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToggleQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		current string
+		key     string
+		value   string
+		want    string
+	}{
+		{"adds to an empty query", "", "tag", "go", "tag=go"},
+		{"adds to repeated keys", "tag=go&tag=rust", "tag", "zig", "tag=go&tag=rust&tag=zig"},
+		{"removes from repeated keys", "tag=go&tag=rust&tag=zig", "tag", "rust", "tag=go&tag=zig"},
+		{"removes every copy of the value", "tag=go&tag=go&tag=rust", "tag", "go", "tag=rust"},
+		{"removing the last value drops the key", "page=2&tag=go", "tag", "go", "page=2"},
+		{"keeps the other params", "page=2&q=remote", "tag", "go", "page=2&q=remote&tag=go"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current, err := url.ParseQuery(tt.current)
+			if err != nil {
+				t.Fatal(err)
+			}
+			before := current.Encode()
+			if got := toggleQuery(current, tt.key, tt.value); got != tt.want {
+				t.Errorf("toggleQuery(%q, %q, %q) = %q, want %q", tt.current, tt.key, tt.value, got, tt.want)
+			}
+			if after := current.Encode(); after != before {
+				t.Errorf("toggleQuery modified the current query, %q became %q", before, after)
+			}
+		})
+	}
+}
+
+func TestInQuery(t *testing.T) {
+	current := url.Values{"tag": {"go", "rust"}, "page": {"2"}}
+	tests := []struct {
+		key, value string
+		want       bool
+	}{
+		{"tag", "go", true},
+		{"tag", "rust", true},
+		{"tag", "zig", false},
+		{"page", "2", true},
+		{"remote", "true", false},
+	}
+	for _, tt := range tests {
+		if got := hasQuery(current, tt.key, tt.value); got != tt.want {
+			t.Errorf("inQuery(%v, %q, %q) = %v, want %v", current, tt.key, tt.value, got, tt.want)
+		}
+	}
+}
+
+// TestNewTemplate parses every view, so a view calling a func missing from the func map fails here
+// instead of at startup
+func TestNewTemplate(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir("../.."); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	defer func() {
+		if rec := recover(); rec != nil {
+			t.Fatalf("unable to parse views: %v", rec)
+		}
+	}()
+	NewTemplate("test", false)
+}
@@ -0,0 +1,46 @@
+package template
+
+import (
+	"fmt"
+	"time"
+
+	stdtemplate "html/template"
+
+	humanize "github.com/dustin/go-humanize"
+)
+
+// inZone returns t in the IANA time zone tz, e.g. "America/New_York". Empty or unknown
+// zones give t in UTC
+func inZone(t time.Time, tz string) time.Time {
+	if tz == "" {
+		return t.UTC()
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return t.UTC()
+	}
+	return t.In(loc)
+}
+
+// localDate formats t with layout in the visitor's time zone, see middleware.TimeZoneFromRequest
+func localDate(data map[string]interface{}, t time.Time, layout string) string {
+	if t.IsZero() {
+		return ""
+	}
+	tz, _ := data["TimeZone"].(string)
+	return inZone(t, tz).Format(layout)
+}
+
+// localTimeTag is timeTag with the full date in the tooltip shown in the visitor's time zone
+func localTimeTag(data map[string]interface{}, t time.Time) stdtemplate.HTML {
+	if t.IsZero() {
+		return ""
+	}
+	tz, _ := data["TimeZone"].(string)
+	return stdtemplate.HTML(fmt.Sprintf(
+		`<time datetime="%s" title="%s">%s</time>`,
+		t.UTC().Format(time.RFC3339),
+		inZone(t, tz).Format("Mon, 2 Jan 2006 15:04 MST"),
+		stdtemplate.HTMLEscapeString(humanize.Time(t)),
+	))
+}
@@ -0,0 +1,71 @@
+package template
+
+import (
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// DefaultPageTitleSeparator goes between the segments of titles built by pageTitle
+const DefaultPageTitleSeparator = "|"
+
+// PageTitleMaxLength is how many characters of a title search results show, longer
+// titles have their leading segments cut so the site name stays visible
+var PageTitleMaxLength = 60
+
+var (
+	pageTitleSiteName  string
+	pageTitleSeparator = DefaultPageTitleSeparator
+	pageTitleMu        sync.RWMutex
+)
+
+// ConfigurePageTitle sets the site name appended to every title built by pageTitle and the
+// separator between segments, an empty separator uses DefaultPageTitleSeparator
+func ConfigurePageTitle(siteName, separator string) {
+	separator = strings.TrimSpace(separator)
+	if separator == "" {
+		separator = DefaultPageTitleSeparator
+	}
+	pageTitleMu.Lock()
+	defer pageTitleMu.Unlock()
+	pageTitleSiteName = strings.TrimSpace(siteName)
+	pageTitleSeparator = separator
+}
+
+// pageTitle joins the non empty parts and the site name, e.g. "Senior Go Engineer | Acme | Site",
+// truncated to PageTitleMaxLength. The result is not escaped, pipe it through html in views
+func pageTitle(parts ...string) string {
+	pageTitleMu.RLock()
+	siteName, separator := pageTitleSiteName, " "+pageTitleSeparator+" "
+	pageTitleMu.RUnlock()
+	segments := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p = strings.Join(strings.Fields(p), " "); p != "" {
+			segments = append(segments, p)
+		}
+	}
+	head := strings.Join(segments, separator)
+	if head == "" {
+		return siteName
+	}
+	if siteName == "" {
+		return truncateTitle(head, separator, PageTitleMaxLength)
+	}
+	suffix := separator + siteName
+	budget := PageTitleMaxLength - utf8.RuneCountInString(suffix)
+	if budget <= 1 {
+		return siteName
+	}
+	return truncateTitle(head, separator, budget) + suffix
+}
+
+// truncateTitle cuts s to at most max characters, ending it with an ellipsis rather than
+// a dangling separator when cut
+func truncateTitle(s, separator string, max int) string {
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	cut := strings.TrimRight(string([]rune(s)[:max-1]), " ")
+	cut = strings.TrimRight(strings.TrimSuffix(cut, strings.TrimSpace(separator)), " ")
+	return cut + "…"
+}
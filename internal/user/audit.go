@@ -0,0 +1,51 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+)
+
+type actorContextKey struct{}
+
+// WithActor returns ctx carrying the id of the user on whose behalf the writes made with it
+// happen. It is recorded as the actor of the user_audit_log rows those writes add, so an
+// admin impersonating a user is recorded rather than the user
+func WithActor(ctx context.Context, actorID string) context.Context {
+	return context.WithValue(ctx, actorContextKey{}, actorID)
+}
+
+// ActorFromContext returns the actor set with WithActor
+func ActorFromContext(ctx context.Context) (string, bool) {
+	actorID, ok := ctx.Value(actorContextKey{}).(string)
+	return actorID, ok && actorID != ""
+}
+
+// AuditEntry is a row of user_audit_log
+type AuditEntry struct {
+	UserID   string
+	Action   string
+	OldValue string
+	NewValue string
+	ActorID  string
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// insertAudit adds a row to user_audit_log through db, which may be a transaction. Empty
+// values, including a ctx without an actor, are stored as NULL
+func insertAudit(ctx context.Context, db execer, userID, action, oldValue, newValue string) error {
+	actorID, _ := ActorFromContext(ctx)
+	_, err := db.ExecContext(ctx, `INSERT INTO user_audit_log (user_id, action, old_value, new_value, actor_id, created_at)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, ''), NOW())`, userID, action, oldValue, newValue, actorID)
+	return err
+}
+
+// RecordAudit adds action on userID to user_audit_log, for changes made outside the
+// repository such as an admin starting to impersonate a user
+func (r *Repository) RecordAudit(ctx context.Context, userID, action, oldValue, newValue string) error {
+	ctx, span := startSpan(ctx, "RecordAudit")
+	defer span.End()
+	return insertAudit(ctx, r.db, userID, action, oldValue, newValue)
+}
@@ -0,0 +1,99 @@
+package user
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Cache is a size bounded LRU cache of users by id with a short TTL, used to spare
+// the database repeated GetUser calls for the same user within a few seconds
+type Cache struct {
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+
+	hits   uint64
+	misses uint64
+}
+
+type cacheEntry struct {
+	user      User
+	expiresAt time.Time
+}
+
+func NewCache(ttl time.Duration, maxEntries int) *Cache {
+	return &Cache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+// Stats returns the number of cache hits and misses so far
+func (c *Cache) Stats() (hits, misses uint64) {
+	return atomic.LoadUint64(&c.hits), atomic.LoadUint64(&c.misses)
+}
+
+func (c *Cache) get(id string) (*User, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[id]
+	if !ok {
+		atomic.AddUint64(&c.misses, 1)
+		return nil, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.lru.Remove(el)
+		delete(c.entries, id)
+		atomic.AddUint64(&c.misses, 1)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	atomic.AddUint64(&c.hits, 1)
+	u := entry.user
+	return &u, true
+}
+
+func (c *Cache) set(u User) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[u.ID]; ok {
+		el.Value = &cacheEntry{user: u, expiresAt: time.Now().Add(c.ttl)}
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[u.ID] = c.lru.PushFront(&cacheEntry{user: u, expiresAt: time.Now().Add(c.ttl)})
+	for c.maxEntries > 0 && c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).user.ID)
+	}
+}
+
+func (c *Cache) invalidate(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[id]; ok {
+		c.lru.Remove(el)
+		delete(c.entries, id)
+	}
+}
+
+func (c *Cache) invalidateEmail(email string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, el := range c.entries {
+		if strings.EqualFold(el.Value.(*cacheEntry).user.Email, email) {
+			c.lru.Remove(el)
+			delete(c.entries, id)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package user
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// UserEventDispatcher is notified of changes to users. The repository calls it on its own
+// goroutine after the change is committed, so implementations may block but can't fail the request
+type UserEventDispatcher interface {
+	OnUserCreated(User)
+}
+
+// WebhookSignatureHeader carries the hex encoded HMAC-SHA256 of the request body keyed with the webhook secret
+const WebhookSignatureHeader = "X-Webhook-Signature"
+
+// WebhookDispatcher POSTs user events as JSON to URL, retrying failed deliveries with exponential backoff
+type WebhookDispatcher struct {
+	URL        string
+	Secret     []byte
+	Client     *http.Client
+	MaxRetries int
+	Backoff    time.Duration // delay before the first retry, doubled on every attempt
+}
+
+func NewWebhookDispatcher(url string, secret []byte) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		URL:        url,
+		Secret:     secret,
+		Client:     &http.Client{Timeout: 10 * time.Second},
+		MaxRetries: 5,
+		Backoff:    time.Second,
+	}
+}
+
+type webhookPayload struct {
+	Event         string    `json:"event"`
+	UserID        string    `json:"user_id"`
+	Email         string    `json:"email"`
+	Type          string    `json:"type"`
+	EmailVerified bool      `json:"email_verified"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+func (d *WebhookDispatcher) OnUserCreated(u User) {
+	payload := webhookPayload{
+		Event:         "user.created",
+		UserID:        u.ID,
+		Email:         u.Email,
+		Type:          u.Type,
+		EmailVerified: u.EmailVerified,
+		CreatedAt:     u.CreatedAt,
+	}
+	if err := d.send(payload); err != nil {
+		log.Printf("unable to deliver user.created webhook for user %s: %v", u.ID, err)
+	}
+}
+
+func (d *WebhookDispatcher) send(payload webhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(sha256.New, d.Secret)
+	mac.Write(body)
+	signature := hex.EncodeToString(mac.Sum(nil))
+
+	backoff := d.Backoff
+	for attempt := 0; ; attempt++ {
+		err = d.post(body, signature)
+		if err == nil || attempt >= d.MaxRetries {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (d *WebhookDispatcher) post(body []byte, signature string) error {
+	req, err := http.NewRequest(http.MethodPost, d.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookSignatureHeader, signature)
+	res, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %d", res.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,852 @@
+package user
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-cafe/job-board/internal/currency"
+	"github.com/segmentio/ksuid"
+)
+
+// MemStore is an in-memory UserStore, for tests of code depending on users and for running
+// without postgres. It follows the semantics of Repository, except that it knows nothing of
+// developer and recruiter profiles, so GetUserTypeByEmail and ListIncompleteUsers only look
+// at users, and that sessions are not purged by RunMaintenance. It is safe for concurrent use
+type MemStore struct {
+	mu             sync.Mutex
+	users          map[string]*memUser
+	signOnTokens   map[string]memToken
+	deletionTokens map[string]memDeletionToken
+	flags          map[string]map[string]bool
+	bookmarks      map[string][]memBookmark
+	identities     map[memIdentityKey]string
+	audit          []AuditEntry
+	unavailable    bool
+	now            func() time.Time
+}
+
+type memUser struct {
+	User
+	deleted bool
+}
+
+type memToken struct {
+	email     string
+	userType  string
+	remember  bool
+	createdAt time.Time
+}
+
+type memDeletionToken struct {
+	userID    string
+	createdAt time.Time
+}
+
+type memBookmark struct {
+	jobID     string
+	createdAt time.Time
+}
+
+type memIdentityKey struct {
+	provider   string
+	externalID string
+}
+
+func NewMemStore() *MemStore {
+	return &MemStore{
+		users:          make(map[string]*memUser),
+		signOnTokens:   make(map[string]memToken),
+		deletionTokens: make(map[string]memDeletionToken),
+		flags:          make(map[string]map[string]bool),
+		bookmarks:      make(map[string][]memBookmark),
+		identities:     make(map[memIdentityKey]string),
+		now:            time.Now,
+	}
+}
+
+// WithClock makes the store read the time from now, so tests can move it past token expiries
+func (s *MemStore) WithClock(now func() time.Time) *MemStore {
+	s.now = now
+	return s
+}
+
+// SetHealthy sets what Healthy reports, to exercise the database outage paths
+func (s *MemStore) SetHealthy(healthy bool) {
+	s.mu.Lock()
+	s.unavailable = !healthy
+	s.mu.Unlock()
+}
+
+func (s *MemStore) Healthy() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.unavailable
+}
+
+// live returns the user with id unless it doesn't exist or was deleted, s.mu must be held
+func (s *MemStore) live(id string) (*memUser, bool) {
+	u, ok := s.users[id]
+	if !ok || u.deleted {
+		return nil, false
+	}
+	return u, true
+}
+
+// byEmail returns the live user with email ignoring case, the oldest one of case duplicates
+// like Repository does. s.mu must be held
+func (s *MemStore) byEmail(email string) (*memUser, bool) {
+	var found *memUser
+	for _, u := range s.users {
+		if u.deleted || !strings.EqualFold(u.Email, email) {
+			continue
+		}
+		if found == nil || u.CreatedAt.Before(found.CreatedAt) || (u.CreatedAt.Equal(found.CreatedAt) && u.ID < found.ID) {
+			found = u
+		}
+	}
+	return found, found != nil
+}
+
+// snapshot copies the live users matching keep, s.mu must be held
+func (s *MemStore) snapshot(keep func(User) bool) []User {
+	users := []User{}
+	for _, u := range s.users {
+		if u.deleted || (keep != nil && !keep(u.User)) {
+			continue
+		}
+		c := u.User
+		c.Humanize()
+		users = append(users, c)
+	}
+	return users
+}
+
+// newestFirst sorts users by creation time then id, both descending
+func newestFirst(users []User) {
+	sort.Slice(users, func(i, j int) bool {
+		if !users[i].CreatedAt.Equal(users[j].CreatedAt) {
+			return users[i].CreatedAt.After(users[j].CreatedAt)
+		}
+		return users[i].ID > users[j].ID
+	})
+}
+
+func (s *MemStore) GetUser(ctx context.Context, userID string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.live(userID)
+	if !ok {
+		return nil, nil
+	}
+	c := u.User
+	c.Humanize()
+	return &c, nil
+}
+
+func (s *MemStore) GetUserTypeByEmail(ctx context.Context, email string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.byEmail(email)
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+	return u.Type, nil
+}
+
+func (s *MemStore) GetUserByProviderID(ctx context.Context, provider, externalID string) (*User, error) {
+	s.mu.Lock()
+	userID, ok := s.identities[memIdentityKey{provider, externalID}]
+	s.mu.Unlock()
+	if !ok {
+		return nil, nil
+	}
+	return s.GetUser(ctx, userID)
+}
+
+func (s *MemStore) CreateUser(ctx context.Context, u User) error {
+	_, err := s.CreateUserReturning(ctx, u)
+	return err
+}
+
+func (s *MemStore) CreateUserReturning(ctx context.Context, u User) (User, error) {
+	if err := ValidateUser(u); err != nil {
+		return User{}, err
+	}
+	if u.ID == "" {
+		userID, err := ksuid.NewRandom()
+		if err != nil {
+			return User{}, err
+		}
+		u.ID = userID.String()
+	}
+	if u.CreatedAt.IsZero() {
+		u.CreatedAt = s.now()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.users[u.ID]; exists {
+		return User{}, fmt.Errorf("user %s already exists", u.ID)
+	}
+	u.Humanize()
+	s.users[u.ID] = &memUser{User: u}
+	return u, nil
+}
+
+func (s *MemStore) UpdateAccessToken(ctx context.Context, userID, accessToken string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u, ok := s.users[userID]; ok {
+		u.AccessToken = accessToken
+	}
+	return nil
+}
+
+func (s *MemStore) UpdateRefreshToken(ctx context.Context, userID, refreshToken string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u, ok := s.users[userID]; ok {
+		u.RefreshToken = refreshToken
+	}
+	return nil
+}
+
+func (s *MemStore) DeleteUserByEmail(ctx context.Context, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, u := range s.users {
+		if u.Email == email {
+			delete(s.users, id)
+		}
+	}
+	return nil
+}
+
+func (s *MemStore) SetPreferredCurrency(ctx context.Context, userID, code string) error {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code != "" {
+		if !currency.IsSupported(code) {
+			return ErrUnsupportedCurrency
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u, ok := s.users[userID]; ok {
+		u.PreferredCurrency = code
+	}
+	return nil
+}
+
+func (s *MemStore) ChangeUserType(ctx context.Context, userID, newType string) error {
+	if newType != UserTypeDeveloper && newType != UserTypeRecruiter {
+		return ErrInvalidUserType
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if u.Type == newType {
+		return nil
+	}
+	s.recordAudit(ctx, userID, "change_user_type", u.Type, newType)
+	u.Type = newType
+	u.TokenVersion++
+	return nil
+}
+
+func (s *MemStore) TokenVersion(ctx context.Context, userID string) (int, error) {
+	u, err := s.GetUser(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	if u == nil {
+		return 0, ErrUserNotFound
+	}
+	return u.TokenVersion, nil
+}
+
+func (s *MemStore) ForceReauth(ctx context.Context, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[userID]
+	if !ok || u.deleted {
+		return ErrUserNotFound
+	}
+	u.ForceReauthAt = s.now()
+	s.recordAudit(ctx, userID, "force_reauth", "", "")
+	return nil
+}
+
+func (s *MemStore) RecordAudit(ctx context.Context, userID, action, oldValue, newValue string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recordAudit(ctx, userID, action, oldValue, newValue)
+	return nil
+}
+
+// recordAudit appends to the audit log, s.mu must be held
+func (s *MemStore) recordAudit(ctx context.Context, userID, action, oldValue, newValue string) {
+	actorID, _ := ActorFromContext(ctx)
+	s.audit = append(s.audit, AuditEntry{UserID: userID, Action: action, OldValue: oldValue, NewValue: newValue, ActorID: actorID})
+}
+
+// AuditLog returns the entries the store would have added to user_audit_log, oldest first
+func (s *MemStore) AuditLog() []AuditEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]AuditEntry(nil), s.audit...)
+}
+
+func (s *MemStore) ForceReauthAt(ctx context.Context, userID string) (time.Time, error) {
+	u, err := s.GetUser(ctx, userID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if u == nil {
+		return time.Time{}, ErrUserNotFound
+	}
+	return u.ForceReauthAt, nil
+}
+
+func (s *MemStore) LinkIdentity(ctx context.Context, userID, provider, externalID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := memIdentityKey{provider, externalID}
+	owner, ok := s.identities[key]
+	if !ok {
+		s.identities[key] = userID
+		return nil
+	}
+	if owner != userID {
+		return ErrIdentityLinked
+	}
+	return nil
+}
+
+func (s *MemStore) SetEmailVerifiedByEmails(ctx context.Context, emails []string, verified bool) (int64, error) {
+	wanted := make(map[string]bool, len(emails))
+	for _, e := range emails {
+		if e = strings.ToLower(strings.TrimSpace(e)); e != "" {
+			wanted[e] = true
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var updated int64
+	for _, u := range s.users {
+		if wanted[strings.ToLower(u.Email)] {
+			u.EmailVerified = verified
+			updated++
+		}
+	}
+	return updated, nil
+}
+
+func (s *MemStore) SyncEmailVerifiedFromProvider(ctx context.Context, userID string, verified bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u, ok := s.users[userID]; ok {
+		u.EmailVerified = verified
+	}
+	return nil
+}
+
+func (s *MemStore) SaveTokenSignOn(ctx context.Context, email, token, userType string, remember bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.signOnTokens[token]; exists {
+		return fmt.Errorf("sign on token already exists")
+	}
+	s.signOnTokens[token] = memToken{email: strings.ToLower(strings.TrimSpace(email)), userType: userType, remember: remember, createdAt: s.now()}
+	return nil
+}
+
+func (s *MemStore) CompleteSignOn(ctx context.Context, token string) (User, bool, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.signOnTokens[token]
+	if !ok {
+		return User{}, false, false, ErrSignOnTokenInvalid
+	}
+	delete(s.signOnTokens, token)
+	if s.now().Sub(t.createdAt) > SignOnTokenTTL {
+		return User{}, false, false, ErrSignOnTokenExpired
+	}
+	if existing, ok := s.byEmail(t.email); ok {
+		existing.EmailVerified = true
+		u := existing.User
+		u.Humanize()
+		return u, false, t.remember, nil
+	}
+	userID, err := ksuid.NewRandom()
+	if err != nil {
+		return User{}, false, false, err
+	}
+	u := User{ID: userID.String(), Email: t.email, Type: t.userType, CreatedAt: s.now().UTC(), EmailVerified: true}
+	if err := ValidateUser(u); err != nil {
+		return User{}, false, false, err
+	}
+	u.Humanize()
+	s.users[u.ID] = &memUser{User: u}
+	return u, true, t.remember, nil
+}
+
+// GetOrCreateUserFromToken returns the user the sign on token was issued for, creating it if
+// needed, without consuming the token
+//
+// Deprecated: use CompleteSignOn, which also consumes the token and verifies the email
+func (s *MemStore) GetOrCreateUserFromToken(ctx context.Context, token string) (User, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.signOnTokens[token]
+	if !ok {
+		return User{}, false, sql.ErrNoRows
+	}
+	if existing, ok := s.byEmail(t.email); ok {
+		u := existing.User
+		u.Humanize()
+		return u, true, nil
+	}
+	userID, err := ksuid.NewRandom()
+	if err != nil {
+		return User{}, false, err
+	}
+	u := User{ID: userID.String(), Email: t.email, Type: t.userType, CreatedAt: s.now()}
+	u.Humanize()
+	s.users[u.ID] = &memUser{User: u}
+	return u, false, nil
+}
+
+func (s *MemStore) ListActiveSignOnTokens(ctx context.Context, email string) ([]SignOnTokenInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokens := []SignOnTokenInfo{}
+	cutoff := s.now().Add(-SignOnTokenTTL)
+	for token, t := range s.signOnTokens {
+		if !strings.EqualFold(t.email, email) || !t.createdAt.After(cutoff) {
+			continue
+		}
+		tokens = append(tokens, SignOnTokenInfo{
+			ID:        SignOnTokenID(token),
+			UserType:  t.userType,
+			CreatedAt: t.createdAt,
+			ExpiresAt: t.createdAt.Add(SignOnTokenTTL),
+		})
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].CreatedAt.After(tokens[j].CreatedAt) })
+	return tokens, nil
+}
+
+func (s *MemStore) RevokeSignOnToken(ctx context.Context, email, tokenID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for token, t := range s.signOnTokens {
+		if strings.EqualFold(t.email, email) && subtle.ConstantTimeCompare([]byte(SignOnTokenID(token)), []byte(tokenID)) == 1 {
+			delete(s.signOnTokens, token)
+			return nil
+		}
+	}
+	return ErrSignOnTokenNotFound
+}
+
+func (s *MemStore) DeleteExpiredUserSignOnTokens(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.purgeSignOnTokens(s.now().Add(-SignOnTokenTTL))
+	return nil
+}
+
+// purgeSignOnTokens deletes the sign on tokens created before cutoff, s.mu must be held
+func (s *MemStore) purgeSignOnTokens(cutoff time.Time) int64 {
+	var n int64
+	for token, t := range s.signOnTokens {
+		if t.createdAt.Before(cutoff) {
+			delete(s.signOnTokens, token)
+			n++
+		}
+	}
+	return n
+}
+
+func (s *MemStore) ClearExpiredAccessTokens(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.clearExpiredAccessTokens(), nil
+}
+
+// clearExpiredAccessTokens empties the tokens of users past their expiration time, s.mu must be held
+func (s *MemStore) clearExpiredAccessTokens() int64 {
+	var n int64
+	now := s.now()
+	for _, u := range s.users {
+		if u.ExpirationTime.IsZero() || !u.ExpirationTime.Before(now) {
+			continue
+		}
+		if u.AccessToken == "" && u.RefreshToken == "" {
+			continue
+		}
+		u.AccessToken, u.RefreshToken = "", ""
+		n++
+	}
+	return n
+}
+
+func (s *MemStore) RequestAccountDeletion(ctx context.Context, userID string) (string, error) {
+	token, err := GenerateSignOnToken()
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.live(userID); !ok {
+		return "", ErrUserNotFound
+	}
+	s.deletionTokens[token] = memDeletionToken{userID: userID, createdAt: s.now()}
+	return token, nil
+}
+
+func (s *MemStore) ConfirmAccountDeletion(ctx context.Context, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.deletionTokens[token]
+	if !ok {
+		return ErrAccountDeletionTokenInvalid
+	}
+	delete(s.deletionTokens, token)
+	if s.now().Sub(t.createdAt) > AccountDeletionTokenTTL {
+		return ErrAccountDeletionTokenExpired
+	}
+	u, ok := s.live(t.userID)
+	if !ok {
+		return ErrUserNotFound
+	}
+	u.deleted = true
+	u.TokenVersion++
+	for other, d := range s.deletionTokens {
+		if d.userID == t.userID {
+			delete(s.deletionTokens, other)
+		}
+	}
+	return nil
+}
+
+func (s *MemStore) GetUserFlags(ctx context.Context, userID string) (map[string]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	flags := make(map[string]bool, len(s.flags[userID]))
+	for flag, on := range s.flags[userID] {
+		flags[flag] = on
+	}
+	return flags, nil
+}
+
+func (s *MemStore) SetUserFlag(ctx context.Context, userID, flag string, on bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.flags[userID] == nil {
+		s.flags[userID] = make(map[string]bool)
+	}
+	s.flags[userID][flag] = on
+	return nil
+}
+
+func (s *MemStore) SaveJobBookmark(ctx context.Context, userID, jobID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, b := range s.bookmarks[userID] {
+		if b.jobID == jobID {
+			return nil
+		}
+	}
+	s.bookmarks[userID] = append(s.bookmarks[userID], memBookmark{jobID: jobID, createdAt: s.now()})
+	return nil
+}
+
+func (s *MemStore) RemoveJobBookmark(ctx context.Context, userID, jobID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.bookmarks[userID][:0]
+	for _, b := range s.bookmarks[userID] {
+		if b.jobID != jobID {
+			kept = append(kept, b)
+		}
+	}
+	s.bookmarks[userID] = kept
+	return nil
+}
+
+func (s *MemStore) ListBookmarkedJobIDs(ctx context.Context, userID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []string
+	// bookmarks are appended as they are saved, so the latest are at the end
+	for i := len(s.bookmarks[userID]) - 1; i >= 0; i-- {
+		ids = append(ids, s.bookmarks[userID][i].jobID)
+	}
+	return ids, nil
+}
+
+func (s *MemStore) ListUsers(ctx context.Context, f ListFilter) ([]User, error) {
+	users := []User{}
+	err := s.EachUser(ctx, f, func(u User) error {
+		users = append(users, u)
+		return nil
+	})
+	return users, err
+}
+
+func (s *MemStore) EachUser(ctx context.Context, f ListFilter, fn func(User) error) error {
+	email := strings.ToLower(f.Email)
+	s.mu.Lock()
+	users := s.snapshot(func(u User) bool {
+		return (f.Type == "" || u.Type == f.Type) &&
+			(f.EmailVerified == nil || u.EmailVerified == *f.EmailVerified) &&
+			(email == "" || strings.Contains(strings.ToLower(u.Email), email)) &&
+			(f.CreatedAfter.IsZero() || !u.CreatedAt.Before(f.CreatedAfter)) &&
+			(f.CreatedBefore.IsZero() || u.CreatedAt.Before(f.CreatedBefore))
+	})
+	s.mu.Unlock()
+	newestFirst(users)
+	for _, u := range users {
+		if err := fn(u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemStore) ListUsersAfter(ctx context.Context, cursor string, limit int) ([]User, string, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+	keep := func(u User) bool { return !u.CreatedAt.IsZero() }
+	if cursor != "" {
+		createdAt, id, err := decodeUserCursor(cursor)
+		if err != nil {
+			return []User{}, "", err
+		}
+		keep = func(u User) bool {
+			return u.CreatedAt.Before(createdAt) || (u.CreatedAt.Equal(createdAt) && u.ID < id)
+		}
+	}
+	s.mu.Lock()
+	users := s.snapshot(keep)
+	s.mu.Unlock()
+	newestFirst(users)
+	if len(users) <= limit {
+		return users, "", nil
+	}
+	users = users[:limit]
+	last := users[len(users)-1]
+	return users, encodeUserCursor(last.CreatedAt, last.ID), nil
+}
+
+func (s *MemStore) ListIncompleteUsers(ctx context.Context, olderThan time.Duration) ([]User, error) {
+	cutoff := s.now().Add(-olderThan)
+	s.mu.Lock()
+	users := s.snapshot(func(u User) bool {
+		return !u.EmailVerified && u.CreatedAt.Before(cutoff)
+	})
+	s.mu.Unlock()
+	sort.Slice(users, func(i, j int) bool { return users[i].CreatedAt.Before(users[j].CreatedAt) })
+	return users, nil
+}
+
+func (s *MemStore) SearchUsersByEmail(ctx context.Context, term string, limit int) ([]User, error) {
+	term = strings.ToLower(strings.TrimSpace(term))
+	if term == "" {
+		return []User{}, nil
+	}
+	if limit <= 0 || limit > MaxUserSearchResults {
+		limit = MaxUserSearchResults
+	}
+	s.mu.Lock()
+	users := s.snapshot(func(u User) bool {
+		return strings.Contains(strings.ToLower(u.Email), term)
+	})
+	s.mu.Unlock()
+	rank := func(u User) int {
+		email := strings.ToLower(u.Email)
+		switch {
+		case email == term:
+			return 0
+		case strings.HasPrefix(email, term):
+			return 1
+		}
+		return 2
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if ri, rj := rank(users[i]), rank(users[j]); ri != rj {
+			return ri < rj
+		}
+		return users[i].CreatedAt.After(users[j].CreatedAt)
+	})
+	if len(users) > limit {
+		users = users[:limit]
+	}
+	return users, nil
+}
+
+func (s *MemStore) RecentUsers(ctx context.Context, limit int) ([]User, error) {
+	if limit <= 0 || limit > MaxRecentUsers {
+		limit = MaxRecentUsers
+	}
+	s.mu.Lock()
+	users := s.snapshot(nil)
+	s.mu.Unlock()
+	newestFirst(users)
+	if len(users) > limit {
+		users = users[:limit]
+	}
+	return users, nil
+}
+
+func (s *MemStore) SignupsByDay(ctx context.Context, from, to time.Time) ([]DayCount, error) {
+	day := func(t time.Time) time.Time {
+		t = t.UTC()
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	counts := make(map[time.Time]int)
+	s.mu.Lock()
+	for _, u := range s.users {
+		counts[day(u.CreatedAt)]++
+	}
+	s.mu.Unlock()
+	days := []DayCount{}
+	for d := day(from); !d.After(day(to)); d = d.AddDate(0, 0, 1) {
+		days = append(days, DayCount{Date: d, Count: counts[d]})
+	}
+	return days, nil
+}
+
+func (s *MemStore) FindDuplicateEmails(ctx context.Context) ([]DuplicateGroup, error) {
+	s.mu.Lock()
+	users := s.snapshot(nil)
+	s.mu.Unlock()
+	byEmail := make(map[string][]User)
+	for _, u := range users {
+		normalized := strings.ToLower(u.Email)
+		byEmail[normalized] = append(byEmail[normalized], u)
+	}
+	var groups []DuplicateGroup
+	for normalized, group := range byEmail {
+		if len(group) < 2 {
+			continue
+		}
+		sort.Slice(group, func(i, j int) bool {
+			if !group[i].CreatedAt.Equal(group[j].CreatedAt) {
+				return group[i].CreatedAt.Before(group[j].CreatedAt)
+			}
+			return group[i].ID < group[j].ID
+		})
+		groups = append(groups, DuplicateGroup{NormalizedEmail: normalized, Users: group})
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].NormalizedEmail < groups[j].NormalizedEmail })
+	return groups, nil
+}
+
+func (s *MemStore) MergeUsers(ctx context.Context, primaryID string, duplicateIDs []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	primary, ok := s.live(primaryID)
+	if !ok {
+		return ErrUserNotFound
+	}
+	// check every duplicate first, Repository rolls back on any failure
+	for _, id := range duplicateIDs {
+		if id == primaryID {
+			continue
+		}
+		u, ok := s.live(id)
+		if !ok {
+			return ErrUserNotFound
+		}
+		if !strings.EqualFold(u.Email, primary.Email) {
+			return fmt.Errorf("user %s has email %s, not a duplicate of %s", id, u.Email, primary.Email)
+		}
+	}
+	for _, id := range duplicateIDs {
+		if id == primaryID {
+			continue
+		}
+		for flag, on := range s.flags[id] {
+			if _, set := s.flags[primaryID][flag]; !set {
+				if s.flags[primaryID] == nil {
+					s.flags[primaryID] = make(map[string]bool)
+				}
+				s.flags[primaryID][flag] = on
+			}
+		}
+		delete(s.flags, id)
+		for _, b := range s.bookmarks[id] {
+			exists := false
+			for _, pb := range s.bookmarks[primaryID] {
+				if pb.jobID == b.jobID {
+					exists = true
+					break
+				}
+			}
+			if !exists {
+				s.bookmarks[primaryID] = append(s.bookmarks[primaryID], b)
+			}
+		}
+		delete(s.bookmarks, id)
+		for key, owner := range s.identities {
+			if owner == id {
+				s.identities[key] = primaryID
+			}
+		}
+		u := s.users[id]
+		u.deleted = true
+		u.TokenVersion++
+		if u.Email != primary.Email {
+			for token, t := range s.signOnTokens {
+				if t.email == u.Email {
+					delete(s.signOnTokens, token)
+				}
+			}
+		}
+	}
+	sort.SliceStable(s.bookmarks[primaryID], func(i, j int) bool {
+		return s.bookmarks[primaryID][i].createdAt.Before(s.bookmarks[primaryID][j].createdAt)
+	})
+	return nil
+}
+
+func (s *MemStore) RunMaintenance(ctx context.Context, opts MaintenanceOptions) (MaintenanceReport, error) {
+	report := MaintenanceReport{Deleted: make(map[string]int64), Duration: make(map[string]time.Duration)}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if opts.SignOnTokens {
+		report.Deleted["user_sign_on_token"] = s.purgeSignOnTokens(s.now().Add(-SignOnTokenTTL))
+		report.Duration["user_sign_on_token"] = 0
+	}
+	if opts.AccountDeletionTokens {
+		var n int64
+		cutoff := s.now().Add(-AccountDeletionTokenTTL)
+		for token, t := range s.deletionTokens {
+			if t.createdAt.Before(cutoff) {
+				delete(s.deletionTokens, token)
+				n++
+			}
+		}
+		report.Deleted["account_deletion_token"] = n
+		report.Duration["account_deletion_token"] = 0
+	}
+	if opts.Sessions {
+		report.Deleted["sessions"] = 0
+		report.Duration["sessions"] = 0
+	}
+	if opts.AccessTokens {
+		report.Deleted["users.access_token"] = s.clearExpiredAccessTokens()
+		report.Duration["users.access_token"] = 0
+	}
+	return report, nil
+}
@@ -1,10 +1,14 @@
 package user
 
-import "time"
+import (
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
 
 const (
 	UserTypeDeveloper = "jobseeker"    // TODO: Change to employee
-	UserTypeAdmin     = "admin"        // TODO: Unused remove
+	UserTypeAdmin     = "admin"        // grants the IsAdmin session claim at sign in
 	UserTypeRecruiter = "workerseeker" // TODO: Change to employer
 )
 
@@ -19,4 +23,81 @@ type User struct {
 	Type               string
 	IsAdmin            bool // Not sure how this is used.
 	CreatedAtHumanised string
+	PreferredCurrency  string    // ISO 4217 code salaries are shown in, empty for each job's own currency
+	TokenVersion       int       // bumped to invalidate the session jwts issued before a role change
+	ForceReauthAt      time.Time // sessions issued before it must sign in again, zero when never forced
+}
+
+// Identity providers a user can be linked to with LinkIdentity. Sign ins through firebase are
+// linked under ProviderFirebase with the firebase uid, which is also the users.id
+const (
+	ProviderFirebase = "firebase"
+	ProviderGoogle   = "google"
+	ProviderGitHub   = "github"
+)
+
+// Identity is the id an identity provider knows a user by, one user can have several
+type Identity struct {
+	UserID     string
+	Provider   string
+	ExternalID string
+	CreatedAt  time.Time
+}
+
+// Humanize recomputes the display fields derived from the timestamps, CreatedAtHumanised
+// is relative to now so it goes stale on users kept around, e.g. in the cache or a JSON copy
+func (u *User) Humanize() {
+	if u.CreatedAt.IsZero() {
+		u.CreatedAtHumanised = ""
+		return
+	}
+	u.CreatedAtHumanised = humanize.Time(u.CreatedAt.UTC())
+}
+
+// ListFilter narrows ListUsers, zero values match every user
+type ListFilter struct {
+	Type          string
+	EmailVerified *bool
+	Email         string // case insensitive substring match
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+}
+
+// MaintenanceOptions selects which expired rows RunMaintenance purges
+type MaintenanceOptions struct {
+	SignOnTokens          bool // magic link tokens, which double as email verification tokens, older than SignOnTokenTTL
+	AccountDeletionTokens bool // unconfirmed account deletion requests older than AccountDeletionTokenTTL
+	Sessions              bool // server side sessions past their expiry, only used with SESSION_STORE=postgres
+	AccessTokens          bool // provider access and refresh tokens past expiration_time, nulled rather than deleted
+}
+
+// MaintenanceReport holds how many rows RunMaintenance deleted and how long it took, keyed by table
+type MaintenanceReport struct {
+	Deleted  map[string]int64
+	Duration map[string]time.Duration
+}
+
+// MaintenanceRecorder is told about every table purged by RunMaintenance and
+// DeleteExpiredUserSignOnTokens, e.g. to alert when a scheduled cleanup stops deleting
+// anything or suddenly deletes far more than usual
+type MaintenanceRecorder func(table string, deleted int64, took time.Duration)
+
+// DuplicateGroup is a set of users whose emails only differ in case, oldest first
+type DuplicateGroup struct {
+	NormalizedEmail string
+	Users           []User
+}
+
+// SignOnTokenInfo describes a pending magic link without exposing the token itself
+type SignOnTokenInfo struct {
+	ID        string // SignOnTokenID of the token, used to revoke it
+	UserType  string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// DayCount is the number of users that signed up on Date
+type DayCount struct {
+	Date  time.Time
+	Count int
 }
@@ -0,0 +1,90 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestHumanize(t *testing.T) {
+	tests := []struct {
+		name      string
+		createdAt time.Time
+		want      string
+	}{
+		{"zero time", time.Time{}, ""},
+		{"days ago", time.Now().Add(-3 * 24 * time.Hour), "3 days ago"},
+		{"not in UTC", time.Now().Add(-2 * time.Hour).In(time.FixedZone("CEST", 2*60*60)), "2 hours ago"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := User{CreatedAt: tt.createdAt, CreatedAtHumanised: "stale"}
+			u.Humanize()
+			if u.CreatedAtHumanised != tt.want {
+				t.Errorf("CreatedAtHumanised = %q, want %q", u.CreatedAtHumanised, tt.want)
+			}
+		})
+	}
+}
+
+// TestHumanizedLoadPaths signs a user up through a magic link, then loads them back through
+// the other paths. Each must return the same CreatedAtHumanised as the sign up did
+func TestHumanizedLoadPaths(t *testing.T) {
+	ctx := context.Background()
+	signedUpAt := time.Now().Add(-3 * 24 * time.Hour)
+	store := NewMemStore().WithClock(func() time.Time { return signedUpAt })
+	if err := store.SaveTokenSignOn(ctx, "jane@example.com", "token", "developer", false); err != nil {
+		t.Fatal(err)
+	}
+	signedUp, _, _, err := store.CompleteSignOn(ctx, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signedUp.CreatedAtHumanised != "3 days ago" {
+		t.Fatalf("sign up CreatedAtHumanised = %q, want %q", signedUp.CreatedAtHumanised, "3 days ago")
+	}
+
+	loadPaths := []struct {
+		name string
+		load func() (User, error)
+	}{
+		{"GetUser", func() (User, error) {
+			u, err := store.GetUser(ctx, signedUp.ID)
+			if err != nil || u == nil {
+				return User{}, err
+			}
+			return *u, nil
+		}},
+		{"ListUsers", func() (User, error) {
+			users, err := store.ListUsers(ctx, ListFilter{Email: "jane@"})
+			if err != nil || len(users) != 1 {
+				return User{}, err
+			}
+			return users[0], nil
+		}},
+		{"JSON round trip", func() (User, error) {
+			b, err := json.Marshal(User{ID: signedUp.ID, CreatedAt: signedUp.CreatedAt})
+			if err != nil {
+				return User{}, err
+			}
+			var u User
+			if err := json.Unmarshal(b, &u); err != nil {
+				return User{}, err
+			}
+			u.Humanize()
+			return u, nil
+		}},
+	}
+	for _, p := range loadPaths {
+		t.Run(p.name, func(t *testing.T) {
+			u, err := p.load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.CreatedAtHumanised != signedUp.CreatedAtHumanised {
+				t.Errorf("CreatedAtHumanised = %q, want %q as on sign up", u.CreatedAtHumanised, signedUp.CreatedAtHumanised)
+			}
+		})
+	}
+}
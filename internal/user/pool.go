@@ -0,0 +1,44 @@
+package user
+
+import (
+	"database/sql"
+	"time"
+)
+
+// PoolConfig bounds the connections a *sql.DB keeps to postgres. Zero values use the defaults
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+const (
+	DefaultMaxOpenConns    = 20
+	DefaultMaxIdleConns    = 20
+	DefaultConnMaxLifetime = 5 * time.Minute
+)
+
+// ConfigurePool applies cfg to db. Idle connections are capped at the open connection
+// limit as database/sql would silently do the same
+func ConfigurePool(db *sql.DB, cfg PoolConfig) {
+	if cfg.MaxOpenConns <= 0 {
+		cfg.MaxOpenConns = DefaultMaxOpenConns
+	}
+	if cfg.MaxIdleConns <= 0 {
+		cfg.MaxIdleConns = DefaultMaxIdleConns
+	}
+	if cfg.MaxIdleConns > cfg.MaxOpenConns {
+		cfg.MaxIdleConns = cfg.MaxOpenConns
+	}
+	if cfg.ConnMaxLifetime <= 0 {
+		cfg.ConnMaxLifetime = DefaultConnMaxLifetime
+	}
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+}
+
+// PoolStats returns the connection pool statistics of the underlying database, for metrics
+func (r *Repository) PoolStats() sql.DBStats {
+	return r.db.Stats()
+}
@@ -0,0 +1,186 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-cafe/job-board/internal/developer"
+	"github.com/golang-cafe/job-board/internal/recruiter"
+	"github.com/gosimple/slug"
+	"github.com/segmentio/ksuid"
+)
+
+// ProfileRepository reads and writes the developer_profile and recruiter_profile rows,
+// which people create before they verify their email and so before they have a users row.
+// Profiles are looked up by email, case insensitively
+type ProfileRepository struct {
+	db *sql.DB
+}
+
+func NewProfileRepository(db *sql.DB) *ProfileRepository {
+	return &ProfileRepository{db: db}
+}
+
+// UpsertDeveloperProfile creates the developer profile of dev.Email, or updates the one it
+// already has. ID and Slug are generated for new profiles when empty and kept on updates
+func (r *ProfileRepository) UpsertDeveloperProfile(ctx context.Context, dev developer.Developer) error {
+	ctx, span := startSpan(ctx, "UpsertDeveloperProfile")
+	defer span.End()
+	if dev.ID == "" {
+		id, err := ksuid.NewRandom()
+		if err != nil {
+			return err
+		}
+		dev.ID = id.String()
+	}
+	if dev.Slug == "" {
+		dev.Slug = slug.Make(fmt.Sprintf("%s %d", dev.Name, time.Now().UTC().Unix()))
+	}
+	_, err := r.db.ExecContext(ctx, `INSERT INTO developer_profile (id, email, name, location, linkedin_url, github_url, twitter_url, hourly_rate, bio, available, image_id, slug, skills, role_types, role_level, search_status, detected_location_id, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, NOW(), NOW())
+		ON CONFLICT (email) DO UPDATE SET
+			name = EXCLUDED.name,
+			location = EXCLUDED.location,
+			linkedin_url = EXCLUDED.linkedin_url,
+			github_url = EXCLUDED.github_url,
+			twitter_url = EXCLUDED.twitter_url,
+			hourly_rate = EXCLUDED.hourly_rate,
+			bio = EXCLUDED.bio,
+			available = EXCLUDED.available,
+			image_id = EXCLUDED.image_id,
+			skills = EXCLUDED.skills,
+			role_types = EXCLUDED.role_types,
+			role_level = EXCLUDED.role_level,
+			search_status = EXCLUDED.search_status,
+			detected_location_id = EXCLUDED.detected_location_id,
+			updated_at = NOW()`,
+		dev.ID,
+		dev.Email,
+		dev.Name,
+		dev.Location,
+		dev.LinkedinURL,
+		dev.GithubURL,
+		dev.TwitterURL,
+		dev.HourlyRate,
+		dev.Bio,
+		dev.Available,
+		dev.ImageID,
+		dev.Slug,
+		dev.Skills,
+		strings.Join(dev.RoleTypes, ","),
+		dev.RoleLevel,
+		dev.SearchStatus,
+		dev.DetectedLocationID,
+	)
+	return err
+}
+
+// GetDeveloperProfile returns the developer profile of email, sql.ErrNoRows if there is none
+func (r *ProfileRepository) GetDeveloperProfile(ctx context.Context, email string) (developer.Developer, error) {
+	ctx, span := startSpan(ctx, "GetDeveloperProfile")
+	defer span.End()
+	dev := developer.Developer{}
+	var roleTypes string
+	var updatedAt sql.NullTime
+	err := r.db.QueryRowContext(ctx, `SELECT id, email, name, location, linkedin_url, github_url, twitter_url, hourly_rate, bio, available, image_id, slug, skills, role_types, role_level, search_status, detected_location_id, created_at, updated_at
+		FROM developer_profile WHERE LOWER(email) = LOWER($1)`, email).Scan(
+		&dev.ID,
+		&dev.Email,
+		&dev.Name,
+		&dev.Location,
+		&dev.LinkedinURL,
+		&dev.GithubURL,
+		&dev.TwitterURL,
+		&dev.HourlyRate,
+		&dev.Bio,
+		&dev.Available,
+		&dev.ImageID,
+		&dev.Slug,
+		&dev.Skills,
+		&roleTypes,
+		&dev.RoleLevel,
+		&dev.SearchStatus,
+		&dev.DetectedLocationID,
+		&dev.CreatedAt,
+		&updatedAt,
+	)
+	if err != nil {
+		return developer.Developer{}, err
+	}
+	dev.RoleTypes = strings.Split(roleTypes, ",")
+	dev.UpdatedAt = dev.CreatedAt
+	if updatedAt.Valid {
+		dev.UpdatedAt = updatedAt.Time
+	}
+	return dev, nil
+}
+
+// UpsertRecruiterProfile creates the recruiter profile of rec.Email, or updates the one it
+// already has. ID and Slug are generated for new profiles when empty and kept on updates
+func (r *ProfileRepository) UpsertRecruiterProfile(ctx context.Context, rec recruiter.Recruiter) error {
+	ctx, span := startSpan(ctx, "UpsertRecruiterProfile")
+	defer span.End()
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// recruiter_profile.email isn't unique, so there is no conflict to upsert on
+	res, err := tx.ExecContext(ctx, `UPDATE recruiter_profile SET name = $1, company_url = $2, updated_at = NOW() WHERE LOWER(email) = LOWER($3)`, rec.Name, rec.CompanyURL, rec.Email)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return tx.Commit()
+	}
+	if rec.ID == "" {
+		id, err := ksuid.NewRandom()
+		if err != nil {
+			return err
+		}
+		rec.ID = id.String()
+	}
+	if rec.Slug == "" {
+		rec.Slug = slug.Make(fmt.Sprintf("%s %d", rec.Name, time.Now().UTC().Unix()))
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO recruiter_profile (id, email, name, company_url, slug, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, NOW(), NOW())`, rec.ID, rec.Email, rec.Name, rec.CompanyURL, rec.Slug); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetRecruiterProfile returns the recruiter profile of email, sql.ErrNoRows if there is none
+func (r *ProfileRepository) GetRecruiterProfile(ctx context.Context, email string) (recruiter.Recruiter, error) {
+	ctx, span := startSpan(ctx, "GetRecruiterProfile")
+	defer span.End()
+	rec := recruiter.Recruiter{}
+	var name sql.NullString
+	var updatedAt sql.NullTime
+	err := r.db.QueryRowContext(ctx, `SELECT id, email, name, company_url, slug, created_at, updated_at, plan_expired_at
+		FROM recruiter_profile WHERE LOWER(email) = LOWER($1) ORDER BY created_at ASC LIMIT 1`, email).Scan(
+		&rec.ID,
+		&rec.Email,
+		&name,
+		&rec.CompanyURL,
+		&rec.Slug,
+		&rec.CreatedAt,
+		&updatedAt,
+		&rec.PlanExpiredAt,
+	)
+	if err != nil {
+		return recruiter.Recruiter{}, err
+	}
+	rec.Name = name.String
+	rec.UpdatedAt = rec.CreatedAt
+	if updatedAt.Valid {
+		rec.UpdatedAt = updatedAt.Time
+	}
+	return rec, nil
+}
@@ -1,43 +1,199 @@
 package user
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/dustin/go-humanize"
+	"github.com/golang-cafe/job-board/internal/currency"
+	"github.com/golang-cafe/job-board/internal/database"
+	"github.com/golang-cafe/job-board/internal/search"
+	"github.com/lib/pq"
 	"github.com/segmentio/ksuid"
 )
 
 type Repository struct {
-	db *sql.DB
+	db         *sql.DB
+	replica    *sql.DB
+	cache      *Cache
+	dispatcher UserEventDispatcher
+	index      search.IndexSyncer
+	health     *database.Health
+	profiles   *ProfileRepository
+	recorder   MaintenanceRecorder
 }
 
 func NewRepository(db *sql.DB) *Repository {
-	return &Repository{db}
+	return &Repository{db: db, index: search.NopSyncer{}}
 }
 
-func (r *Repository) SaveTokenSignOn(email, token, userType string) error {
-	if _, err := r.db.Exec(`INSERT INTO user_sign_on_token (token, email, user_type, created_at) VALUES ($1, $2, $3, NOW())`, token, email, userType); err != nil {
+// WithReplica sends the read only lookups and listings to replica, writes and reads that
+// must see them stay on the primary, see database.WithPrimary
+func (r *Repository) WithReplica(replica *sql.DB) *Repository {
+	r.replica = replica
+	return r
+}
+
+// reader is the database read only queries made with ctx should use
+func (r *Repository) reader(ctx context.Context) *sql.DB {
+	return database.Reader(ctx, r.db, r.replica)
+}
+
+// WithCache puts c in front of GetUser. Writes going through the repository invalidate
+// the affected user, other changes are picked up once the cache TTL expires
+func (r *Repository) WithCache(c *Cache) *Repository {
+	r.cache = c
+	return r
+}
+
+// WithDispatcher notifies d of users created through the repository
+func (r *Repository) WithDispatcher(d UserEventDispatcher) *Repository {
+	r.dispatcher = d
+	return r
+}
+
+// WithIndexSyncer keeps the external search index in sync with users written through the repository
+func (r *Repository) WithIndexSyncer(s search.IndexSyncer) *Repository {
+	r.index = s
+	return r
+}
+
+// WithMaintenanceRecorder reports the rows purged by the maintenance routines to rec
+func (r *Repository) WithMaintenanceRecorder(rec MaintenanceRecorder) *Repository {
+	r.recorder = rec
+	return r
+}
+
+func (r *Repository) recordPurge(table string, deleted int64, took time.Duration) {
+	if r.recorder != nil {
+		r.recorder(table, deleted, took)
+	}
+}
+
+func userDocumentID(userID string) string {
+	return "user:" + userID
+}
+
+func userDocument(u User) search.Document {
+	return search.Document{
+		ID:   userDocumentID(u.ID),
+		Type: "user",
+		Fields: map[string]interface{}{
+			"email":          u.Email,
+			"user_type":      u.Type,
+			"email_verified": u.EmailVerified,
+			"created_at":     u.CreatedAt,
+		},
+	}
+}
+
+// WithProfiles lets GetUserTypeByEmail recognise people who created a developer or recruiter
+// profile but haven't verified their email, and so have no users row, yet
+func (r *Repository) WithProfiles(p *ProfileRepository) *Repository {
+	r.profiles = p
+	return r
+}
+
+// WithHealth reports connection failures of GetUser and GetUserByProviderID to h, which then gates
+// requests until the database is back. They are the lookups behind every authenticated request
+func (r *Repository) WithHealth(h *database.Health) *Repository {
+	r.health = h
+	return r
+}
+
+// Healthy reports whether the database behind the repository is reachable
+func (r *Repository) Healthy() bool {
+	return r.health == nil || r.health.Healthy()
+}
+
+// observe reports err to the health tracker, if any, and returns it
+func (r *Repository) observe(err error) error {
+	if r.health == nil {
+		return err
+	}
+	return r.health.Observe(err)
+}
+
+// userCreated hands u to the dispatcher without blocking the caller
+func (r *Repository) userCreated(u User) {
+	if r.dispatcher != nil {
+		go r.dispatcher.OnUserCreated(u)
+	}
+}
+
+// invalidate drops the user from the cache, if any
+func (r *Repository) invalidate(userID string) {
+	if r.cache != nil {
+		r.cache.invalidate(userID)
+	}
+}
+
+// SignOnTokenBytes is the number of random bytes in tokens returned by GenerateSignOnToken.
+// Values below 16, i.e. 128 bits of entropy, are raised to 16
+var SignOnTokenBytes = 32
+
+// GenerateSignOnToken returns a URL safe magic link token read from crypto/rand. Callers of
+// SaveTokenSignOn should use it rather than generating their own tokens, so links can't be guessed
+func GenerateSignOnToken() (string, error) {
+	n := SignOnTokenBytes
+	if n < 16 {
+		n = 16
+	}
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// SaveTokenSignOn stores a magic link token for email. remember is the user's choice to stay
+// signed in for longer, CompleteSignOn hands it back once the link is followed
+func (r *Repository) SaveTokenSignOn(ctx context.Context, email, token, userType string, remember bool) error {
+	ctx, span := startSpan(ctx, "SaveTokenSignOn")
+	defer span.End()
+	if _, err := r.db.ExecContext(ctx, `INSERT INTO user_sign_on_token (token, email, user_type, remember, created_at) VALUES ($1, $2, $3, $4, NOW())`, token, strings.ToLower(strings.TrimSpace(email)), userType, remember); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (r *Repository) GetUser(user_id string) (*User, error) {
-	row := r.db.QueryRow(`SELECT id, email, created_at, user_type, email_verified, access_token, refresh_token, expiration_time FROM users where id = $1`, user_id)
-	var id, email, userType, accessToken, refreshToken sql.NullString
-	var createdAt, expirationTime sql.NullTime
+func (r *Repository) GetUser(ctx context.Context, user_id string) (*User, error) {
+	if r.cache != nil {
+		if u, ok := r.cache.get(user_id); ok {
+			u.Humanize()
+			return u, nil
+		}
+	}
+	ctx, span := startSpan(ctx, "GetUser")
+	defer span.End()
+	const query = `SELECT id, email, created_at, user_type, email_verified, access_token, refresh_token, expiration_time, preferred_currency, token_version, force_reauth_at FROM users where id = $1 AND deleted_at IS NULL`
+	var id, email, userType, accessToken, refreshToken, preferredCurrency sql.NullString
+	var createdAt, expirationTime, forceReauthAt sql.NullTime
 	var emailVerified sql.NullBool
-	err := row.Scan(&id, &email, &createdAt, &userType, &emailVerified, &accessToken, &refreshToken, &expirationTime)
+	var tokenVersion sql.NullInt64
+	db := r.reader(ctx)
+	err := db.QueryRowContext(ctx, query, user_id).Scan(&id, &email, &createdAt, &userType, &emailVerified, &accessToken, &refreshToken, &expirationTime, &preferredCurrency, &tokenVersion, &forceReauthAt)
+	if err == sql.ErrNoRows && db != r.db {
+		// the user may have just been created and not have reached the replica yet
+		err = r.db.QueryRowContext(ctx, query, user_id).Scan(&id, &email, &createdAt, &userType, &emailVerified, &accessToken, &refreshToken, &expirationTime, &preferredCurrency, &tokenVersion, &forceReauthAt)
+	}
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
-		return nil, err
+		return nil, r.observe(err)
 	}
 
-	return &User{
+	u := &User{
 		ID:            id.String,
 		Email:         email.String,
 		EmailVerified: emailVerified.Bool,
@@ -45,32 +201,81 @@ func (r *Repository) GetUser(user_id string) (*User, error) {
 		RefreshToken:  refreshToken.String,
 		CreatedAt:     createdAt.Time,
 		Type:          userType.String,
-	}, nil
+	}
+	u.PreferredCurrency = strings.TrimSpace(preferredCurrency.String)
+	u.TokenVersion = int(tokenVersion.Int64)
+	u.ForceReauthAt = forceReauthAt.Time
+	u.Humanize()
+	if r.cache != nil {
+		r.cache.set(*u)
+	}
+	return u, nil
 }
 
-func (r *Repository) CreateUser(u User) error {
-	_, err := r.db.Exec(
-		`INSERT INTO users (id, email, created_at, user_type, email_verified, access_token, refresh_token, expiration_time) 
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
-		u.ID, u.Email, u.CreatedAt, u.Type, u.EmailVerified, u.AccessToken, u.RefreshToken, u.ExpirationTime)
+func (r *Repository) CreateUser(ctx context.Context, u User) error {
+	_, err := r.CreateUserReturning(ctx, u)
 	return err
 }
 
-func (r *Repository) UpdateAccessToken(userId, accessToken string) error {
-	_, err := r.db.Exec(`UPDATE users SET access_token = $1 WHERE id = $2`, accessToken, userId)
+// CreateUserReturning inserts the user and returns it with the fields set by the
+// database. An empty ID is generated and a zero CreatedAt defaults to NOW()
+func (r *Repository) CreateUserReturning(ctx context.Context, u User) (User, error) {
+	ctx, span := startSpan(ctx, "CreateUser")
+	defer span.End()
+	if err := ValidateUser(u); err != nil {
+		return User{}, err
+	}
+	if u.ID == "" {
+		userID, err := ksuid.NewRandom()
+		if err != nil {
+			return User{}, err
+		}
+		u.ID = userID.String()
+	}
+	var createdAt sql.NullTime
+	if !u.CreatedAt.IsZero() {
+		createdAt = sql.NullTime{Time: u.CreatedAt, Valid: true}
+	}
+	row := r.db.QueryRowContext(
+		ctx,
+		`INSERT INTO users (id, email, created_at, user_type, email_verified, access_token, refresh_token, expiration_time) 
+		VALUES ($1, $2, COALESCE($3, NOW()), $4, $5, $6, $7, $8)
+		RETURNING id, created_at`,
+		u.ID, u.Email, createdAt, u.Type, u.EmailVerified, u.AccessToken, u.RefreshToken, u.ExpirationTime)
+	if err := row.Scan(&u.ID, &u.CreatedAt); err != nil {
+		return User{}, err
+	}
+	u.Humanize()
+	r.index.Upsert(userDocument(u))
+	r.userCreated(u)
+	return u, nil
+}
+
+func (r *Repository) UpdateAccessToken(ctx context.Context, userId, accessToken string) error {
+	ctx, span := startSpan(ctx, "UpdateAccessToken")
+	defer span.End()
+	_, err := r.db.ExecContext(ctx, `UPDATE users SET access_token = $1 WHERE id = $2`, accessToken, userId)
+	r.invalidate(userId)
 	return err
 }
 
-func (r *Repository) UpdateRefreshToken(userId, refreshToken string) error {
-	_, err := r.db.Exec(`UPDATE users SET refresh_token = $1 WHERE id = $2`, refreshToken, userId)
+func (r *Repository) UpdateRefreshToken(ctx context.Context, userId, refreshToken string) error {
+	ctx, span := startSpan(ctx, "UpdateRefreshToken")
+	defer span.End()
+	_, err := r.db.ExecContext(ctx, `UPDATE users SET refresh_token = $1 WHERE id = $2`, refreshToken, userId)
+	r.invalidate(userId)
 	return err
 }
 
 // GetOrCreateUserFromToken creates or get existing user given a token
 // returns the user struct, whether the user existed already and an error
-func (r *Repository) GetOrCreateUserFromToken(token string) (User, bool, error) {
+//
+// Deprecated: use CompleteSignOn, which also consumes the token and verifies the email
+func (r *Repository) GetOrCreateUserFromToken(ctx context.Context, token string) (User, bool, error) {
+	ctx, span := startSpan(ctx, "GetOrCreateUserFromToken")
+	defer span.End()
 	u := User{}
-	row := r.db.QueryRow(`SELECT id, email, created_at, user_type, email_verified, access_token, expiration_time FROM users where token = $1`, token)
+	row := r.db.QueryRowContext(ctx, `SELECT id, email, created_at, user_type, email_verified, access_token, expiration_time FROM users where token = $1`, token)
 	//row := r.db.QueryRow(`SELECT t.token, t.email, u.id, u.email, u.created_at, t.user_type
 	// FROM user_sign_on_token t LEFT JOIN users u ON t.email = u.email WHERE t.token = $1`, token)
 	var id, email, userType, accessToken sql.NullString
@@ -92,10 +297,12 @@ func (r *Repository) GetOrCreateUserFromToken(token string) (User, bool, error)
 		u.Email = accessToken.String
 		u.CreatedAt = time.Now()
 		u.Type = userType.String
-		u.CreatedAtHumanised = humanize.Time(u.CreatedAt.UTC())
-		if _, err := r.db.Exec(`INSERT INTO users (id, email, created_at, user_type) VALUES ($1, $2, $3, $4)`, u.ID, u.Email, u.CreatedAt, u.Type); err != nil {
+		u.Humanize()
+		if _, err := r.db.ExecContext(ctx, `INSERT INTO users (id, email, created_at, user_type) VALUES ($1, $2, $3, $4)`, u.ID, u.Email, u.CreatedAt, u.Type); err != nil {
 			return User{}, false, err
 		}
+		r.index.Upsert(userDocument(u))
+		r.userCreated(u)
 
 		return u, false, nil
 	}
@@ -103,42 +310,1093 @@ func (r *Repository) GetOrCreateUserFromToken(token string) (User, bool, error)
 	u.Email = email.String
 	u.CreatedAt = createdAt.Time
 	u.Type = userType.String
-	u.CreatedAtHumanised = humanize.Time(u.CreatedAt.UTC())
+	u.Humanize()
 
 	return u, true, nil
 }
 
-func (r *Repository) DeleteUserByEmail(email string) error {
-	_, err := r.db.Exec(`DELETE FROM users WHERE email = $1`, email)
-	return err
+func (r *Repository) DeleteUserByEmail(ctx context.Context, email string) error {
+	ctx, span := startSpan(ctx, "DeleteUserByEmail")
+	defer span.End()
+	rows, err := r.db.QueryContext(ctx, `DELETE FROM users WHERE email = $1 RETURNING id`, email)
+	if r.cache != nil {
+		r.cache.invalidateEmail(email)
+	}
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		r.index.Delete(userDocumentID(id))
+	}
+	return rows.Err()
 }
 
 // DeleteExpiredUserSignOnTokens deletes user_sign_on_tokens older than 1 week
-func (r *Repository) DeleteExpiredUserSignOnTokens() error {
-	_, err := r.db.Exec(`DELETE FROM user_sign_on_token WHERE created_at < NOW() - INTERVAL '7 DAYS'`)
-	return err
+func (r *Repository) DeleteExpiredUserSignOnTokens(ctx context.Context) error {
+	ctx, span := startSpan(ctx, "DeleteExpiredUserSignOnTokens")
+	defer span.End()
+	start := time.Now()
+	res, err := r.db.ExecContext(ctx, `DELETE FROM user_sign_on_token WHERE created_at < NOW() - INTERVAL '7 DAYS'`)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	r.recordPurge("user_sign_on_token", n, time.Since(start))
+	return nil
+}
+
+// ClearExpiredAccessTokens nulls the provider access and refresh tokens of users whose
+// expiration_time has passed, so a leaked table holds as few usable tokens as possible.
+// It returns the number of users cleared
+func (r *Repository) ClearExpiredAccessTokens(ctx context.Context) (int64, error) {
+	ctx, span := startSpan(ctx, "ClearExpiredAccessTokens")
+	defer span.End()
+	start := time.Now()
+	rows, err := r.db.QueryContext(ctx, `UPDATE users SET access_token = NULL, refresh_token = NULL
+		WHERE expiration_time < NOW() AND (access_token IS NOT NULL OR refresh_token IS NOT NULL)
+		RETURNING id`)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	var n int64
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return n, err
+		}
+		r.invalidate(id)
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+	r.recordPurge("users.access_token", n, time.Since(start))
+	return n, nil
+}
+
+// FindDuplicateEmails returns the users sharing an email once case is ignored, the leftovers
+// of inconsistent email handling at sign up. Merge them with MergeUsers
+func (r *Repository) FindDuplicateEmails(ctx context.Context) ([]DuplicateGroup, error) {
+	ctx, span := startSpan(ctx, "FindDuplicateEmails")
+	defer span.End()
+	rows, err := r.reader(ctx).QueryContext(ctx, `SELECT LOWER(email), id, email, created_at, user_type, email_verified
+		FROM users
+		WHERE deleted_at IS NULL AND LOWER(email) IN (
+			SELECT LOWER(email) FROM users WHERE deleted_at IS NULL GROUP BY LOWER(email) HAVING COUNT(*) > 1
+		)
+		ORDER BY LOWER(email), created_at ASC NULLS LAST, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var groups []DuplicateGroup
+	for rows.Next() {
+		var normalized string
+		var u User
+		var userType sql.NullString
+		var createdAt sql.NullTime
+		var emailVerified sql.NullBool
+		if err := rows.Scan(&normalized, &u.ID, &u.Email, &createdAt, &userType, &emailVerified); err != nil {
+			return nil, err
+		}
+		u.Type = userType.String
+		u.CreatedAt = createdAt.Time
+		u.EmailVerified = emailVerified.Bool
+		u.Humanize()
+		if len(groups) == 0 || groups[len(groups)-1].NormalizedEmail != normalized {
+			groups = append(groups, DuplicateGroup{NormalizedEmail: normalized})
+		}
+		groups[len(groups)-1].Users = append(groups[len(groups)-1].Users, u)
+	}
+	return groups, rows.Err()
+}
+
+// MergeUsers folds duplicateIDs into primaryID in a single transaction: flags, bookmarks, audit entries and
+// the profiles and jobs keyed by the duplicates' emails are moved to the primary user, which
+// keeps its own values on conflicts, and the duplicates are soft deleted. All the users must
+// exist and share the primary's email once case is ignored
+func (r *Repository) MergeUsers(ctx context.Context, primaryID string, duplicateIDs []string) error {
+	ctx, span := startSpan(ctx, "MergeUsers")
+	defer span.End()
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var primaryEmail string
+	err = tx.QueryRowContext(ctx, `SELECT email FROM users WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, primaryID).Scan(&primaryEmail)
+	if err == sql.ErrNoRows {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	var duplicateEmails []string
+	for _, id := range duplicateIDs {
+		if id == primaryID {
+			continue
+		}
+		var email string
+		err := tx.QueryRowContext(ctx, `SELECT email FROM users WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, id).Scan(&email)
+		if err == sql.ErrNoRows {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(email, primaryEmail) {
+			return fmt.Errorf("user %s has email %s, not a duplicate of %s", id, email, primaryEmail)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO user_flags (user_id, flag, enabled, updated_at)
+			SELECT $1, flag, enabled, updated_at FROM user_flags WHERE user_id = $2
+			ON CONFLICT (user_id, flag) DO NOTHING`, primaryID, id); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM user_flags WHERE user_id = $1`, id); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO job_bookmarks (user_id, job_id, created_at)
+			SELECT $1, job_id, created_at FROM job_bookmarks WHERE user_id = $2
+			ON CONFLICT (user_id, job_id) DO NOTHING`, primaryID, id); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM job_bookmarks WHERE user_id = $1`, id); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE user_audit_log SET user_id = $1 WHERE user_id = $2`, primaryID, id); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE user_identities SET user_id = $1 WHERE user_id = $2`, primaryID, id); err != nil {
+			return err
+		}
+		if err := insertAudit(ctx, tx, primaryID, "merge_user", id, primaryID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE users SET deleted_at = NOW(), token_version = token_version + 1 WHERE id = $1`, id); err != nil {
+			return err
+		}
+		if email != primaryEmail {
+			duplicateEmails = append(duplicateEmails, email)
+		}
+	}
+	// one email at a time so a profile moved for one duplicate is seen by the next
+	for _, email := range duplicateEmails {
+		for _, q := range []string{
+			`UPDATE developer_profile SET email = $1 WHERE email = $2 AND NOT EXISTS (SELECT 1 FROM developer_profile WHERE email = $1)`,
+			`UPDATE recruiter_profile SET email = $1 WHERE email = $2 AND NOT EXISTS (SELECT 1 FROM recruiter_profile WHERE email = $1)`,
+			`UPDATE job SET company_email = $1 WHERE company_email = $2`,
+		} {
+			if _, err := tx.ExecContext(ctx, q, primaryEmail, email); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM user_sign_on_token WHERE email = $1`, email); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	r.invalidate(primaryID)
+	for _, id := range duplicateIDs {
+		if id == primaryID {
+			continue
+		}
+		r.invalidate(id)
+		r.index.Delete(userDocumentID(id))
+	}
+	return nil
+}
+
+// RunMaintenance purges the expired ephemeral rows selected by opts, one table after the other.
+// It stops at the first failure, the report then covers the tables purged so far
+func (r *Repository) RunMaintenance(ctx context.Context, opts MaintenanceOptions) (MaintenanceReport, error) {
+	ctx, span := startSpan(ctx, "RunMaintenance")
+	defer span.End()
+	report := MaintenanceReport{Deleted: make(map[string]int64), Duration: make(map[string]time.Duration)}
+	purges := []struct {
+		enabled bool
+		table   string
+		query   string
+		args    []interface{}
+	}{
+		{opts.SignOnTokens, "user_sign_on_token", `DELETE FROM user_sign_on_token WHERE created_at < $1`, []interface{}{time.Now().Add(-SignOnTokenTTL)}},
+		{opts.AccountDeletionTokens, "account_deletion_token", `DELETE FROM account_deletion_token WHERE created_at < $1`, []interface{}{time.Now().Add(-AccountDeletionTokenTTL)}},
+		{opts.Sessions, "sessions", `DELETE FROM sessions WHERE expires_at < NOW()`, nil},
+	}
+	for _, p := range purges {
+		if !p.enabled {
+			continue
+		}
+		start := time.Now()
+		res, err := r.db.ExecContext(ctx, p.query, p.args...)
+		if err != nil {
+			return report, fmt.Errorf("unable to purge %s: %w", p.table, err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return report, err
+		}
+		took := time.Since(start)
+		report.Deleted[p.table] = n
+		report.Duration[p.table] = took
+		r.recordPurge(p.table, n, took)
+	}
+	if opts.AccessTokens {
+		start := time.Now()
+		n, err := r.ClearExpiredAccessTokens(ctx)
+		if err != nil {
+			return report, fmt.Errorf("unable to clear expired access tokens: %w", err)
+		}
+		report.Deleted["users.access_token"] = n
+		report.Duration["users.access_token"] = time.Since(start)
+	}
+	return report, nil
+}
+
+// AccountDeletionTokenTTL is how long the link confirming an account deletion works for
+const AccountDeletionTokenTTL = time.Hour
+
+var (
+	ErrAccountDeletionTokenInvalid = errors.New("account deletion token is invalid")
+	ErrAccountDeletionTokenExpired = errors.New("account deletion token has expired")
+)
+
+// RequestAccountDeletion starts the deletion of the user's account and returns the token to
+// send to them by email. Nothing is deleted until the token comes back through ConfirmAccountDeletion
+func (r *Repository) RequestAccountDeletion(ctx context.Context, userID string) (string, error) {
+	ctx, span := startSpan(ctx, "RequestAccountDeletion")
+	defer span.End()
+	token, err := GenerateSignOnToken()
+	if err != nil {
+		return "", err
+	}
+	res, err := r.db.ExecContext(ctx, `INSERT INTO account_deletion_token (token, user_id, created_at)
+		SELECT $1, id, NOW() FROM users WHERE id = $2 AND deleted_at IS NULL`, token, userID)
+	if err != nil {
+		return "", err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		if err != nil {
+			return "", err
+		}
+		return "", ErrUserNotFound
+	}
+	return token, nil
+}
+
+// ConfirmAccountDeletion consumes token and soft deletes the account it was issued for,
+// signing the user out everywhere. Tokens can only be used once
+func (r *Repository) ConfirmAccountDeletion(ctx context.Context, token string) error {
+	ctx, span := startSpan(ctx, "ConfirmAccountDeletion")
+	defer span.End()
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var userID string
+	var createdAt time.Time
+	err = tx.QueryRowContext(ctx, `DELETE FROM account_deletion_token WHERE token = $1 RETURNING user_id, created_at`, token).Scan(&userID, &createdAt)
+	if err == sql.ErrNoRows {
+		return ErrAccountDeletionTokenInvalid
+	}
+	if err != nil {
+		return err
+	}
+	if time.Since(createdAt) > AccountDeletionTokenTTL {
+		// keep the deletion of the stale token
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		return ErrAccountDeletionTokenExpired
+	}
+	var email string
+	err = tx.QueryRowContext(ctx, `UPDATE users SET deleted_at = NOW(), token_version = token_version + 1 WHERE id = $1 AND deleted_at IS NULL RETURNING email`, userID).Scan(&email)
+	if err == sql.ErrNoRows {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM account_deletion_token WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	// the developer profile and its image go with the account
+	if _, err := tx.ExecContext(ctx, `WITH deleted AS (DELETE FROM developer_profile WHERE email = $1 RETURNING image_id)
+		DELETE FROM image WHERE id IN (SELECT image_id FROM deleted)`, email); err != nil {
+		return err
+	}
+	if err := insertAudit(ctx, tx, userID, "delete_account", "", ""); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	r.invalidate(userID)
+	r.index.Delete(userDocumentID(userID))
+	return nil
+}
+
+// ErrUnsupportedCurrency is returned by SetPreferredCurrency for codes missing from currency.Supported
+var ErrUnsupportedCurrency = errors.New("unsupported currency")
+
+// SetPreferredCurrency sets the ISO 4217 currency salaries are shown in for the user.
+// An empty code clears the preference, showing every job in its own currency again
+func (r *Repository) SetPreferredCurrency(ctx context.Context, userID, code string) error {
+	ctx, span := startSpan(ctx, "SetPreferredCurrency")
+	defer span.End()
+	code = strings.ToUpper(strings.TrimSpace(code))
+	var preferred sql.NullString
+	if code != "" {
+		if !currency.IsSupported(code) {
+			return ErrUnsupportedCurrency
+		}
+		preferred = sql.NullString{String: code, Valid: true}
+	}
+	if _, err := r.db.ExecContext(ctx, `UPDATE users SET preferred_currency = $1 WHERE id = $2`, preferred, userID); err != nil {
+		return err
+	}
+	r.invalidate(userID)
+	return nil
+}
+
+var (
+	ErrUserNotFound    = errors.New("user not found")
+	ErrInvalidUserType = errors.New("invalid user type")
+)
+
+// ChangeUserType switches the user between developer and recruiter, e.g. to promote a developer
+// who started hiring. The change is recorded in user_audit_log and the token version is bumped
+// so session jwts issued for the old role stop being accepted, see middleware.TokenVersion
+func (r *Repository) ChangeUserType(ctx context.Context, userID, newType string) error {
+	ctx, span := startSpan(ctx, "ChangeUserType")
+	defer span.End()
+	if newType != UserTypeDeveloper && newType != UserTypeRecruiter {
+		return ErrInvalidUserType
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var oldType sql.NullString
+	err = tx.QueryRowContext(ctx, `SELECT user_type FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&oldType)
+	if err == sql.ErrNoRows {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if oldType.String == newType {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET user_type = $1, token_version = token_version + 1 WHERE id = $2`, newType, userID); err != nil {
+		return err
+	}
+	if err := insertAudit(ctx, tx, userID, "change_user_type", oldType.String, newType); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	r.invalidate(userID)
+	r.index.Upsert(search.Document{ID: userDocumentID(userID), Type: "user", Fields: map[string]interface{}{"user_type": newType}})
+	return nil
 }
 
-func (r *Repository) GetUserTypeByEmail(email string) (string, error) {
+// TokenVersion returns the current token version of the user, for middleware.TokenVersion.
+// It reads the primary and skips the cache, so a session revoked a moment ago isn't accepted
+// again from a lagging replica or a stale cache entry
+func (r *Repository) TokenVersion(ctx context.Context, userID string) (int, error) {
+	ctx, span := startSpan(ctx, "TokenVersion")
+	defer span.End()
+	var version int
+	err := r.db.QueryRowContext(ctx, `SELECT token_version FROM users WHERE id = $1 AND deleted_at IS NULL`, userID).Scan(&version)
+	if err == sql.ErrNoRows {
+		return 0, ErrUserNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// ForceReauth makes every session of the user issued from now on the only valid ones, e.g.
+// after their credentials leaked. They are asked to sign in again on their next request
+func (r *Repository) ForceReauth(ctx context.Context, userID string) error {
+	ctx, span := startSpan(ctx, "ForceReauth")
+	defer span.End()
+	res, err := r.db.ExecContext(ctx, `UPDATE users SET force_reauth_at = NOW() WHERE id = $1 AND deleted_at IS NULL`, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		if err != nil {
+			return err
+		}
+		return ErrUserNotFound
+	}
+	if err := insertAudit(ctx, r.db, userID, "force_reauth", "", ""); err != nil {
+		return err
+	}
+	r.invalidate(userID)
+	return nil
+}
+
+// ForceReauthAt returns when ForceReauth was last called for the user, the zero time if never,
+// for middleware.ForceReauthAt. Like TokenVersion it reads the primary and skips the cache
+func (r *Repository) ForceReauthAt(ctx context.Context, userID string) (time.Time, error) {
+	ctx, span := startSpan(ctx, "ForceReauthAt")
+	defer span.End()
+	var forceReauthAt sql.NullTime
+	err := r.db.QueryRowContext(ctx, `SELECT force_reauth_at FROM users WHERE id = $1 AND deleted_at IS NULL`, userID).Scan(&forceReauthAt)
+	if err == sql.ErrNoRows {
+		return time.Time{}, ErrUserNotFound
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return forceReauthAt.Time, nil
+}
+
+// ErrIdentityLinked is returned by LinkIdentity when the provider id already belongs to another user
+var ErrIdentityLinked = errors.New("identity is linked to another user")
+
+// GetUserByProviderID returns the user linked to externalID at provider, or nil if there is none
+func (r *Repository) GetUserByProviderID(ctx context.Context, provider, externalID string) (*User, error) {
+	ctx, span := startSpan(ctx, "GetUserByProviderID")
+	defer span.End()
+	var userID string
+	err := r.db.QueryRowContext(ctx, `SELECT user_id FROM user_identities WHERE provider = $1 AND provider_user_id = $2`, provider, externalID).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, r.observe(err)
+	}
+	return r.GetUser(ctx, userID)
+}
+
+// LinkIdentity records that provider knows the user as externalID, so signing in through any
+// linked provider finds the same account instead of creating a duplicate. Linking an identity
+// twice to the same user is a no-op
+func (r *Repository) LinkIdentity(ctx context.Context, userID, provider, externalID string) error {
+	ctx, span := startSpan(ctx, "LinkIdentity")
+	defer span.End()
+	var owner string
+	err := r.db.QueryRowContext(ctx, `INSERT INTO user_identities (user_id, provider, provider_user_id, created_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (provider, provider_user_id) DO UPDATE SET provider = EXCLUDED.provider
+		RETURNING user_id`, userID, provider, externalID).Scan(&owner)
+	if err != nil {
+		return err
+	}
+	if owner != userID {
+		return ErrIdentityLinked
+	}
+	return nil
+}
+
+func (r *Repository) GetUserTypeByEmail(ctx context.Context, email string) (string, error) {
+	ctx, span := startSpan(ctx, "GetUserTypeByEmail")
+	defer span.End()
 	var userType string
-	row := r.db.QueryRow(`SELECT user_type FROM users WHERE email = $1`, email)
+	row := r.reader(ctx).QueryRowContext(ctx, `SELECT user_type FROM users WHERE LOWER(email) = LOWER($1) AND deleted_at IS NULL ORDER BY created_at ASC NULLS LAST, id LIMIT 1`, email)
 	err := row.Scan(&userType)
 	if err == sql.ErrNoRows {
 		// check if user is unverified recruiter/developer
-		row = r.db.QueryRow(`SELECT 'recruiter' FROM recruiter_profile WHERE email = $1`, email)
-		err = row.Scan(&userType)
-		if err == nil {
-			return userType, nil
-		}
-		row = r.db.QueryRow(`SELECT 'developer' FROM developer_profile WHERE email = $1`, email)
-		err = row.Scan(&userType)
-		if err == nil {
-			return userType, nil
+		if r.profiles != nil {
+			if _, err := r.profiles.GetRecruiterProfile(ctx, email); err == nil {
+				return "recruiter", nil
+			} else if err != sql.ErrNoRows {
+				return "", err
+			}
+			if _, err := r.profiles.GetDeveloperProfile(ctx, email); err == nil {
+				return "developer", nil
+			} else if err != sql.ErrNoRows {
+				return "", err
+			}
 		}
-		return userType, err
+		return "", sql.ErrNoRows
 	}
 	if err != nil {
 		return userType, err
 	}
 	return userType, nil
 }
+
+// GetUserFlags returns the feature flags set for the user. Flags that were never set are absent, i.e. off
+func (r *Repository) GetUserFlags(ctx context.Context, userID string) (map[string]bool, error) {
+	ctx, span := startSpan(ctx, "GetUserFlags")
+	defer span.End()
+	flags := make(map[string]bool)
+	rows, err := r.db.QueryContext(ctx, `SELECT flag, enabled FROM user_flags WHERE user_id = $1`, userID)
+	if err != nil {
+		return flags, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var flag string
+		var enabled bool
+		if err := rows.Scan(&flag, &enabled); err != nil {
+			return flags, err
+		}
+		flags[flag] = enabled
+	}
+	return flags, rows.Err()
+}
+
+func (r *Repository) SetUserFlag(ctx context.Context, userID, flag string, on bool) error {
+	ctx, span := startSpan(ctx, "SetUserFlag")
+	defer span.End()
+	_, err := r.db.ExecContext(
+		ctx,
+		`INSERT INTO user_flags (user_id, flag, enabled, updated_at) VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (user_id, flag) DO UPDATE SET enabled = EXCLUDED.enabled, updated_at = NOW()`,
+		userID, flag, on)
+	return err
+}
+
+// SaveJobBookmark bookmarks the job with external ID jobID for the user, bookmarking it again is a no-op
+func (r *Repository) SaveJobBookmark(ctx context.Context, userID, jobID string) error {
+	ctx, span := startSpan(ctx, "SaveJobBookmark")
+	defer span.End()
+	_, err := r.db.ExecContext(
+		ctx,
+		`INSERT INTO job_bookmarks (user_id, job_id, created_at) VALUES ($1, $2, NOW())
+		ON CONFLICT (user_id, job_id) DO NOTHING`,
+		userID, jobID)
+	return err
+}
+
+func (r *Repository) RemoveJobBookmark(ctx context.Context, userID, jobID string) error {
+	ctx, span := startSpan(ctx, "RemoveJobBookmark")
+	defer span.End()
+	_, err := r.db.ExecContext(ctx, `DELETE FROM job_bookmarks WHERE user_id = $1 AND job_id = $2`, userID, jobID)
+	return err
+}
+
+// ListBookmarkedJobIDs returns the external IDs of the jobs the user bookmarked, latest first
+func (r *Repository) ListBookmarkedJobIDs(ctx context.Context, userID string) ([]string, error) {
+	ctx, span := startSpan(ctx, "ListBookmarkedJobIDs")
+	defer span.End()
+	rows, err := r.db.QueryContext(ctx, `SELECT job_id FROM job_bookmarks WHERE user_id = $1 ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// SetEmailVerifiedByEmails sets email_verified for every user matching one of emails in a
+// single statement and returns the number of users updated
+func (r *Repository) SetEmailVerifiedByEmails(ctx context.Context, emails []string, verified bool) (int64, error) {
+	ctx, span := startSpan(ctx, "SetEmailVerifiedByEmails")
+	defer span.End()
+	normalized := make([]string, 0, len(emails))
+	seen := make(map[string]bool, len(emails))
+	for _, e := range emails {
+		e = strings.ToLower(strings.TrimSpace(e))
+		if e == "" || seen[e] {
+			continue
+		}
+		seen[e] = true
+		normalized = append(normalized, e)
+	}
+	if len(normalized) == 0 {
+		return 0, nil
+	}
+	rows, err := r.db.QueryContext(ctx, `UPDATE users SET email_verified = $1 WHERE LOWER(email) = ANY($2) RETURNING id`, verified, pq.Array(normalized))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	if r.cache != nil {
+		for _, e := range normalized {
+			r.cache.invalidateEmail(e)
+		}
+	}
+	var updated int64
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return updated, err
+		}
+		r.index.Upsert(search.Document{ID: userDocumentID(id), Type: "user", Fields: map[string]interface{}{"email_verified": verified}})
+		updated++
+	}
+	return updated, rows.Err()
+}
+
+// SyncEmailVerifiedFromProvider sets email_verified of userID to verified, as reported by the
+// auth provider. The row is only written when the stored flag differs
+func (r *Repository) SyncEmailVerifiedFromProvider(ctx context.Context, userID string, verified bool) error {
+	ctx, span := startSpan(ctx, "SyncEmailVerifiedFromProvider")
+	defer span.End()
+	res, err := r.db.ExecContext(ctx, `UPDATE users SET email_verified = $1 WHERE id = $2 AND email_verified IS DISTINCT FROM $1`, verified, userID)
+	if err != nil {
+		return err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil || updated == 0 {
+		return err
+	}
+	if r.cache != nil {
+		r.cache.invalidate(userID)
+	}
+	r.index.Upsert(search.Document{ID: userDocumentID(userID), Type: "user", Fields: map[string]interface{}{"email_verified": verified}})
+	return nil
+}
+
+// SignupsByDay returns the number of users created on each day from from to to, both
+// included, in date order. Days without signups are returned with a zero count
+func (r *Repository) SignupsByDay(ctx context.Context, from, to time.Time) ([]DayCount, error) {
+	ctx, span := startSpan(ctx, "SignupsByDay")
+	defer span.End()
+	rows, err := r.reader(ctx).QueryContext(
+		ctx,
+		`SELECT d::date, COUNT(u.id)
+		FROM generate_series($1::date, $2::date, '1 day') AS d
+		LEFT JOIN users u ON u.created_at::date = d::date
+		GROUP BY d
+		ORDER BY d`,
+		from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	days := []DayCount{}
+	for rows.Next() {
+		var day DayCount
+		if err := rows.Scan(&day.Date, &day.Count); err != nil {
+			return nil, err
+		}
+		days = append(days, day)
+	}
+	return days, rows.Err()
+}
+
+// ListUsers returns the users matching f, newest first
+func (r *Repository) ListUsers(ctx context.Context, f ListFilter) ([]User, error) {
+	users := []User{}
+	err := r.EachUser(ctx, f, func(u User) error {
+		users = append(users, u)
+		return nil
+	})
+	return users, err
+}
+
+// EachUser calls fn for every user matching f, newest first, as rows are read so callers
+// can stream large result sets. Iteration stops at the first error returned by fn
+func (r *Repository) EachUser(ctx context.Context, f ListFilter, fn func(User) error) error {
+	ctx, span := startSpan(ctx, "EachUser")
+	defer span.End()
+	conditions := []string{"deleted_at IS NULL"}
+	args := []interface{}{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	if f.Type != "" {
+		conditions = append(conditions, "user_type = "+arg(f.Type))
+	}
+	if f.EmailVerified != nil {
+		conditions = append(conditions, "email_verified = "+arg(*f.EmailVerified))
+	}
+	if f.Email != "" {
+		conditions = append(conditions, "email ILIKE '%' || "+arg(f.Email)+" || '%'")
+	}
+	if !f.CreatedAfter.IsZero() {
+		conditions = append(conditions, "created_at >= "+arg(f.CreatedAfter))
+	}
+	if !f.CreatedBefore.IsZero() {
+		conditions = append(conditions, "created_at < "+arg(f.CreatedBefore))
+	}
+	rows, err := r.reader(ctx).QueryContext(ctx, `SELECT id, email, created_at, user_type, email_verified FROM users WHERE `+strings.Join(conditions, " AND ")+` ORDER BY created_at DESC`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var u User
+		var email, userType sql.NullString
+		var createdAt sql.NullTime
+		var emailVerified sql.NullBool
+		if err := rows.Scan(&u.ID, &email, &createdAt, &userType, &emailVerified); err != nil {
+			return err
+		}
+		u.Email = email.String
+		u.CreatedAt = createdAt.Time
+		u.Type = userType.String
+		u.EmailVerified = emailVerified.Bool
+		u.Humanize()
+		if err := fn(u); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ListIncompleteUsers returns the users created more than olderThan ago who never verified
+// their email nor created a developer or recruiter profile, oldest first, for re-engagement emails
+func (r *Repository) ListIncompleteUsers(ctx context.Context, olderThan time.Duration) ([]User, error) {
+	ctx, span := startSpan(ctx, "ListIncompleteUsers")
+	defer span.End()
+	users := []User{}
+	rows, err := r.reader(ctx).QueryContext(ctx, `SELECT u.id, u.email, u.created_at, u.user_type, u.email_verified
+		FROM users u
+		LEFT JOIN developer_profile dp ON dp.email = u.email
+		LEFT JOIN recruiter_profile rp ON rp.email = u.email
+		WHERE u.deleted_at IS NULL
+			AND u.email_verified IS NOT TRUE
+			AND u.created_at < $1
+			AND dp.id IS NULL
+			AND rp.id IS NULL
+		ORDER BY u.created_at ASC`, time.Now().Add(-olderThan))
+	if err != nil {
+		return users, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var u User
+		var userType sql.NullString
+		var createdAt sql.NullTime
+		var emailVerified sql.NullBool
+		if err := rows.Scan(&u.ID, &u.Email, &createdAt, &userType, &emailVerified); err != nil {
+			return users, err
+		}
+		u.CreatedAt = createdAt.Time
+		u.Type = userType.String
+		u.EmailVerified = emailVerified.Bool
+		u.Humanize()
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// MaxUserSearchResults caps the limit of SearchUsersByEmail
+const MaxUserSearchResults = 50
+
+// likeEscaper escapes the LIKE wildcards so search terms match literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// SearchUsersByEmail returns up to limit users whose email contains term, case insensitively,
+// for the admin type-ahead. Exact matches come first, then emails starting with term, then
+// the rest, newest first within each group. The users_email_trgm_idx trigram index keeps
+// the substring match fast
+func (r *Repository) SearchUsersByEmail(ctx context.Context, term string, limit int) ([]User, error) {
+	ctx, span := startSpan(ctx, "SearchUsersByEmail")
+	defer span.End()
+	users := []User{}
+	term = strings.ToLower(strings.TrimSpace(term))
+	if term == "" {
+		return users, nil
+	}
+	if limit <= 0 || limit > MaxUserSearchResults {
+		limit = MaxUserSearchResults
+	}
+	rows, err := r.reader(ctx).QueryContext(ctx, `SELECT id, email, created_at, user_type, email_verified
+		FROM users
+		WHERE deleted_at IS NULL AND email ILIKE '%' || $1 || '%'
+		ORDER BY
+			CASE WHEN LOWER(email) = $2 THEN 0 WHEN LOWER(email) LIKE $1 || '%' THEN 1 ELSE 2 END,
+			created_at DESC NULLS LAST
+		LIMIT $3`, likeEscaper.Replace(term), term, limit)
+	if err != nil {
+		return users, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var u User
+		var userType sql.NullString
+		var createdAt sql.NullTime
+		var emailVerified sql.NullBool
+		if err := rows.Scan(&u.ID, &u.Email, &createdAt, &userType, &emailVerified); err != nil {
+			return users, err
+		}
+		u.CreatedAt = createdAt.Time
+		u.Type = userType.String
+		u.EmailVerified = emailVerified.Bool
+		u.Humanize()
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// MaxRecentUsers caps the limit of RecentUsers
+const MaxRecentUsers = 100
+
+// RecentUsers returns the last limit users to sign up, newest first, for the admin feed.
+// The users_created_at_id_idx index serves the ordering without scanning the table
+func (r *Repository) RecentUsers(ctx context.Context, limit int) ([]User, error) {
+	ctx, span := startSpan(ctx, "RecentUsers")
+	defer span.End()
+	if limit <= 0 || limit > MaxRecentUsers {
+		limit = MaxRecentUsers
+	}
+	users := []User{}
+	rows, err := r.reader(ctx).QueryContext(ctx, `SELECT id, email, created_at, user_type, email_verified
+		FROM users
+		WHERE deleted_at IS NULL
+		ORDER BY created_at DESC, id DESC
+		LIMIT $1`, limit)
+	if err != nil {
+		return users, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var u User
+		var userType sql.NullString
+		var createdAt sql.NullTime
+		var emailVerified sql.NullBool
+		if err := rows.Scan(&u.ID, &u.Email, &createdAt, &userType, &emailVerified); err != nil {
+			return users, err
+		}
+		u.CreatedAt = createdAt.Time
+		u.Type = userType.String
+		u.EmailVerified = emailVerified.Bool
+		u.Humanize()
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// ErrInvalidCursor is returned by ListUsersAfter for cursors it didn't produce
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ListUsersAfter returns up to limit users, newest first, following cursor. An empty cursor
+// starts from the newest user. The returned cursor is opaque and empty once there are no
+// more users. Unlike offsets, seeking with (created_at, id) stays fast for deep pages
+func (r *Repository) ListUsersAfter(ctx context.Context, cursor string, limit int) ([]User, string, error) {
+	ctx, span := startSpan(ctx, "ListUsersAfter")
+	defer span.End()
+	users := []User{}
+	if limit <= 0 {
+		limit = 50
+	}
+	var rows *sql.Rows
+	var err error
+	if cursor == "" {
+		rows, err = r.reader(ctx).QueryContext(ctx, `SELECT id, email, created_at, user_type, email_verified FROM users WHERE created_at IS NOT NULL AND deleted_at IS NULL ORDER BY created_at DESC, id DESC LIMIT $1`, limit+1)
+	} else {
+		createdAt, id, decodeErr := decodeUserCursor(cursor)
+		if decodeErr != nil {
+			return users, "", decodeErr
+		}
+		rows, err = r.reader(ctx).QueryContext(ctx, `SELECT id, email, created_at, user_type, email_verified FROM users WHERE (created_at, id) < ($1, $2) AND deleted_at IS NULL ORDER BY created_at DESC, id DESC LIMIT $3`, createdAt, id, limit+1)
+	}
+	if err != nil {
+		return users, "", err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var u User
+		var userType sql.NullString
+		var createdAt sql.NullTime
+		var emailVerified sql.NullBool
+		if err := rows.Scan(&u.ID, &u.Email, &createdAt, &userType, &emailVerified); err != nil {
+			return users, "", err
+		}
+		u.CreatedAt = createdAt.Time
+		u.Type = userType.String
+		u.EmailVerified = emailVerified.Bool
+		u.Humanize()
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return users, "", err
+	}
+	// one extra row was fetched to know whether there is a next page
+	if len(users) <= limit {
+		return users, "", nil
+	}
+	users = users[:limit]
+	last := users[len(users)-1]
+	return users, encodeUserCursor(last.CreatedAt, last.ID), nil
+}
+
+func encodeUserCursor(createdAt time.Time, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(createdAt.Format(time.RFC3339Nano) + "|" + id))
+}
+
+func decodeUserCursor(cursor string) (time.Time, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	return createdAt, parts[1], nil
+}
+
+var (
+	ErrSignOnTokenInvalid = errors.New("sign on token is invalid")
+	ErrSignOnTokenExpired = errors.New("sign on token has expired")
+)
+
+// SignOnTokenTTL is how long a magic link sign on token can be used for
+const SignOnTokenTTL = 7 * 24 * time.Hour
+
+// ErrSignOnTokenNotFound is returned by RevokeSignOnToken when the user has no such pending token
+var ErrSignOnTokenNotFound = errors.New("sign on token not found")
+
+// SignOnTokenID identifies a sign on token, it is the hex encoded SHA-256 of the token so it
+// can be shown to the user and used to revoke the token but not to sign in
+func SignOnTokenID(token string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(token)))
+	return hex.EncodeToString(sum[:])
+}
+
+// ListActiveSignOnTokens returns the magic links issued for email that can still be used, newest first
+func (r *Repository) ListActiveSignOnTokens(ctx context.Context, email string) ([]SignOnTokenInfo, error) {
+	ctx, span := startSpan(ctx, "ListActiveSignOnTokens")
+	defer span.End()
+	rows, err := r.db.QueryContext(ctx, `SELECT token, user_type, created_at FROM user_sign_on_token WHERE LOWER(email) = LOWER($1) AND created_at > $2 ORDER BY created_at DESC`, email, time.Now().Add(-SignOnTokenTTL))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tokens := []SignOnTokenInfo{}
+	for rows.Next() {
+		var token string
+		var userType sql.NullString
+		var createdAt time.Time
+		if err := rows.Scan(&token, &userType, &createdAt); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, SignOnTokenInfo{
+			ID:        SignOnTokenID(token),
+			UserType:  userType.String,
+			CreatedAt: createdAt,
+			ExpiresAt: createdAt.Add(SignOnTokenTTL),
+		})
+	}
+	return tokens, rows.Err()
+}
+
+// RevokeSignOnToken deletes the magic link of email identified by tokenID, see SignOnTokenID
+func (r *Repository) RevokeSignOnToken(ctx context.Context, email, tokenID string) error {
+	ctx, span := startSpan(ctx, "RevokeSignOnToken")
+	defer span.End()
+	rows, err := r.db.QueryContext(ctx, `SELECT token FROM user_sign_on_token WHERE LOWER(email) = LOWER($1)`, email)
+	if err != nil {
+		return err
+	}
+	var match string
+	for rows.Next() {
+		var token string
+		if err := rows.Scan(&token); err != nil {
+			rows.Close()
+			return err
+		}
+		if subtle.ConstantTimeCompare([]byte(SignOnTokenID(token)), []byte(tokenID)) == 1 {
+			match = token
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if match == "" {
+		return ErrSignOnTokenNotFound
+	}
+	_, err = r.db.ExecContext(ctx, `DELETE FROM user_sign_on_token WHERE token = $1`, match)
+	return err
+}
+
+// CompleteSignOn consumes a magic link sign on token in a single transaction: the token is
+// checked and deleted, and the user it was issued for is created if needed and marked as
+// email verified. It returns the user, whether it was created by this call and whether the
+// user asked to be remembered when requesting the link
+func (r *Repository) CompleteSignOn(ctx context.Context, token string) (User, bool, bool, error) {
+	ctx, span := startSpan(ctx, "CompleteSignOn")
+	defer span.End()
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return User{}, false, false, err
+	}
+	defer tx.Rollback()
+
+	var email, tokenUserType string
+	var remember bool
+	var tokenCreatedAt time.Time
+	err = tx.QueryRowContext(ctx, `DELETE FROM user_sign_on_token WHERE token = $1 RETURNING email, user_type, remember, created_at`, token).Scan(&email, &tokenUserType, &remember, &tokenCreatedAt)
+	if err == sql.ErrNoRows {
+		return User{}, false, false, ErrSignOnTokenInvalid
+	}
+	if err != nil {
+		return User{}, false, false, err
+	}
+	if time.Since(tokenCreatedAt) > SignOnTokenTTL {
+		// keep the deletion of the stale token
+		if err := tx.Commit(); err != nil {
+			return User{}, false, false, err
+		}
+		return User{}, false, false, ErrSignOnTokenExpired
+	}
+
+	u := User{Email: strings.ToLower(strings.TrimSpace(email))}
+	var userType sql.NullString
+	var tokenVersion sql.NullInt64
+	// case duplicates not merged yet resolve to the oldest account, the one MergeUsers keeps
+	err = tx.QueryRowContext(ctx, `UPDATE users SET email_verified = true WHERE id = (
+			SELECT id FROM users WHERE LOWER(email) = $1 AND deleted_at IS NULL ORDER BY created_at ASC NULLS LAST, id LIMIT 1
+		) RETURNING id, email, created_at, user_type, token_version`, u.Email).Scan(&u.ID, &u.Email, &u.CreatedAt, &userType, &tokenVersion)
+	isNew := err == sql.ErrNoRows
+	switch {
+	case isNew:
+		userID, err := ksuid.NewRandom()
+		if err != nil {
+			return User{}, false, false, err
+		}
+		u.ID = userID.String()
+		u.Type = tokenUserType
+		u.CreatedAt = time.Now().UTC()
+		if err := ValidateUser(u); err != nil {
+			return User{}, false, false, err
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO users (id, email, created_at, user_type, email_verified) VALUES ($1, $2, $3, $4, true)`, u.ID, u.Email, u.CreatedAt, u.Type); err != nil {
+			return User{}, false, false, err
+		}
+	case err != nil:
+		return User{}, false, false, err
+	default:
+		u.Type = userType.String
+		u.TokenVersion = int(tokenVersion.Int64)
+	}
+	if err := tx.Commit(); err != nil {
+		return User{}, false, false, err
+	}
+	u.EmailVerified = true
+	u.Humanize()
+	r.invalidate(u.ID)
+	r.index.Upsert(userDocument(u))
+	if isNew {
+		r.userCreated(u)
+	}
+	return u, isNew, remember, nil
+}
@@ -0,0 +1,63 @@
+package user
+
+import (
+	"context"
+	"time"
+)
+
+// UserStore is what handlers and middlewares need from the user storage. Repository is the
+// postgres implementation, MemStore an in-memory one for tests and local tools
+type UserStore interface {
+	Healthy() bool
+
+	GetUser(ctx context.Context, userID string) (*User, error)
+	GetUserTypeByEmail(ctx context.Context, email string) (string, error)
+	GetUserByProviderID(ctx context.Context, provider, externalID string) (*User, error)
+	CreateUser(ctx context.Context, u User) error
+	CreateUserReturning(ctx context.Context, u User) (User, error)
+	UpdateAccessToken(ctx context.Context, userID, accessToken string) error
+	UpdateRefreshToken(ctx context.Context, userID, refreshToken string) error
+	DeleteUserByEmail(ctx context.Context, email string) error
+	SetPreferredCurrency(ctx context.Context, userID, code string) error
+	ChangeUserType(ctx context.Context, userID, newType string) error
+	TokenVersion(ctx context.Context, userID string) (int, error)
+	ForceReauth(ctx context.Context, userID string) error
+	ForceReauthAt(ctx context.Context, userID string) (time.Time, error)
+	RecordAudit(ctx context.Context, userID, action, oldValue, newValue string) error
+	LinkIdentity(ctx context.Context, userID, provider, externalID string) error
+	SetEmailVerifiedByEmails(ctx context.Context, emails []string, verified bool) (int64, error)
+	SyncEmailVerifiedFromProvider(ctx context.Context, userID string, verified bool) error
+
+	SaveTokenSignOn(ctx context.Context, email, token, userType string, remember bool) error
+	CompleteSignOn(ctx context.Context, token string) (User, bool, bool, error)
+	GetOrCreateUserFromToken(ctx context.Context, token string) (User, bool, error)
+	ListActiveSignOnTokens(ctx context.Context, email string) ([]SignOnTokenInfo, error)
+	RevokeSignOnToken(ctx context.Context, email, tokenID string) error
+	DeleteExpiredUserSignOnTokens(ctx context.Context) error
+	ClearExpiredAccessTokens(ctx context.Context) (int64, error)
+
+	RequestAccountDeletion(ctx context.Context, userID string) (string, error)
+	ConfirmAccountDeletion(ctx context.Context, token string) error
+
+	GetUserFlags(ctx context.Context, userID string) (map[string]bool, error)
+	SetUserFlag(ctx context.Context, userID, flag string, on bool) error
+	SaveJobBookmark(ctx context.Context, userID, jobID string) error
+	RemoveJobBookmark(ctx context.Context, userID, jobID string) error
+	ListBookmarkedJobIDs(ctx context.Context, userID string) ([]string, error)
+
+	ListUsers(ctx context.Context, f ListFilter) ([]User, error)
+	EachUser(ctx context.Context, f ListFilter, fn func(User) error) error
+	ListUsersAfter(ctx context.Context, cursor string, limit int) ([]User, string, error)
+	ListIncompleteUsers(ctx context.Context, olderThan time.Duration) ([]User, error)
+	SearchUsersByEmail(ctx context.Context, term string, limit int) ([]User, error)
+	RecentUsers(ctx context.Context, limit int) ([]User, error)
+	SignupsByDay(ctx context.Context, from, to time.Time) ([]DayCount, error)
+	FindDuplicateEmails(ctx context.Context) ([]DuplicateGroup, error)
+	MergeUsers(ctx context.Context, primaryID string, duplicateIDs []string) error
+	RunMaintenance(ctx context.Context, opts MaintenanceOptions) (MaintenanceReport, error)
+}
+
+var (
+	_ UserStore = (*Repository)(nil)
+	_ UserStore = (*MemStore)(nil)
+)
@@ -0,0 +1,46 @@
+package user
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang-cafe/job-board/internal/database"
+	"github.com/golang-cafe/job-board/internal/servertiming"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/golang-cafe/job-board/internal/user")
+
+// querySpan ends the span, releases the query deadline and records the query duration
+// for the Server-Timing header together
+type querySpan struct {
+	trace.Span
+	ctx    context.Context
+	start  time.Time
+	cancel context.CancelFunc
+}
+
+func (s querySpan) End(options ...trace.SpanEndOption) {
+	s.cancel()
+	s.Span.End(options...)
+	servertiming.RecordTiming(s.ctx, "db", time.Since(s.start))
+}
+
+// startSpan starts a client span for a repository query and applies the per query
+// timeout set with database.WithQueryTimeout, if any. The span is a no-op unless a
+// tracer provider has been registered with otel.SetTracerProvider
+func startSpan(ctx context.Context, op string) (context.Context, trace.Span) {
+	ctx, cancel := database.QueryContext(ctx)
+	ctx, span := tracer.Start(
+		ctx,
+		"user.Repository."+op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.operation", op),
+		),
+	)
+	return ctx, querySpan{Span: span, ctx: ctx, start: time.Now(), cancel: cancel}
+}
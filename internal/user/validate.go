@@ -0,0 +1,69 @@
+package user
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"unicode"
+)
+
+const (
+	maxEmailLength    = 254
+	maxEmailLocalPart = 64
+	maxUserTypeLength = 32
+)
+
+// ValidationError reports an invalid user field so handlers can show a message next to it
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Field, e.Message)
+}
+
+// ValidateEmail checks email is a single bare address (no display name or angle brackets)
+// within the RFC 5321 length limits and free of whitespace and control characters
+func ValidateEmail(email string) error {
+	invalid := func(msg string) error {
+		return &ValidationError{Field: "email", Message: msg}
+	}
+	if email == "" {
+		return invalid("cannot be empty")
+	}
+	if len(email) > maxEmailLength {
+		return invalid(fmt.Sprintf("cannot be longer than %d characters", maxEmailLength))
+	}
+	for _, r := range email {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return invalid("cannot contain spaces or control characters")
+		}
+	}
+	at := strings.LastIndex(email, "@")
+	if at <= 0 || at == len(email)-1 {
+		return invalid("must be in the form name@example.com")
+	}
+	if at > maxEmailLocalPart {
+		return invalid(fmt.Sprintf("the part before @ cannot be longer than %d characters", maxEmailLocalPart))
+	}
+	if domain := email[at+1:]; !strings.Contains(domain, ".") || strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") {
+		return invalid("must have a valid domain")
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return invalid("must be in the form name@example.com")
+	}
+	return nil
+}
+
+// ValidateUser checks the fields of u that are written to the users table
+func ValidateUser(u User) error {
+	if err := ValidateEmail(u.Email); err != nil {
+		return err
+	}
+	if len(u.Type) > maxUserTypeLength {
+		return &ValidationError{Field: "type", Message: fmt.Sprintf("cannot be longer than %d characters", maxUserTypeLength)}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package user
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateEmail(t *testing.T) {
+	tests := []struct {
+		name    string
+		email   string
+		wantErr bool
+	}{
+		{"plain address", "jane@example.com", false},
+		{"plus tag and subdomain", "jane.doe+jobs@mail.example.co.uk", false},
+		{"empty", "", true},
+		{"missing @", "jane.example.com", true},
+		{"nothing before @", "@example.com", true},
+		{"nothing after @", "jane@", true},
+		{"domain without a dot", "jane@localhost", true},
+		{"domain ending in a dot", "jane@example.", true},
+		{"space inside", "jane doe@example.com", true},
+		{"leading space", " jane@example.com", true},
+		{"control character", "jane\x00@example.com", true},
+		{"display name", "Jane <jane@example.com>", true},
+		{"local part too long", strings.Repeat("a", maxEmailLocalPart+1) + "@example.com", true},
+		{"address too long", "jane@" + strings.Repeat("a", maxEmailLength) + ".com", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateEmail(tt.email)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateEmail(%q) = %v, want error %v", tt.email, err, tt.wantErr)
+			}
+			var verr *ValidationError
+			if err != nil && (!errors.As(err, &verr) || verr.Field != "email") {
+				t.Errorf("ValidateEmail(%q) = %#v, want a ValidationError for email", tt.email, err)
+			}
+		})
+	}
+}
+
+func TestValidateUser(t *testing.T) {
+	tests := []struct {
+		name      string
+		user      User
+		wantField string
+	}{
+		{"valid", User{Email: "jane@example.com", Type: "developer"}, ""},
+		{"invalid email", User{Email: "jane", Type: "developer"}, "email"},
+		{"type too long", User{Email: "jane@example.com", Type: strings.Repeat("x", maxUserTypeLength+1)}, "type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateUser(tt.user)
+			var verr *ValidationError
+			switch {
+			case tt.wantField == "" && err != nil:
+				t.Errorf("ValidateUser() = %v, want nil", err)
+			case tt.wantField != "" && (!errors.As(err, &verr) || verr.Field != tt.wantField):
+				t.Errorf("ValidateUser() = %v, want a ValidationError for %s", err, tt.wantField)
+			}
+		})
+	}
+}
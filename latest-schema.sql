@@ -852,4 +852,86 @@ ALTER TABLE ONLY public.users ADD COLUMN access_token VARCHAR
 ALTER TABLE ONLY public.users ADD COLUMN expiration_time TIMESTAMP
 ALTER TABLE ONLY public.users ADD COLUMN created_at TIMESTAMP DEFAULT NOW();
 ALTER TABLE ONLY public.users ALTER COLUMN id TYPE VARCHAR;
-ALTER TABLE ONLY public.users ADD COLUMN refresh_token VARCHAR;
\ No newline at end of file
+ALTER TABLE ONLY public.users ADD COLUMN refresh_token VARCHAR;
+CREATE TABLE IF NOT EXISTS public.user_flags (
+    user_id VARCHAR NOT NULL,
+    flag VARCHAR(64) NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT false,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, flag)
+);
+
+CREATE TABLE IF NOT EXISTS public.sessions (
+    id VARCHAR(64) NOT NULL,
+    data JSONB NOT NULL DEFAULT '{}',
+    max_age INTEGER,
+    expires_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (id)
+);
+CREATE INDEX sessions_expires_at_idx ON public.sessions (expires_at);
+
+CREATE INDEX job_search_idx ON job USING GIN ((setweight(to_tsvector('english', job_title), 'A') || setweight(to_tsvector('english', company), 'B') || setweight(to_tsvector('english', description), 'C')));
+
+CREATE INDEX IF NOT EXISTS users_created_at_id_idx ON public.users (created_at DESC, id DESC);
+
+ALTER TABLE ONLY public.user_sign_on_token ALTER COLUMN token TYPE VARCHAR(128);
+ALTER TABLE ONLY public.users ADD COLUMN preferred_currency CHAR(3);
+
+ALTER TABLE ONLY public.users ADD COLUMN token_version INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE ONLY public.users ADD COLUMN force_reauth_at TIMESTAMPTZ;
+CREATE TABLE IF NOT EXISTS public.user_audit_log (
+    id SERIAL PRIMARY KEY,
+    user_id VARCHAR NOT NULL,
+    action VARCHAR(64) NOT NULL,
+    old_value VARCHAR,
+    new_value VARCHAR,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS user_audit_log_user_id_idx ON public.user_audit_log (user_id, created_at DESC);
+
+ALTER TABLE ONLY public.users ADD COLUMN deleted_at TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS public.job_bookmarks (
+    user_id VARCHAR NOT NULL,
+    job_id VARCHAR(28) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (user_id, job_id)
+);
+
+CREATE TABLE IF NOT EXISTS public.account_deletion_token (
+    token VARCHAR(128) NOT NULL PRIMARY KEY,
+    user_id VARCHAR NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS recruiter_profile_email_idx ON public.recruiter_profile (email);
+
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX IF NOT EXISTS users_email_trgm_idx ON public.users USING gin (email gin_trgm_ops);
+
+CREATE TABLE IF NOT EXISTS public.user_identities (
+    user_id VARCHAR NOT NULL,
+    provider VARCHAR(32) NOT NULL,
+    provider_user_id VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (provider, provider_user_id)
+);
+CREATE INDEX IF NOT EXISTS user_identities_user_id_idx ON public.user_identities (user_id);
+-- only firebase users, whose id is their firebase uid: magic link users have 27 character ksuid
+-- ids and never held firebase tokens
+INSERT INTO public.user_identities (user_id, provider, provider_user_id, created_at)
+    SELECT id, 'firebase', id, COALESCE(created_at, NOW()) FROM public.users
+    WHERE LENGTH(id) <> 27 OR access_token IS NOT NULL OR refresh_token IS NOT NULL
+    ON CONFLICT DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS public.beta_access_request (
+    email VARCHAR(255) NOT NULL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE ONLY public.user_sign_on_token ADD COLUMN remember BOOLEAN NOT NULL DEFAULT false;
+
+CREATE INDEX IF NOT EXISTS developer_profile_lower_email_idx ON public.developer_profile (LOWER(email));
+CREATE INDEX IF NOT EXISTS recruiter_profile_lower_email_idx ON public.recruiter_profile (LOWER(email));
+
+ALTER TABLE ONLY public.user_audit_log ADD COLUMN actor_id VARCHAR;